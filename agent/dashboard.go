@@ -0,0 +1,166 @@
+package agent
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/ganlvtech/go-game-matching/matcher"
+)
+
+// 监控页面使用的接口
+
+type GridData struct {
+	TimeGroupLen  int            `json:"time_group_len"`
+	ScoreGroupLen int            `json:"score_group_len"`
+	Cells         [][]int        `json:"cells"` // [时间分段][分数分段] 仍在匹配的人数，时间分段按加入时间从早到晚，最后一列包含当前时间
+	Bands         []GridBandData `json:"bands"`
+}
+
+// 分段的概况，平均已等待时间远大于预计等待时间，或者加入的人很多但匹配成功的很少，说明这个分段很难匹配
+type GridBandData struct {
+	Band              int     `json:"band"`
+	MinScore          int     `json:"min_score"`
+	Queued            int     `json:"queued"`  // 仍在匹配的人数
+	Joined            int     `json:"joined"`  // 累计加入人数
+	Matched           int     `json:"matched"` // 累计匹配成功人数
+	EstimatedWaitTime int     `json:"estimated_wait_time"`
+	WaitTimeRange     []int   `json:"wait_time_range"`
+	AverageAge        float64 `json:"average_age"` // 仍在匹配的玩家平均已等待时间
+	MaxAge            int     `json:"max_age"`
+}
+
+type ScoreRadiusSample struct {
+	ElapsedTime int `json:"elapsed_time"`
+	ScoreRadius int `json:"score_radius"`
+}
+
+type GroupData struct {
+	Id                uint64                `json:"id"`
+	StandardDeviation float64               `json:"standard_deviation"`
+	Players           []MatcherPlayerDetail `json:"players"`
+}
+
+// 玩家的加入时间为相对于 now 的时间，负数表示之前
+func newGroupData(g *matcher.Group, now matcher.Time) GroupData {
+	data := GroupData{
+		Id:                uint64(g.Id),
+		StandardDeviation: g.StandardDeviation(),
+		Players:           make([]MatcherPlayerDetail, len(g.Players)),
+	}
+	for i, p := range g.Players {
+		data.Players[i] = MatcherPlayerDetail{Id: string(p.Id), JoinTime: int(p.JoinTime - now), Score: int(p.Score)}
+	}
+	return data
+}
+
+// 二维表按时间循环使用，这里把当前时间所在的列转到最后
+func (s *HttpMatchingServer) HandleGrid(ctx *fasthttp.RequestCtx) {
+	now := s.Now()
+	s.mu.Lock()
+	counts := s.Matcher.GridCellCounts()
+	current := s.Matcher.GridColumn(now)
+	summaries := s.Matcher.BandSummaries(now)
+	data := &GridData{
+		TimeGroupLen:  s.Matcher.TimeGroupLen(),
+		ScoreGroupLen: s.Matcher.ScoreGroupLen(),
+		Cells:         make([][]int, len(counts)),
+		Bands:         make([]GridBandData, len(summaries)),
+	}
+	s.mu.Unlock()
+	for i, b := range summaries {
+		data.Bands[i] = GridBandData{
+			Band:              b.Band,
+			MinScore:          b.Band * data.ScoreGroupLen,
+			Queued:            b.Queued,
+			Joined:            b.Joined,
+			Matched:           b.Matched,
+			EstimatedWaitTime: int(b.EstimatedWaitTime.Expected),
+			WaitTimeRange:     []int{int(b.EstimatedWaitTime.Low), int(b.EstimatedWaitTime.High)},
+			AverageAge:        b.AverageAge,
+			MaxAge:            int(b.MaxAge),
+		}
+	}
+	for k := range data.Cells {
+		data.Cells[k] = counts[(current+1+k)%len(counts)]
+	}
+	writeJsonResponseOKWithData(ctx, data)
+}
+
+// 分数容忍区间随等待时间的变化，从 0 到最长等待时间取 points 个点，默认 61 个
+// 启用自适应分数容忍区间时为调整之前的曲线
+func (s *HttpMatchingServer) HandleScoreRadius(ctx *fasthttp.RequestCtx) {
+	points := 61
+	if p := ctx.Request.URI().QueryArgs().Peek("points"); len(p) > 0 {
+		n, err := strconv.Atoi(string(p))
+		if err != nil || n < 2 {
+			atomic.AddInt64(&s.Stats.BadRequestCount, 1)
+			ctx.SetStatusCode(http.StatusBadRequest)
+			return
+		}
+		points = n
+	}
+	s.mu.Lock()
+	maxTime := s.Matcher.MaxTime()
+	data := make([]ScoreRadiusSample, points)
+	for i := range data {
+		t := maxTime * matcher.Time(i) / matcher.Time(points-1)
+		data[i] = ScoreRadiusSample{ElapsedTime: int(t), ScoreRadius: int(s.Matcher.ScoreRadiusFunc(t))}
+	}
+	s.mu.Unlock()
+	writeJsonResponseOKWithData(ctx, data)
+}
+
+// 最近匹配成功的 limit 个小组，默认 100 个，按小组 id 从新到旧
+func (s *HttpMatchingServer) HandleGroups(ctx *fasthttp.RequestCtx) {
+	limit := 100
+	if l := ctx.Request.URI().QueryArgs().Peek("limit"); len(l) > 0 {
+		n, err := strconv.Atoi(string(l))
+		if err != nil || n < 0 {
+			atomic.AddInt64(&s.Stats.BadRequestCount, 1)
+			ctx.SetStatusCode(http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	now := s.Now()
+	s.mu.Lock()
+	groups := append([]*matcher.Group(nil), s.Matcher.Groups()...)
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Id > groups[j].Id
+	})
+	if len(groups) > limit {
+		groups = groups[:limit]
+	}
+	data := make([]GroupData, len(groups))
+	for i, g := range groups {
+		data[i] = newGroupData(g, now)
+	}
+	s.mu.Unlock()
+	writeJsonResponseOKWithData(ctx, data)
+}
+
+// 解散小组，没有被删除的玩家回到队列
+func (s *HttpMatchingServer) HandleDisband(ctx *fasthttp.RequestCtx) {
+	id, err := strconv.ParseUint(string(ctx.Request.URI().QueryArgs().Peek("group_id")), 10, 64)
+	if err != nil {
+		atomic.AddInt64(&s.Stats.BadRequestCount, 1)
+		ctx.SetStatusCode(http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	err = s.apply(&matcher.Operation{Type: matcher.OperationDisband, GroupId: matcher.GroupId(id)})
+	s.mu.Unlock()
+	if err != nil {
+		log.Println(ctx.RemoteIP(), ctx.RequestURI(), err)
+		atomic.AddInt64(&s.Stats.ErrorCount, 1)
+		writeJsonResponseError(ctx, 8, err)
+		return
+	}
+	log.Println("Group disbanded:", id)
+	writeJsonResponseOK(ctx)
+}
@@ -0,0 +1,68 @@
+package agent_test
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	json "github.com/json-iterator/go"
+
+	"github.com/ganlvtech/go-game-matching/agent"
+	"github.com/ganlvtech/go-game-matching/matcher"
+)
+
+func TestHttpMatchingServer_Dashboard(t *testing.T) {
+	s := agent.NewHttpMatchingServer(180, 300, 10)
+	clock := matcher.NewVirtualClock(time.Unix(1600000000, 0))
+	s.SetClock(clock)
+	for i := 0; i < 3; i++ {
+		request(s, "/join?id="+strconv.Itoa(i)+"&score=100")
+		clock.Advance(time.Second)
+	}
+	s.Match(s.Now(), 2)
+
+	grid := &struct {
+		Code int            `json:"code"`
+		Data agent.GridData `json:"data"`
+	}{}
+	_ = json.Unmarshal(request(s, "/grid").Response.Body(), grid)
+	cells := grid.Data.Cells
+	if len(cells) == 0 || cells[len(cells)-1][10] != 1 || grid.Data.ScoreGroupLen != 10 {
+		t.Errorf("grid %+v", grid.Data)
+	}
+	band := grid.Data.Bands[10]
+	if band.MinScore != 100 || band.Queued != 1 || band.Joined != 3 || band.Matched != 2 || band.MaxAge <= 0 || band.AverageAge != float64(band.MaxAge) {
+		t.Errorf("band %+v", band)
+	}
+	if grid.Data.Bands[11].Queued != 0 || grid.Data.Bands[11].AverageAge != 0 {
+		t.Errorf("empty band %+v", grid.Data.Bands[11])
+	}
+
+	groups := &struct {
+		Code int               `json:"code"`
+		Data []agent.GroupData `json:"data"`
+	}{}
+	_ = json.Unmarshal(request(s, "/groups").Response.Body(), groups)
+	if len(groups.Data) != 1 || len(groups.Data[0].Players) != 2 {
+		t.Fatalf("groups %+v", groups.Data)
+	}
+
+	resp := &agent.HttpJsonResponse{}
+	_ = json.Unmarshal(request(s, "/admin/disband?group_id="+strconv.FormatUint(groups.Data[0].Id, 10)).Response.Body(), resp)
+	if resp.Code != 0 || s.Matcher.GroupCount() != 0 || s.Matcher.PlayerInQueueCount() != 3 {
+		t.Errorf("disband: %+v, %d groups", resp, s.Matcher.GroupCount())
+	}
+	_ = json.Unmarshal(request(s, "/admin/disband?group_id="+strconv.FormatUint(groups.Data[0].Id, 10)).Response.Body(), resp)
+	if resp.Code != 8 {
+		t.Errorf("disband twice: %+v", resp)
+	}
+
+	radius := &struct {
+		Code int                       `json:"code"`
+		Data []agent.ScoreRadiusSample `json:"data"`
+	}{}
+	_ = json.Unmarshal(request(s, "/score_radius?points=4").Response.Body(), radius)
+	if len(radius.Data) != 4 || radius.Data[3].ElapsedTime != 180 || radius.Data[0].ScoreRadius >= radius.Data[3].ScoreRadius {
+		t.Errorf("score radius %+v", radius.Data)
+	}
+}
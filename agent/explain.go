@@ -0,0 +1,102 @@
+package agent
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/ganlvtech/go-game-matching/matcher"
+)
+
+type ExplainCellData struct {
+	Column   int `json:"column"` // 与 /grid 的 cells 下标相同，最后一列包含当前时间
+	Band     int `json:"band"`
+	MinScore int `json:"min_score"`
+	Players  int `json:"players"`
+	Eligible int `json:"eligible"`
+}
+
+// 各原因排除的玩家数，见 matcher.ExclusionOutOfRadius 等
+type ExplainExcludedData struct {
+	OutOfRadius     int `json:"out_of_radius"`
+	BandGranularity int `json:"band_granularity"`
+	PendingAccept   int `json:"pending_accept"`
+	JoinedLater     int `json:"joined_later"`
+}
+
+type ExplainData struct {
+	Id               string              `json:"id"`
+	Score            int                 `json:"score"`
+	Band             int                 `json:"band"`
+	ElapsedTime      int                 `json:"elapsed_time"`
+	ScoreRadius      int                 `json:"score_radius"`
+	ScoreRange       []int               `json:"score_range"` // 扫描的分数范围，包含两端
+	ScannedCellCount int                 `json:"scanned_cell_count"`
+	Cells            []ExplainCellData   `json:"cells"`
+	Eligible         int                 `json:"eligible"`
+	Needed           int                 `json:"needed"`
+	Excluded         ExplainExcludedData `json:"excluded"`
+	MatchInProgress  bool                `json:"match_in_progress"`
+}
+
+// /explain?id=&count=
+// 解释正在匹配的玩家为什么还没有匹配成功，count 为每组人数，默认为最近一次 Match 的人数
+func (s *HttpMatchingServer) HandleExplain(ctx *fasthttp.RequestCtx) {
+	args := ctx.Request.URI().QueryArgs()
+	id := matcher.PlayerId(args.Peek("id"))
+	count := 0
+	if c := args.Peek("count"); len(c) > 0 {
+		n, err := strconv.Atoi(string(c))
+		if err != nil || n < 1 {
+			atomic.AddInt64(&s.Stats.BadRequestCount, 1)
+			ctx.SetStatusCode(http.StatusBadRequest)
+			return
+		}
+		count = n
+	}
+	now := s.Now()
+	s.mu.Lock()
+	e, err := s.Matcher.Explain(id, now, count)
+	current := s.Matcher.GridColumn(now)
+	columns := s.Matcher.GridColumnCount()
+	scoreGroupLen := s.Matcher.ScoreGroupLen()
+	s.mu.Unlock()
+	if err != nil {
+		log.Println(ctx.RemoteIP(), ctx.RequestURI(), err)
+		atomic.AddInt64(&s.Stats.ErrorCount, 1)
+		writeJsonResponseError(ctx, 9, err)
+		return
+	}
+	data := &ExplainData{
+		Id:               string(e.Id),
+		Score:            int(e.Score),
+		Band:             e.Band,
+		ElapsedTime:      int(e.ElapsedTime),
+		ScoreRadius:      int(e.ScoreRadius),
+		ScoreRange:       []int{e.MinBand * scoreGroupLen, (e.MaxBand+1)*scoreGroupLen - 1},
+		ScannedCellCount: e.ScannedCellCount,
+		Cells:            make([]ExplainCellData, len(e.Cells)),
+		Eligible:         e.Eligible,
+		Needed:           e.Needed,
+		Excluded: ExplainExcludedData{
+			OutOfRadius:     e.Excluded[matcher.ExclusionOutOfRadius],
+			BandGranularity: e.Excluded[matcher.ExclusionBandGranularity],
+			PendingAccept:   e.Excluded[matcher.ExclusionPendingAccept],
+			JoinedLater:     e.Excluded[matcher.ExclusionJoinedLater],
+		},
+		MatchInProgress: e.MatchInProgress,
+	}
+	for i, c := range e.Cells {
+		data.Cells[i] = ExplainCellData{
+			Column:   (c.Column - current - 1 + 2*columns) % columns,
+			Band:     c.Band,
+			MinScore: c.Band * scoreGroupLen,
+			Players:  c.Players,
+			Eligible: c.Eligible,
+		}
+	}
+	writeJsonResponseOKWithData(ctx, data)
+}
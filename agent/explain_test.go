@@ -0,0 +1,50 @@
+package agent_test
+
+import (
+	"testing"
+	"time"
+
+	json "github.com/json-iterator/go"
+
+	"github.com/ganlvtech/go-game-matching/agent"
+	"github.com/ganlvtech/go-game-matching/matcher"
+)
+
+func TestHttpMatchingServer_Explain(t *testing.T) {
+	s := agent.NewHttpMatchingServer(180, 300, 10)
+	clock := matcher.NewVirtualClock(time.Unix(1600000000, 0))
+	s.SetClock(clock)
+	s.Matcher.RequireAccept = true
+	// e 和 f 组成的小组等待确认
+	request(s, "/join?id=e&score=102")
+	request(s, "/join?id=f&score=103")
+	s.Match(s.Now(), 2)
+	request(s, "/join?id=a&score=100")
+	request(s, "/join?id=b&score=105")
+	request(s, "/join?id=c&score=290")
+	clock.Advance(time.Second)
+
+	resp := &struct {
+		Code int               `json:"code"`
+		Data agent.ExplainData `json:"data"`
+	}{}
+	_ = json.Unmarshal(request(s, "/explain?id=a&count=3").Response.Body(), resp)
+	e := resp.Data
+	if resp.Code != 0 || e.ElapsedTime != 1 || e.Eligible != 1 || e.Needed != 2 || e.Excluded.OutOfRadius != 1 || e.Excluded.PendingAccept != 2 || e.Excluded.JoinedLater != 0 {
+		t.Errorf("explain %+v", resp)
+	}
+	if len(e.Cells) != 1 || e.Cells[0].Band != 10 || e.Cells[0].Players != 1 || e.Cells[0].Eligible != 1 {
+		t.Errorf("cells %+v", e.Cells)
+	}
+	if s.Matcher.PlayerInQueueCount() != 3 {
+		t.Error("explain changed the matcher")
+	}
+
+	_ = json.Unmarshal(request(s, "/explain?id=x").Response.Body(), resp)
+	if resp.Code != 9 {
+		t.Errorf("explain player not exists: %+v", resp)
+	}
+	if ctx := request(s, "/explain?id=a&count=0"); ctx.Response.StatusCode() != 400 {
+		t.Errorf("status %d", ctx.Response.StatusCode())
+	}
+}
@@ -0,0 +1,181 @@
+package agent
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/ganlvtech/go-game-matching/matcher"
+)
+
+// 默认保存最近多少次 Match 的采样，每秒匹配一次时为一小时
+const DefaultHistorySize = 3600
+
+// 每次 Match 的采样，降采样时多个采样合并为一个
+type HistorySample struct {
+	Time                   int64   `json:"time"`              // 开始匹配的时间，Unix 毫秒，合并时为最后一次
+	Ticks                  int     `json:"ticks"`             // 合并的采样数
+	PlayerInQueueCount     int     `json:"player_in_queue"`   // 匹配之后仍在匹配的人数，合并时为最后一次
+	GroupCount             int     `json:"groups"`            // 匹配成功的小组数
+	MatchedCount           int     `json:"matched"`           // 匹配成功的人数
+	TimedOutCount          int     `json:"timed_out"`         // 超时的人数
+	TickDuration           float64 `json:"tick_duration"`     // 匹配耗时，单位为秒，合并时为平均值
+	MaxTickDuration        float64 `json:"max_tick_duration"` // 合并的采样中最长的匹配耗时
+	AverageWaitTime        float64 `json:"average_wait_time"` // 匹配成功的玩家的平均等待时间，以 time_unit 为单位
+	GroupStandardDeviation float64 `json:"group_standard_deviation"`
+}
+
+func newHistorySample(start time.Time, d time.Duration, m *matcher.Matcher, groups []*matcher.Group, currentTime matcher.Time, timedOut int) HistorySample {
+	sample := HistorySample{
+		Time:               start.UnixNano() / int64(time.Millisecond),
+		Ticks:              1,
+		PlayerInQueueCount: m.PlayerInQueueCount(),
+		GroupCount:         len(groups),
+		TimedOutCount:      timedOut,
+		TickDuration:       d.Seconds(),
+		MaxTickDuration:    d.Seconds(),
+	}
+	waitSum := 0.0
+	sdSum := 0.0
+	for _, g := range groups {
+		waitSum += g.AverageWaitTime(currentTime) * float64(len(g.Players))
+		sdSum += g.StandardDeviation()
+		sample.MatchedCount += len(g.Players)
+	}
+	if sample.MatchedCount > 0 {
+		sample.AverageWaitTime = waitSum / float64(sample.MatchedCount)
+	}
+	if sample.GroupCount > 0 {
+		sample.GroupStandardDeviation = sdSum / float64(sample.GroupCount)
+	}
+	return sample
+}
+
+// 合并 o，o 在 s 之后。平均等待时间按人数加权，分数标准差按小组数加权
+func (s *HistorySample) merge(o HistorySample) {
+	if s.MatchedCount+o.MatchedCount > 0 {
+		s.AverageWaitTime = (s.AverageWaitTime*float64(s.MatchedCount) + o.AverageWaitTime*float64(o.MatchedCount)) / float64(s.MatchedCount+o.MatchedCount)
+	}
+	if s.GroupCount+o.GroupCount > 0 {
+		s.GroupStandardDeviation = (s.GroupStandardDeviation*float64(s.GroupCount) + o.GroupStandardDeviation*float64(o.GroupCount)) / float64(s.GroupCount+o.GroupCount)
+	}
+	s.TickDuration = (s.TickDuration*float64(s.Ticks) + o.TickDuration*float64(o.Ticks)) / float64(s.Ticks+o.Ticks)
+	s.MaxTickDuration = math.Max(s.MaxTickDuration, o.MaxTickDuration)
+	s.Time = o.Time
+	s.Ticks += o.Ticks
+	s.PlayerInQueueCount = o.PlayerInQueueCount
+	s.GroupCount += o.GroupCount
+	s.MatchedCount += o.MatchedCount
+	s.TimedOutCount += o.TimedOutCount
+}
+
+// 固定大小的环形缓冲区，写满之后覆盖最早的采样
+type statsHistory struct {
+	mu      sync.Mutex
+	samples []HistorySample
+	next    int
+	full    bool
+}
+
+func newStatsHistory(size int) *statsHistory {
+	return &statsHistory{samples: make([]HistorySample, size)}
+}
+
+func (h *statsHistory) add(s HistorySample) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.samples) == 0 {
+		return
+	}
+	h.samples[h.next] = s
+	h.next++
+	if h.next == len(h.samples) {
+		h.next = 0
+		h.full = true
+	}
+}
+
+// 按时间顺序返回 since 之后（Unix 毫秒，包含）的采样，points 大于 0 时按时间平均分为不超过 points 段，每段合并为一个采样
+func (h *statsHistory) query(since int64, points int) []HistorySample {
+	h.mu.Lock()
+	samples := make([]HistorySample, 0, len(h.samples))
+	if h.full {
+		samples = append(samples, h.samples[h.next:]...)
+	}
+	samples = append(samples, h.samples[:h.next]...)
+	h.mu.Unlock()
+
+	first := len(samples)
+	for i, s := range samples {
+		if s.Time >= since {
+			first = i
+			break
+		}
+	}
+	samples = samples[first:]
+	if points <= 0 || len(samples) <= points {
+		return samples
+	}
+	start := samples[0].Time
+	step := (samples[len(samples)-1].Time - start) / int64(points)
+	if step <= 0 {
+		step = 1
+	}
+	result := make([]HistorySample, 0, points)
+	bucket := int64(-1)
+	for _, s := range samples {
+		b := (s.Time - start) / step
+		if b >= int64(points) {
+			b = int64(points) - 1
+		}
+		if b != bucket {
+			result = append(result, s)
+			bucket = b
+			continue
+		}
+		result[len(result)-1].merge(s)
+	}
+	return result
+}
+
+// 重新设置保存的采样数，已有的采样会被丢弃，0 表示不保存
+func (s *HttpMatchingServer) SetHistorySize(size int) {
+	s.mu.Lock()
+	s.history = newStatsHistory(size)
+	s.mu.Unlock()
+}
+
+// /stats/history?window=10m&points=300
+// window 为最近多长时间，默认为全部；points 为最多返回多少个点，默认不降采样
+func (s *HttpMatchingServer) HandleStatsHistory(ctx *fasthttp.RequestCtx) {
+	args := ctx.Request.URI().QueryArgs()
+	since := int64(math.MinInt64)
+	if window := args.Peek("window"); len(window) > 0 {
+		d, err := time.ParseDuration(string(window))
+		if err != nil {
+			atomic.AddInt64(&s.Stats.BadRequestCount, 1)
+			ctx.SetStatusCode(http.StatusBadRequest)
+			return
+		}
+		since = s.clock.Now().Add(-d).UnixNano() / int64(time.Millisecond)
+	}
+	points := 0
+	if p := args.Peek("points"); len(p) > 0 {
+		n, err := strconv.Atoi(string(p))
+		if err != nil || n < 0 {
+			atomic.AddInt64(&s.Stats.BadRequestCount, 1)
+			ctx.SetStatusCode(http.StatusBadRequest)
+			return
+		}
+		points = n
+	}
+	s.mu.Lock()
+	h := s.history
+	s.mu.Unlock()
+	writeJsonResponseOKWithData(ctx, h.query(since, points))
+}
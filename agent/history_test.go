@@ -0,0 +1,90 @@
+package agent_test
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	json "github.com/json-iterator/go"
+
+	"github.com/ganlvtech/go-game-matching/agent"
+	"github.com/ganlvtech/go-game-matching/matcher"
+)
+
+func queryHistory(t *testing.T, s *agent.HttpMatchingServer, query string) []agent.HistorySample {
+	ctx := request(s, "/stats/history"+query)
+	resp := &struct {
+		Code int                   `json:"code"`
+		Data []agent.HistorySample `json:"data"`
+	}{}
+	if err := json.Unmarshal(ctx.Response.Body(), resp); err != nil || resp.Code != 0 {
+		t.Fatalf("%s: %v %s", query, err, ctx.Response.Body())
+	}
+	return resp.Data
+}
+
+func TestHttpMatchingServer_StatsHistory(t *testing.T) {
+	s := agent.NewHttpMatchingServer(30, 300, 10)
+	clock := matcher.NewVirtualClock(time.Unix(1600000000, 0))
+	s.SetClock(clock)
+	s.SetHistorySize(100)
+	s.Matcher.ScoreRadiusFunc = func(deltaT matcher.Time) matcher.PlayerScore {
+		return 5
+	}
+
+	// 每秒 5 人加入同一分段，5 人一组；另外有 1 人一直匹配不到直到超时
+	request(s, "/join?id=lonely&score=0")
+	id := 0
+	for i := 0; i < 120; i++ {
+		for j := 0; j < 5; j++ {
+			request(s, "/join?id="+strconv.Itoa(id)+"&score=200")
+			id++
+		}
+		clock.Advance(time.Second)
+		s.Match(s.Now(), 5)
+	}
+
+	all := queryHistory(t, s, "")
+	if len(all) != 100 {
+		t.Fatalf("got %d samples", len(all))
+	}
+	for i := 1; i < len(all); i++ {
+		if all[i].Time-all[i-1].Time != 1000 {
+			t.Fatalf("samples out of order: %+v %+v", all[i-1], all[i])
+		}
+	}
+	last := all[len(all)-1]
+	// 采样时间来自虚拟时钟，耗时是真实时间
+	if last.Time != clock.Now().UnixNano()/int64(time.Millisecond) || last.TickDuration <= 0 {
+		t.Errorf("sample time %d, tick duration %v", last.Time, last.TickDuration)
+	}
+	if last.Ticks != 1 || last.GroupCount != 1 || last.MatchedCount != 5 || last.AverageWaitTime != 1 || last.PlayerInQueueCount != 0 {
+		t.Errorf("last sample %+v", last)
+	}
+
+	window := queryHistory(t, s, "?window=10s")
+	if len(window) != 11 {
+		t.Errorf("got %d samples in window", len(window))
+	}
+
+	down := queryHistory(t, s, "?points=10")
+	if len(down) > 10 || len(down) < 9 {
+		t.Fatalf("got %d downsampled samples", len(down))
+	}
+	ticks, groups, timedOut := 0, 0, 0
+	for _, sample := range down {
+		ticks += sample.Ticks
+		groups += sample.GroupCount
+		timedOut += sample.TimedOutCount
+	}
+	if ticks != 100 || groups != 100 || down[len(down)-1].Time != last.Time {
+		t.Errorf("downsampled %d ticks, %d groups", ticks, groups)
+	}
+	if timedOut != 1 {
+		t.Errorf("%d players timed out", timedOut)
+	}
+
+	if ctx := request(s, "/stats/history?points=x"); ctx.Response.StatusCode() != 400 {
+		t.Errorf("status code %d", ctx.Response.StatusCode())
+	}
+}
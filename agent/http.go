@@ -2,6 +2,7 @@ package agent
 
 import (
 	"log"
+	"net"
 	"net/http"
 	"strconv"
 	"sync"
@@ -27,11 +28,25 @@ type HttpMatchingServerStats struct {
 }
 
 type HttpMatchingServer struct {
-	Matcher       *matcher.Matcher
-	mu            sync.Mutex
-	Stats         HttpMatchingServerStats
-	lastStats     HttpMatchingServerStats
-	lastStatsTime time.Time
+	Matcher             *matcher.Matcher
+	AdaptiveScoreRadius *matcher.AdaptiveScoreRadius
+	Journal             *matcher.Journal
+	OnPromote           func() // 从节点成为主节点后调用
+	mu                  sync.Mutex
+	Stats               HttpMatchingServerStats
+	lastStats           HttpMatchingServerStats
+	lastStatsTime       time.Time
+	replicas            map[*replica]struct{} // 主节点的复制连接
+	following           int32                 // 是否为只读的从节点，原子操作
+	followConn          net.Conn
+	Scheduler           *MatchScheduler // 只用于在 /stats 中显示调度情况
+	ingest              *ingestBuffer   // 为 nil 时不使用批量写入
+	clock               matcher.Clock
+	waitTimes           atomic.Value // *waitTimeSnapshot
+	recorder            *matcher.TrafficRecorder
+	journalErr          error // 操作日志写入失败后不为 nil，拒绝之后的全部写入
+	metrics             *serverMetrics
+	history             *statsHistory
 }
 
 type HttpJsonResponse struct {
@@ -41,37 +56,118 @@ type HttpJsonResponse struct {
 }
 
 type MatchingJoinData struct {
-	WaitTime int `json:"wait_time"`
+	WaitTime      int   `json:"wait_time"`
+	WaitTimeRange []int `json:"wait_time_range"`       // 预计等待时间区间，大约一半的玩家不超过第一个值，90% 不超过第二个值
+	Provisional   bool  `json:"provisional,omitempty"` // 批量写入时请求还没有执行
+}
+
+func newMatchingJoinData(e matcher.WaitTimeEstimate) MatchingJoinData {
+	return MatchingJoinData{
+		WaitTime:      int(e.Expected),
+		WaitTimeRange: []int{int(e.Low), int(e.High)},
+	}
 }
 
 type MatchingStatusData struct {
 	Ids []matcher.PlayerId `json:"ids"`
 }
 
+type MatchingPlayerStatusData struct {
+	Id                string             `json:"id"`
+	State             string             `json:"state"`
+	Score             int                `json:"score"`
+	JoinTime          int                `json:"join_time"`
+	ElapsedTime       int                `json:"elapsed_time"`
+	ScoreRadius       int                `json:"score_radius,omitempty"`
+	EstimatedWaitTime int                `json:"estimated_wait_time"`
+	BandPosition      int                `json:"band_position,omitempty"`
+	BandCount         int                `json:"band_count,omitempty"`
+	GroupId           uint64             `json:"group_id,omitempty"`
+	Ids               []matcher.PlayerId `json:"ids,omitempty"`
+}
+
 type MatcherStatsData struct {
-	PlayerCount            int     `json:"player_count"`
-	PlayerInQueueCount     int     `json:"player_in_queue_count"`
-	PlayerNotRemovedCount  int     `json:"player_not_removed_count"`
-	GroupCount             int     `json:"group_count"`
-	GroupStandardDeviation float64 `json:"group_standard_deviation"`
-	AverageWaitTime        float64 `json:"average_wait_time"`
-	ServerRunningTime      float64 `json:"server_running_time"`
-	JoinRequestCount       int     `json:"join_request_count"`
-	StatusRequestCount     int     `json:"status_request_count"`
-	LeaveRequestCount      int     `json:"leave_request_count"`
-	RemoveRequestCount     int     `json:"remove_request_count"`
-	BadRequestCount        int     `json:"bad_request_count"`
-	ErrorCount             int     `json:"error_count"`
-	JoinOKCount            int     `json:"join_ok_count"`
-	GetStatusOKCount       int     `json:"get_status_ok_count"`
-	JoinRequestQPS         float64 `json:"join_request_qps"`
-	StatusRequestQPS       float64 `json:"status_request_qps"`
-	LeaveRequestQPS        float64 `json:"leave_request_qps"`
-	RemoveRequestQPS       float64 `json:"remove_request_qps"`
-	BadRequestQPS          float64 `json:"bad_request_qps"`
-	ErrorQPS               float64 `json:"error_qps"`
-	JoinOKQPS              float64 `json:"join_ok_qps"`
-	GetStatusOKQPS         float64 `json:"get_status_ok_qps"`
+	Role                   string               `json:"role"`
+	TimeUnit               string               `json:"time_unit"` // 其他接口中时间的单位，例如 1s 1ms
+	Estimator              string               `json:"estimator"`
+	PlayerCount            int                  `json:"player_count"`
+	PlayerInQueueCount     int                  `json:"player_in_queue_count"`
+	PlayerNotRemovedCount  int                  `json:"player_not_removed_count"`
+	GroupCount             int                  `json:"group_count"`
+	GroupStandardDeviation float64              `json:"group_standard_deviation"`
+	AverageWaitTime        float64              `json:"average_wait_time"`
+	ServerRunningTime      float64              `json:"server_running_time"`
+	JoinRequestCount       int                  `json:"join_request_count"`
+	StatusRequestCount     int                  `json:"status_request_count"`
+	LeaveRequestCount      int                  `json:"leave_request_count"`
+	RemoveRequestCount     int                  `json:"remove_request_count"`
+	BadRequestCount        int                  `json:"bad_request_count"`
+	ErrorCount             int                  `json:"error_count"`
+	MatchInProgress        bool                 `json:"match_in_progress"` // 上一次 Match 因为预算用完停在了队列中间
+	Scheduler              *MatchSchedulerStats `json:"scheduler,omitempty"`
+	JoinOKCount            int                  `json:"join_ok_count"`
+	GetStatusOKCount       int                  `json:"get_status_ok_count"`
+	JoinRequestQPS         float64              `json:"join_request_qps"`
+	StatusRequestQPS       float64              `json:"status_request_qps"`
+	LeaveRequestQPS        float64              `json:"leave_request_qps"`
+	RemoveRequestQPS       float64              `json:"remove_request_qps"`
+	BadRequestQPS          float64              `json:"bad_request_qps"`
+	ErrorQPS               float64              `json:"error_qps"`
+	JoinOKQPS              float64              `json:"join_ok_qps"`
+	GetStatusOKQPS         float64              `json:"get_status_ok_qps"`
+
+	AdaptiveScoreRadius []AdaptiveScoreRadiusBandStatsData `json:"adaptive_score_radius,omitempty"`
+	Prediction          *PredictionStatsData               `json:"prediction"`
+}
+
+// 加入时返回的预计等待时间与实际等待时间的比较，时间以 time_unit 为单位
+type PredictionStatsData struct {
+	Total PredictionBandStatsData   `json:"total"`
+	Bands []PredictionBandStatsData `json:"bands"`
+}
+
+type PredictionBandStatsData struct {
+	Band           int     `json:"band"`
+	Count          int     `json:"count"`
+	TimedOut       int     `json:"timed_out"`
+	Bias           float64 `json:"bias"`            // 实际减去预计的平均值，正数表示预计偏短
+	MAE            float64 `json:"mae"`             // 平均绝对误差
+	WithinExpected float64 `json:"within_expected"` // 实际不超过 wait_time 的比例
+	WithinHigh     float64 `json:"within_high"`     // 实际不超过 wait_time_range 上限的比例，应接近 0.9
+}
+
+func newPredictionBandStatsData(band int, s matcher.PredictionStats) PredictionBandStatsData {
+	return PredictionBandStatsData{
+		Band:           band,
+		Count:          s.Count,
+		TimedOut:       s.TimedOut,
+		Bias:           s.Bias(),
+		MAE:            s.MAE(),
+		WithinExpected: s.WithinExpected(),
+		WithinHigh:     s.WithinHigh(),
+	}
+}
+
+func newPredictionStatsData(stats []matcher.PredictionStats) *PredictionStatsData {
+	data := &PredictionStatsData{Bands: make([]PredictionBandStatsData, len(stats))}
+	total := matcher.PredictionStats{}
+	for i, s := range stats {
+		data.Bands[i] = newPredictionBandStatsData(i, s)
+		total.Merge(s)
+	}
+	data.Total = newPredictionBandStatsData(-1, total)
+	return data
+}
+
+type AdaptiveScoreRadiusBandStatsData struct {
+	Band              int     `json:"band"`
+	Population        int     `json:"population"`
+	ArrivalRate       float64 `json:"arrival_rate"`
+	TargetWaitTime    float64 `json:"target_wait_time"`
+	EstimatedWaitTime float64 `json:"estimated_wait_time"`
+	WaitFactor        float64 `json:"wait_factor"`
+	DensityFactor     float64 `json:"density_factor"`
+	Factor            float64 `json:"factor"`
 }
 
 type MatcherPlayerDetail struct {
@@ -88,40 +184,213 @@ type MatcherPlayerDistribute struct {
 }
 
 func NewHttpMatchingServer(maxTime matcher.Time, maxScore matcher.PlayerScore, scoreGroupLen int) *HttpMatchingServer {
+	return NewHttpMatchingServerWithTimeUnit(maxTime, maxScore, scoreGroupLen, time.Second)
+}
+
+// 接口中的时间都以 unit 为单位
+func NewHttpMatchingServerWithTimeUnit(maxTime matcher.Time, maxScore matcher.PlayerScore, scoreGroupLen int, unit time.Duration) *HttpMatchingServer {
 	s := &HttpMatchingServer{
-		Matcher: matcher.NewMatcher(maxTime, maxScore, scoreGroupLen),
+		Matcher: matcher.NewMatcherWithTimeUnit(maxTime, maxScore, scoreGroupLen, unit),
 		mu:      sync.Mutex{},
+		metrics: newServerMetrics(unit, scoreGroupLen),
+		history: newStatsHistory(DefaultHistorySize),
 	}
-	s.Stats.ServerStartTime = time.Now()
+	s.clock = matcher.SystemClock
+	s.Stats.ServerStartTime = s.clock.Now()
 	s.lastStatsTime = s.Stats.ServerStartTime
 	return s
 }
 
+// 启用自适应分数容忍区间，targetWaitTime 为各分段的目标等待时间
+func (s *HttpMatchingServer) EnableAdaptiveScoreRadius(matchCount int, targetWaitTime float64) {
+	s.mu.Lock()
+	s.AdaptiveScoreRadius = matcher.NewAdaptiveScoreRadius(s.Matcher, matchCount, targetWaitTime)
+	s.Matcher.SetAdaptiveScoreRadius(s.AdaptiveScoreRadius)
+	s.mu.Unlock()
+}
+
+// 订阅匹配器的事件，bufferSize 为缓冲区大小，缓冲区满时事件会被丢弃
+func (s *HttpMatchingServer) Subscribe(bufferSize int) *matcher.EventSubscription {
+	return s.Matcher.Events.Subscribe(bufferSize)
+}
+
 func (s *HttpMatchingServer) Match(currentTime matcher.Time, count int) {
 	s.mu.Lock()
-	s.Matcher.Match(currentTime, count)
+	s.flushIngestion()
+	_ = s.apply(&matcher.Operation{Type: matcher.OperationMatch, Time: currentTime, Count: count})
+	s.publishWaitTime()
 	s.mu.Unlock()
 }
 
+// 时间单位不会改变，不需要加锁
+func (s *HttpMatchingServer) TimeOf(t time.Time) matcher.Time {
+	return s.Matcher.TimeOf(t)
+}
+
+// 替换服务器和匹配器使用的时钟，需要在开始处理请求之前调用
+func (s *HttpMatchingServer) SetClock(c matcher.Clock) {
+	s.mu.Lock()
+	s.clock = c
+	s.Matcher.Clock = c
+	s.Stats.ServerStartTime = c.Now()
+	s.lastStatsTime = s.Stats.ServerStartTime
+	s.mu.Unlock()
+}
+
+func (s *HttpMatchingServer) Clock() matcher.Clock {
+	return s.clock
+}
+
+// 按时钟的当前时间
+func (s *HttpMatchingServer) Now() matcher.Time {
+	return s.TimeOf(s.clock.Now())
+}
+
+func (s *HttpMatchingServer) BandJoinCounts() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Matcher.BandJoinCounts()
+}
+
+func (s *HttpMatchingServer) NextRadiusCrossing(after matcher.Time) (matcher.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Matcher.NextRadiusCrossing(after)
+}
+
 func (s *HttpMatchingServer) Sweep(before matcher.Time) {
 	s.mu.Lock()
-	s.Matcher.Sweep(before)
+	_ = s.apply(&matcher.Operation{Type: matcher.OperationSweep, Time: before})
 	s.mu.Unlock()
 }
 
+// 修改匹配器状态的操作都要通过这里执行，调用前需要加锁
+// 启用操作日志时先写入日志，写入失败则不执行
+//
+// 写入失败时不知道这条操作是否已经写入了文件，match 更是已经执行过了，内存中的状态和日志可能不一致，
+// 所以之后拒绝全部写入，直到 CompactJournal 成功（快照包含内存中的全部状态）或者重启
+func (s *HttpMatchingServer) apply(op *matcher.Operation) error {
+	if s.IsFollower() {
+		return ErrFollowerReadOnly
+	}
+	if s.journalErr != nil {
+		return ErrJournalFailed
+	}
+	if op.Type == matcher.OperationMatch {
+		return s.applyMatch(op)
+	}
+	if s.Journal != nil {
+		if err := s.Journal.Append(op); err != nil {
+			s.failJournal(err)
+			return err
+		}
+	}
+	s.replicate(op)
+	return s.applyToMatcher(op)
+}
+
+// 有预算时 match 处理的人数要执行之后才知道，所以先执行再写操作日志，日志中记录实际处理的人数，重放时结果相同
+// 执行之后写入日志失败时这次 match 已经生效，从节点同样执行，但是不能再接受写入，见 apply
+// 进程在执行和写入之间崩溃时这次 match 不在日志中，重启后相当于没有执行
+func (s *HttpMatchingServer) applyMatch(op *matcher.Operation) error {
+	if s.Journal != nil {
+		op.Seq = s.Journal.Seq() + 1
+	}
+	err := s.applyToMatcher(op)
+	if s.Journal != nil {
+		if err := s.Journal.Append(op); err != nil {
+			s.failJournal(err)
+			s.replicate(op)
+			return err
+		}
+	}
+	s.replicate(op)
+	return err
+}
+
+func (s *HttpMatchingServer) failJournal(err error) {
+	log.Println("Journal append failed, rejecting writes until the journal is compacted:", err)
+	s.journalErr = err
+}
+
+// 每次 match 记录耗时和采样
+func (s *HttpMatchingServer) applyToMatcher(op *matcher.Operation) error {
+	var groups []*matcher.Group
+	var err error
+	var at, start time.Time
+	timedOut := s.Matcher.TimedOutCount()
+	if op.Type == matcher.OperationMatch {
+		// 采样时间使用服务器的时钟，耗时使用真实时间，虚拟时钟在匹配过程中不会前进
+		at = s.clock.Now()
+		start = time.Now()
+	}
+	if s.recorder != nil {
+		groups, err = s.recorder.ApplyWithGroups(s.Matcher, op)
+	} else {
+		groups, err = s.Matcher.ApplyWithGroups(op)
+	}
+	for _, g := range groups {
+		s.metrics.observeGroup("0", s.Matcher, g, op.Time)
+	}
+	if op.Type == matcher.OperationMatch {
+		d := time.Since(start)
+		s.metrics.observeTick("0", d)
+		s.history.add(newHistorySample(at, d, s.Matcher, groups, op.Time, s.Matcher.TimedOutCount()-timedOut))
+	}
+	return err
+}
+
+// 开始把执行的每个操作记录到 path，文件已存在时追加，可以用 cmd/replay 重放
+func (s *HttpMatchingServer) StartTrafficRecording(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushIngestion()
+	if s.recorder != nil {
+		if err := s.recorder.Close(); err != nil {
+			log.Println("Traffic recorder close failed:", err)
+		}
+		s.recorder = nil
+	}
+	r, err := matcher.OpenTrafficRecorder(path, s.Matcher, s.AdaptiveScoreRadius, s.clock)
+	if err != nil {
+		return err
+	}
+	s.recorder = r
+	return nil
+}
+
+func (s *HttpMatchingServer) StopTrafficRecording() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.recorder == nil {
+		return nil
+	}
+	s.flushIngestion()
+	err := s.recorder.Close()
+	s.recorder = nil
+	return err
+}
+
 func (s *HttpMatchingServer) HandleHTTP(ctx *fasthttp.RequestCtx) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Println(r)
 		}
 	}()
-	switch string(ctx.Request.URI().Path()) {
+	path := string(ctx.Request.URI().Path())
+	typ := requestType(path)
+	switch path {
 	case "/join":
 		atomic.AddInt64(&s.Stats.JoinRequestCount, 1)
 		s.HandleJoin(ctx)
 	case "/status":
 		atomic.AddInt64(&s.Stats.StatusRequestCount, 1)
 		s.HandleGetStatus(ctx)
+	case "/player_status":
+		atomic.AddInt64(&s.Stats.StatusRequestCount, 1)
+		s.HandlePlayerStatus(ctx)
+	case "/accept":
+		s.HandleAccept(ctx)
 	case "/leave":
 		atomic.AddInt64(&s.Stats.LeaveRequestCount, 1)
 		s.HandleLeave(ctx)
@@ -130,6 +399,8 @@ func (s *HttpMatchingServer) HandleHTTP(ctx *fasthttp.RequestCtx) {
 		s.HandleRemove(ctx)
 	case "/stats":
 		s.HandleStats(ctx)
+	case "/stats/history":
+		s.HandleStatsHistory(ctx)
 	case "/player_ids":
 		s.mu.Lock()
 		data := s.Matcher.PlayerIds()
@@ -154,20 +425,63 @@ func (s *HttpMatchingServer) HandleHTTP(ctx *fasthttp.RequestCtx) {
 		s.HandleGroupPlayerDetails(ctx)
 	case "/player_distribute":
 		s.HandlePlayerDistribute(ctx)
+	case "/explain":
+		s.HandleExplain(ctx)
+	case "/grid":
+		s.HandleGrid(ctx)
+	case "/score_radius":
+		s.HandleScoreRadius(ctx)
+	case "/groups":
+		s.HandleGroups(ctx)
+	case "/admin/radius_curve":
+		s.HandleRadiusCurve(ctx)
+	case "/admin/disband":
+		s.HandleDisband(ctx)
+	case "/admin/preview":
+		s.HandlePreview(ctx)
+	case "/admin/promote":
+		s.Promote()
+		writeJsonResponseOK(ctx)
+	case "/metrics":
+		s.HandleMetrics(ctx)
+	default:
+		typ = "unknown"
 	}
+	s.metrics.observeRequest(typ, ctx)
 }
 
 func (s *HttpMatchingServer) HandleJoin(ctx *fasthttp.RequestCtx) {
 	id := matcher.PlayerId(ctx.Request.URI().QueryArgs().Peek("id"))
 	score1, err := strconv.Atoi(string(ctx.Request.URI().QueryArgs().Peek("score")))
-	if err != nil {
+	if id == "" || err != nil || score1 < 0 {
 		atomic.AddInt64(&s.Stats.BadRequestCount, 1)
 		ctx.SetStatusCode(http.StatusBadRequest)
 		return
 	}
 	score := matcher.PlayerScore(score1)
+	op := &matcher.Operation{Type: matcher.OperationJoin, Id: id, Time: s.Now(), Score: score}
+	if s.ingest != nil {
+		// 缓冲区中的操作在 Match 之前才执行，能提前检查的错误在这里返回，
+		// 玩家已存在等需要加锁才能检查的错误只能在执行时计入 ErrorCount
+		if err := s.validateJoin(score); err != nil {
+			log.Println(ctx.RemoteIP(), ctx.RequestURI(), err)
+			atomic.AddInt64(&s.Stats.ErrorCount, 1)
+			writeJsonResponseError(ctx, 1, err)
+			return
+		}
+		s.ingest.push(op)
+		data := newMatchingJoinData(s.provisionalWaitTime(score))
+		data.Provisional = true
+		writeJsonResponseOKWithData(ctx, data)
+		return
+	}
+	// 分数超出范围时没有对应的分段，只在加入成功后读取预计等待时间
+	var estimate matcher.WaitTimeEstimate
 	s.mu.Lock()
-	err = s.Matcher.JoinQueue(id, matcher.Time(time.Now().Unix()), score)
+	err = s.apply(op)
+	if err == nil {
+		estimate = s.Matcher.GetWaitTimeEstimateByScore(score)
+	}
 	s.mu.Unlock()
 	if err != nil {
 		log.Println(ctx.RemoteIP(), ctx.RequestURI(), err)
@@ -175,9 +489,8 @@ func (s *HttpMatchingServer) HandleJoin(ctx *fasthttp.RequestCtx) {
 		writeJsonResponseError(ctx, 1, err)
 		return
 	}
-	waitTime := s.Matcher.GetWaitTimeByScore(score)
 	atomic.AddInt64(&s.Stats.JoinOKCount, 1)
-	writeJsonResponseOKWithData(ctx, MatchingJoinData{WaitTime: waitTime,})
+	writeJsonResponseOKWithData(ctx, newMatchingJoinData(estimate))
 }
 
 func (s *HttpMatchingServer) HandleGetStatus(ctx *fasthttp.RequestCtx) {
@@ -195,10 +508,61 @@ func (s *HttpMatchingServer) HandleGetStatus(ctx *fasthttp.RequestCtx) {
 	writeJsonResponseOKWithData(ctx, MatchingStatusData{Ids: ids})
 }
 
+func (s *HttpMatchingServer) HandlePlayerStatus(ctx *fasthttp.RequestCtx) {
+	id := matcher.PlayerId(ctx.Request.URI().QueryArgs().Peek("id"))
+	s.mu.Lock()
+	status, err := s.Matcher.GetPlayerStatus(id, s.Now())
+	s.mu.Unlock()
+	if err != nil {
+		log.Println(ctx.RemoteIP(), ctx.RequestURI(), err)
+		atomic.AddInt64(&s.Stats.ErrorCount, 1)
+		writeJsonResponseError(ctx, 5, err)
+		return
+	}
+	atomic.AddInt64(&s.Stats.GetStatusOKCount, 1)
+	writeJsonResponseOKWithData(ctx, newMatchingPlayerStatusData(status))
+}
+
+func newMatchingPlayerStatusData(status *matcher.PlayerStatus) *MatchingPlayerStatusData {
+	return &MatchingPlayerStatusData{
+		Id:                string(status.Id),
+		State:             status.State.String(),
+		Score:             int(status.Score),
+		JoinTime:          int(status.JoinTime),
+		ElapsedTime:       int(status.ElapsedTime),
+		ScoreRadius:       int(status.ScoreRadius),
+		EstimatedWaitTime: int(status.EstimatedWaitTime),
+		BandPosition:      status.BandPosition,
+		BandCount:         status.BandCount,
+		GroupId:           uint64(status.GroupId),
+		Ids:               status.PlayerIds,
+	}
+}
+
+func (s *HttpMatchingServer) HandleAccept(ctx *fasthttp.RequestCtx) {
+	id := matcher.PlayerId(ctx.Request.URI().QueryArgs().Peek("id"))
+	s.mu.Lock()
+	err := s.apply(&matcher.Operation{Type: matcher.OperationAccept, Id: id})
+	s.mu.Unlock()
+	if err != nil {
+		log.Println(ctx.RemoteIP(), ctx.RequestURI(), err)
+		atomic.AddInt64(&s.Stats.ErrorCount, 1)
+		writeJsonResponseError(ctx, 6, err)
+		return
+	}
+	writeJsonResponseOK(ctx)
+}
+
 func (s *HttpMatchingServer) HandleLeave(ctx *fasthttp.RequestCtx) {
 	id := matcher.PlayerId(ctx.Request.URI().QueryArgs().Peek("id"))
+	op := &matcher.Operation{Type: matcher.OperationLeave, Id: id}
+	if s.ingest != nil {
+		s.ingest.push(op)
+		writeJsonResponseOK(ctx)
+		return
+	}
 	s.mu.Lock()
-	err := s.Matcher.LeaveQueue(id)
+	err := s.apply(op)
 	s.mu.Unlock()
 	if err != nil {
 		log.Println(ctx.RemoteIP(), ctx.RequestURI(), err)
@@ -211,9 +575,21 @@ func (s *HttpMatchingServer) HandleLeave(ctx *fasthttp.RequestCtx) {
 
 func (s *HttpMatchingServer) HandleRemove(ctx *fasthttp.RequestCtx) {
 	id := matcher.PlayerId(ctx.Request.URI().QueryArgs().Peek("id"))
+	op := &matcher.Operation{Type: matcher.OperationRemove, Id: id}
+	if s.ingest != nil {
+		s.ingest.push(op)
+		writeJsonResponseOK(ctx)
+		return
+	}
 	s.mu.Lock()
-	s.Matcher.Remove(id)
+	err := s.apply(op)
 	s.mu.Unlock()
+	if err != nil {
+		log.Println(ctx.RemoteIP(), ctx.RequestURI(), err)
+		atomic.AddInt64(&s.Stats.ErrorCount, 1)
+		writeJsonResponseError(ctx, 7, err)
+		return
+	}
 	writeJsonResponseOK(ctx)
 }
 
@@ -226,9 +602,34 @@ func (s *HttpMatchingServer) HandleStats(ctx *fasthttp.RequestCtx) {
 		GroupCount:             s.Matcher.GroupCount(),
 		GroupStandardDeviation: s.Matcher.GroupStandardDeviation(),
 		AverageWaitTime:        s.Matcher.AverageWaitTime(),
+		MatchInProgress:        s.Matcher.MatchInProgress(),
+		Role:                   "primary",
+		TimeUnit:               s.Matcher.TimeUnit().String(),
+		Estimator:              s.Matcher.Estimator().Name(),
+		Prediction:             newPredictionStatsData(s.Matcher.PredictionStats()),
+	}
+	if s.IsFollower() {
+		data.Role = "follower"
+	}
+	if s.Scheduler != nil {
+		data.Scheduler = s.Scheduler.StatsData()
+	}
+	if s.AdaptiveScoreRadius != nil {
+		for _, b := range s.AdaptiveScoreRadius.Stats() {
+			data.AdaptiveScoreRadius = append(data.AdaptiveScoreRadius, AdaptiveScoreRadiusBandStatsData{
+				Band:              b.Band,
+				Population:        b.Population,
+				ArrivalRate:       b.ArrivalRate,
+				TargetWaitTime:    b.TargetWaitTime,
+				EstimatedWaitTime: b.EstimatedWaitTime,
+				WaitFactor:        b.WaitFactor,
+				DensityFactor:     b.DensityFactor,
+				Factor:            b.Factor,
+			})
+		}
 	}
 	s.mu.Unlock()
-	now := time.Now()
+	now := s.clock.Now()
 	data.ServerRunningTime = now.Sub(s.Stats.ServerStartTime).Seconds()
 	data.JoinRequestCount = int(s.Stats.JoinRequestCount)
 	data.StatusRequestCount = int(s.Stats.StatusRequestCount)
@@ -258,8 +659,16 @@ func (s *HttpMatchingServer) HandleStats(ctx *fasthttp.RequestCtx) {
 	s.lastStatsTime = now
 }
 
+// Prometheus 文本格式，计数器只增不减，不像 /stats 的 QPS 那样受其他调用者影响
+func (s *HttpMatchingServer) HandleMetrics(ctx *fasthttp.RequestCtx) {
+	s.mu.Lock()
+	gauges := []queueGauges{newQueueGauges("0", s.Matcher)}
+	s.mu.Unlock()
+	writeMetricsResponse(ctx, s.metrics, gauges)
+}
+
 func (s *HttpMatchingServer) HandleGroupPlayerDetails(ctx *fasthttp.RequestCtx) {
-	now := int(time.Now().Unix())
+	now := int(s.Now())
 	s.mu.Lock()
 	groups := s.Matcher.Groups()
 	r := make([][]MatcherPlayerDetail, len(groups))
@@ -276,7 +685,7 @@ func (s *HttpMatchingServer) HandleGroupPlayerDetails(ctx *fasthttp.RequestCtx)
 }
 
 func (s *HttpMatchingServer) HandlePlayerDistribute(ctx *fasthttp.RequestCtx) {
-	now := int(time.Now().Unix())
+	now := int(s.Now())
 	s.mu.Lock()
 	groupIndexMap := make(map[*matcher.Group]int, s.Matcher.GroupCount())
 	for i, g := range s.Matcher.Groups() {
@@ -299,12 +708,42 @@ func (s *HttpMatchingServer) HandlePlayerDistribute(ctx *fasthttp.RequestCtx) {
 	writeJsonResponseOKWithData(ctx, r)
 }
 
+// GET 返回当前的分数容忍区间曲线，POST 或者带 curve 参数则替换为新的曲线
+func (s *HttpMatchingServer) HandleRadiusCurve(ctx *fasthttp.RequestCtx) {
+	spec := ctx.Request.URI().QueryArgs().Peek("curve")
+	if ctx.IsPost() {
+		spec = ctx.PostBody()
+	}
+	if len(spec) == 0 {
+		s.mu.Lock()
+		c := s.Matcher.RadiusCurve()
+		s.mu.Unlock()
+		writeJsonResponseOKWithData(ctx, c)
+		return
+	}
+	c, err := matcher.ParseRadiusCurve(string(spec))
+	if err == nil {
+		s.mu.Lock()
+		err = s.apply(&matcher.Operation{Type: matcher.OperationRadiusCurve, RadiusCurve: c})
+		s.mu.Unlock()
+	}
+	if err != nil {
+		log.Println(ctx.RemoteIP(), ctx.RequestURI(), err)
+		atomic.AddInt64(&s.Stats.ErrorCount, 1)
+		writeJsonResponseError(ctx, 4, err)
+		return
+	}
+	log.Println("Radius curve changed:", c)
+	writeJsonResponseOKWithData(ctx, c)
+}
+
 func writeJsonResponse(ctx *fasthttp.RequestCtx, v interface{}) {
 	ctx.SetContentType("application/json")
 	_ = json.NewEncoder(ctx).Encode(v)
 }
 
 func writeJsonResponseError(ctx *fasthttp.RequestCtx, code int, err error) {
+	ctx.SetUserValue(responseCodeKey, code)
 	writeJsonResponse(ctx, &HttpJsonResponse{
 		Code: code,
 		Msg:  err.Error(),
@@ -316,6 +755,7 @@ func writeJsonResponseOK(ctx *fasthttp.RequestCtx) {
 }
 
 func writeJsonResponseOKWithData(ctx *fasthttp.RequestCtx, data interface{}) {
+	ctx.SetUserValue(responseCodeKey, 0)
 	ctx.SetContentType("application/json")
 	_ = json.NewEncoder(ctx).Encode(&HttpJsonResponse{
 		Code: 0,
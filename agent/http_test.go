@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"math/rand"
 	"net/http"
+	"sort"
 	"strconv"
+	"sync"
 	"testing"
 	"time"
 
@@ -14,12 +16,18 @@ import (
 	"github.com/ganlvtech/go-game-matching/matcher"
 )
 
-func benchmarkHttpMatchingServer_HandleJoin(b *testing.B, concurrent int) {
+func benchmarkHttpMatchingServer_HandleJoin(b *testing.B, concurrent int, batched bool) {
 	const maxScore = 300
 	isRun := true
 	port := int(9000 + rand.Uint32()%1000)
 
 	matchingServer := agent.NewHttpMatchingServer(180, maxScore, 10)
+	if batched {
+		matchingServer.EnableBatchedIngestion()
+	}
+
+	var latenciesMu sync.Mutex
+	latencies := make([]time.Duration, 0)
 
 	go func() {
 		server := fasthttp.Server{
@@ -39,6 +47,7 @@ func benchmarkHttpMatchingServer_HandleJoin(b *testing.B, concurrent int) {
 			client := &http.Client{Transport: tr}
 			for id := base; isRun; id++ {
 				score := int(rand.Uint32()%250 + 25)
+				start := time.Now()
 				resp, err := client.Get("http://127.0.0.1:" + strconv.Itoa(port) + "/join?id=" + strconv.Itoa(id) + "&score=" + strconv.Itoa(score))
 				if err != nil {
 					panic(err)
@@ -50,6 +59,10 @@ func benchmarkHttpMatchingServer_HandleJoin(b *testing.B, concurrent int) {
 					panic(err)
 				}
 				_ = resp.Body.Close()
+				latency := time.Since(start)
+				latenciesMu.Lock()
+				latencies = append(latencies, latency)
+				latenciesMu.Unlock()
 				if r.Code != 0 {
 					panic(r.Msg)
 				}
@@ -64,12 +77,92 @@ func benchmarkHttpMatchingServer_HandleJoin(b *testing.B, concurrent int) {
 			break
 		}
 	}
+
+	latenciesMu.Lock()
+	defer latenciesMu.Unlock()
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool {
+			return latencies[i] < latencies[j]
+		})
+		b.ReportMetric(float64(latencies[len(latencies)*99/100]), "p99-ns")
+	}
 }
 
 func BenchmarkHttpMatchingServer_HandleJoin(b *testing.B) {
-	benchmarkHttpMatchingServer_HandleJoin(b, 1)
+	benchmarkHttpMatchingServer_HandleJoin(b, 1, false)
 }
 
 func BenchmarkHttpMatchingServer_HandleJoin_100(b *testing.B) {
-	benchmarkHttpMatchingServer_HandleJoin(b, 100)
+	benchmarkHttpMatchingServer_HandleJoin(b, 100, false)
+}
+
+func BenchmarkHttpMatchingServer_HandleJoin_Batched(b *testing.B) {
+	benchmarkHttpMatchingServer_HandleJoin(b, 1, true)
+}
+
+func BenchmarkHttpMatchingServer_HandleJoin_Batched_100(b *testing.B) {
+	benchmarkHttpMatchingServer_HandleJoin(b, 100, true)
+}
+
+func TestHttpMatchingServer_JoinInvalidScore(t *testing.T) {
+	servers := map[string]metricsServer{
+		"single":  agent.NewHttpMatchingServer(180, 300, 10),
+		"sharded": agent.NewHttpShardedMatchingServer(2, 180, 300, 10),
+	}
+	for name, s := range servers {
+		if ctx := request(s, "/join?id=a&score=-20"); ctx.Response.StatusCode() != http.StatusBadRequest {
+			t.Errorf("%s: negative score status %d", name, ctx.Response.StatusCode())
+		}
+		r := &agent.HttpJsonResponse{}
+		_ = json.Unmarshal(request(s, "/join?id=a&score=300").Response.Body(), r)
+		if r.Code != 1 {
+			t.Errorf("%s: score 300 response %+v", name, r)
+		}
+		// 之前的请求没有让服务器卡住
+		_ = json.Unmarshal(request(s, "/join?id=a&score=100").Response.Body(), r)
+		if r.Code != 0 {
+			t.Errorf("%s: valid join response %+v", name, r)
+		}
+	}
+}
+
+func TestHttpMatchingServer_BatchedJoin(t *testing.T) {
+	s := agent.NewHttpMatchingServer(180, 300, 10)
+	s.EnableBatchedIngestion()
+
+	// 同一批中的操作按请求顺序执行
+	request(s, "/join?id=a&score=100")
+	request(s, "/leave?id=a")
+	request(s, "/join?id=a&score=200")
+	request(s, "/join?id=b&score=100")
+	request(s, "/join?id=b&score=100")
+	s.Match(s.Now(), 4)
+	if !s.Matcher.Exists("a") || !s.Matcher.Exists("b") {
+		t.Fatal("buffered joins were not applied")
+	}
+	if s.Stats.JoinOKCount != 3 {
+		t.Errorf("JoinOKCount = %d, want 3", s.Stats.JoinOKCount)
+	}
+	if s.Stats.ErrorCount != 1 {
+		t.Errorf("ErrorCount = %d, want 1", s.Stats.ErrorCount)
+	}
+
+	// 不合法的请求不进入缓冲区
+	for _, uri := range []string{"/join?id=c&score=-20", "/join?score=100"} {
+		if ctx := request(s, uri); ctx.Response.StatusCode() != http.StatusBadRequest {
+			t.Errorf("%s: status %d", uri, ctx.Response.StatusCode())
+		}
+	}
+	r := &agent.HttpJsonResponse{}
+	_ = json.Unmarshal(request(s, "/join?id=c&score=5000").Response.Body(), r)
+	if r.Code != 1 {
+		t.Errorf("score 5000 response %+v", r)
+	}
+	s.Match(s.Now(), 4)
+	if s.Matcher.Exists("c") || s.Matcher.Exists("") {
+		t.Error("invalid join was applied")
+	}
+	if s.Stats.JoinOKCount != 3 {
+		t.Errorf("JoinOKCount = %d, want 3", s.Stats.JoinOKCount)
+	}
 }
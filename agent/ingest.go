@@ -0,0 +1,110 @@
+package agent
+
+import (
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/ganlvtech/go-game-matching/matcher"
+)
+
+// 无锁的操作缓冲区
+//
+// 加入、离开、删除请求不再和 Match 抢同一把锁，而是压入这个无锁栈后立即返回，
+// Match 之前一次性取出全部操作，按写入顺序批量执行。
+type ingestBuffer struct {
+	head unsafe.Pointer // *ingestNode
+}
+
+type ingestNode struct {
+	op   *matcher.Operation
+	next *ingestNode
+}
+
+func (b *ingestBuffer) push(op *matcher.Operation) {
+	n := &ingestNode{op: op}
+	for {
+		head := atomic.LoadPointer(&b.head)
+		n.next = (*ingestNode)(head)
+		if atomic.CompareAndSwapPointer(&b.head, head, unsafe.Pointer(n)) {
+			return
+		}
+	}
+}
+
+// 取出全部操作，按写入顺序返回
+func (b *ingestBuffer) drain() []*matcher.Operation {
+	n := (*ingestNode)(atomic.SwapPointer(&b.head, nil))
+	ops := make([]*matcher.Operation, 0)
+	for ; n != nil; n = n.next {
+		ops = append(ops, n.op)
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// 批量执行时读取的等待时间，每次 Match 之后更新，加入请求不需要加锁就能给出预计等待时间
+type waitTimeSnapshot struct {
+	maxScore      matcher.PlayerScore
+	scoreGroupLen int
+	estimates     []matcher.WaitTimeEstimate
+}
+
+// 启用批量写入，之后的加入、离开、删除请求在下一次 Match 之前才会执行
+// 加入请求返回的等待时间是上一次 Match 之后的预计值，玩家是否加入成功需要通过 /player_status 查询
+func (s *HttpMatchingServer) EnableBatchedIngestion() {
+	s.mu.Lock()
+	s.ingest = &ingestBuffer{}
+	s.publishWaitTime()
+	s.mu.Unlock()
+}
+
+// 执行缓冲区中的全部操作，调用前需要加锁
+func (s *HttpMatchingServer) flushIngestion() {
+	if s.ingest == nil {
+		return
+	}
+	for _, op := range s.ingest.drain() {
+		if err := s.apply(op); err != nil {
+			atomic.AddInt64(&s.Stats.ErrorCount, 1)
+		} else if op.Type == matcher.OperationJoin {
+			atomic.AddInt64(&s.Stats.JoinOKCount, 1)
+		}
+	}
+}
+
+// 调用前需要加锁
+func (s *HttpMatchingServer) publishWaitTime() {
+	if s.ingest == nil {
+		return
+	}
+	e := s.Matcher.Estimator()
+	estimates := make([]matcher.WaitTimeEstimate, e.GroupCount())
+	for i := range estimates {
+		estimates[i] = e.Estimate(i)
+	}
+	s.waitTimes.Store(&waitTimeSnapshot{
+		maxScore:      s.Matcher.MaxScore(),
+		scoreGroupLen: s.Matcher.ScoreGroupLen(),
+		estimates:     estimates,
+	})
+}
+
+// 不加锁检查加入请求的分数，与 JoinQueue 的检查一致
+func (s *HttpMatchingServer) validateJoin(score matcher.PlayerScore) error {
+	w := s.waitTimes.Load().(*waitTimeSnapshot)
+	if score >= w.maxScore {
+		return matcher.PlayerScoreOutOfRangeError(score)
+	}
+	return nil
+}
+
+func (s *HttpMatchingServer) provisionalWaitTime(score matcher.PlayerScore) matcher.WaitTimeEstimate {
+	w := s.waitTimes.Load().(*waitTimeSnapshot)
+	i := int(score) / w.scoreGroupLen
+	if i >= len(w.estimates) {
+		i = len(w.estimates) - 1
+	}
+	return w.estimates[i]
+}
@@ -0,0 +1,262 @@
+package agent
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/ganlvtech/go-game-matching/matcher"
+)
+
+// /metrics 输出的 Prometheus 文本格式指标
+//
+// 计数器只增不减，多个采集端互不影响。queue 标签为分片序号，不分片时为 0
+
+// 等待时间的桶，单位为秒
+var waitTimeBuckets = []float64{1, 2, 5, 10, 15, 20, 30, 45, 60, 90, 120, 180, 300, 600}
+
+// 每次 Match 耗时的桶，单位为秒
+var tickDurationBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// 小组分数标准差的桶为分段长度的倍数
+var scoreSpreadBucketFactors = []float64{0.125, 0.25, 0.5, 1, 2, 4, 8}
+
+// 写入响应时记录 JSON 中的 code，用于按错误码计数
+const responseCodeKey = "response_code"
+
+type histogram struct {
+	upperBounds []float64
+	counts      []uint64 // 每个桶单独计数，最后一个为 +Inf，输出时再累加
+	sum         float64
+	count       uint64
+}
+
+func newHistogram(upperBounds []float64) *histogram {
+	return &histogram{
+		upperBounds: upperBounds,
+		counts:      make([]uint64, len(upperBounds)+1),
+	}
+}
+
+func (h *histogram) observe(v float64) {
+	h.counts[sort.SearchFloat64s(h.upperBounds, v)]++
+	h.sum += v
+	h.count++
+}
+
+type requestKey struct {
+	typ  string
+	code string
+}
+
+type bandKey struct {
+	queue string
+	band  int
+}
+
+// 某个队列当前的状态，输出时读取
+type queueGauges struct {
+	queue              string
+	players            int
+	playersInQueue     int
+	groups             int
+	bandPlayersInQueue []int
+}
+
+func newQueueGauges(queue string, m *matcher.Matcher) queueGauges {
+	g := queueGauges{
+		queue:              queue,
+		players:            m.PlayerCount(),
+		playersInQueue:     m.PlayerInQueueCount(),
+		groups:             m.GroupCount(),
+		bandPlayersInQueue: make([]int, m.ScoreBandCount()),
+	}
+	for i := range g.bandPlayersInQueue {
+		g.bandPlayersInQueue[i] = m.BandPlayerInQueueCount(i)
+	}
+	return g
+}
+
+type serverMetrics struct {
+	mu                 sync.Mutex
+	unitSeconds        float64 // 匹配器的一个时间单位是多少秒
+	scoreSpreadBuckets []float64
+	requests           map[requestKey]uint64
+	waitTimes          map[bandKey]*histogram
+	scoreSpreads       map[bandKey]*histogram
+	ticks              map[string]*histogram
+}
+
+func newServerMetrics(unit time.Duration, scoreGroupLen int) *serverMetrics {
+	m := &serverMetrics{
+		unitSeconds:        unit.Seconds(),
+		scoreSpreadBuckets: make([]float64, len(scoreSpreadBucketFactors)),
+		requests:           make(map[requestKey]uint64),
+		waitTimes:          make(map[bandKey]*histogram),
+		scoreSpreads:       make(map[bandKey]*histogram),
+		ticks:              make(map[string]*histogram),
+	}
+	for i, f := range scoreSpreadBucketFactors {
+		m.scoreSpreadBuckets[i] = f * float64(scoreGroupLen)
+	}
+	return m
+}
+
+// 请求处理完之后调用，没有写入 JSON 响应时按 HTTP 状态码计数，例如 http_400
+func (m *serverMetrics) observeRequest(typ string, ctx *fasthttp.RequestCtx) {
+	code := "http_" + strconv.Itoa(ctx.Response.StatusCode())
+	if v, ok := ctx.UserValue(responseCodeKey).(int); ok {
+		code = strconv.Itoa(v)
+	}
+	m.mu.Lock()
+	m.requests[requestKey{typ: typ, code: code}]++
+	m.mu.Unlock()
+}
+
+func (m *serverMetrics) observeTick(queue string, d time.Duration) {
+	m.mu.Lock()
+	h, ok := m.ticks[queue]
+	if !ok {
+		h = newHistogram(tickDurationBuckets)
+		m.ticks[queue] = h
+	}
+	h.observe(d.Seconds())
+	m.mu.Unlock()
+}
+
+// 记录小组中每个玩家的等待时间和小组分数的标准差，等待时间按玩家的分段，标准差按小组平均分的分段
+func (m *serverMetrics) observeGroup(queue string, mt *matcher.Matcher, g *matcher.Group, currentTime matcher.Time) {
+	if len(g.Players) == 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sum := 0.0
+	for _, p := range g.Players {
+		key := bandKey{queue: queue, band: mt.ScoreBandIndex(p.Score)}
+		h, ok := m.waitTimes[key]
+		if !ok {
+			h = newHistogram(waitTimeBuckets)
+			m.waitTimes[key] = h
+		}
+		h.observe(float64(currentTime-p.JoinTime) * m.unitSeconds)
+		sum += float64(p.Score)
+	}
+	key := bandKey{queue: queue, band: mt.ScoreBandIndex(matcher.PlayerScore(sum / float64(len(g.Players))))}
+	h, ok := m.scoreSpreads[key]
+	if !ok {
+		h = newHistogram(m.scoreSpreadBuckets)
+		m.scoreSpreads[key] = h
+	}
+	h.observe(g.StandardDeviation())
+}
+
+func (m *serverMetrics) writeTo(w io.Writer, gauges []queueGauges) error {
+	b := bufio.NewWriter(w)
+
+	m.mu.Lock()
+	writeMetricHeader(b, "matching_requests_total", "counter", "HTTP requests by type and response code.")
+	keys := make([]requestKey, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].typ != keys[j].typ {
+			return keys[i].typ < keys[j].typ
+		}
+		return keys[i].code < keys[j].code
+	})
+	for _, k := range keys {
+		writeMetricSample(b, "matching_requests_total", `type="`+k.typ+`",code="`+k.code+`"`, float64(m.requests[k]))
+	}
+
+	writeMetricHeader(b, "matching_tick_duration_seconds", "histogram", "Duration of each match tick.")
+	queues := make([]string, 0, len(m.ticks))
+	for q := range m.ticks {
+		queues = append(queues, q)
+	}
+	sort.Strings(queues)
+	for _, q := range queues {
+		writeHistogram(b, "matching_tick_duration_seconds", `queue="`+q+`"`, m.ticks[q])
+	}
+	writeMetricHeader(b, "matching_wait_time_seconds", "histogram", "Wait time of matched players by score band.")
+	writeBandHistograms(b, "matching_wait_time_seconds", m.waitTimes)
+	writeMetricHeader(b, "matching_group_score_spread", "histogram", "Score standard deviation of matched groups by score band of the group mean.")
+	writeBandHistograms(b, "matching_group_score_spread", m.scoreSpreads)
+	m.mu.Unlock()
+
+	writeMetricHeader(b, "matching_players", "gauge", "Players known to the matcher, including matched players not yet removed.")
+	for _, g := range gauges {
+		writeMetricSample(b, "matching_players", `queue="`+g.queue+`"`, float64(g.players))
+	}
+	writeMetricHeader(b, "matching_players_in_queue", "gauge", "Players waiting to be matched.")
+	for _, g := range gauges {
+		writeMetricSample(b, "matching_players_in_queue", `queue="`+g.queue+`"`, float64(g.playersInQueue))
+	}
+	writeMetricHeader(b, "matching_band_players_in_queue", "gauge", "Players waiting to be matched by score band.")
+	for _, g := range gauges {
+		for band, n := range g.bandPlayersInQueue {
+			writeMetricSample(b, "matching_band_players_in_queue", `queue="`+g.queue+`",band="`+strconv.Itoa(band)+`"`, float64(n))
+		}
+	}
+	writeMetricHeader(b, "matching_groups", "gauge", "Matched groups not yet removed.")
+	for _, g := range gauges {
+		writeMetricSample(b, "matching_groups", `queue="`+g.queue+`"`, float64(g.groups))
+	}
+	return b.Flush()
+}
+
+func writeMetricHeader(w *bufio.Writer, name string, typ string, help string) {
+	_, _ = w.WriteString("# HELP " + name + " " + help + "\n# TYPE " + name + " " + typ + "\n")
+}
+
+func writeMetricSample(w *bufio.Writer, name string, labels string, v float64) {
+	_, _ = w.WriteString(name + "{" + labels + "} " + formatMetricValue(v) + "\n")
+}
+
+func writeHistogram(w *bufio.Writer, name string, labels string, h *histogram) {
+	cumulative := uint64(0)
+	for i, bound := range h.upperBounds {
+		cumulative += h.counts[i]
+		writeMetricSample(w, name+"_bucket", labels+`,le="`+formatMetricValue(bound)+`"`, float64(cumulative))
+	}
+	writeMetricSample(w, name+"_bucket", labels+`,le="+Inf"`, float64(h.count))
+	writeMetricSample(w, name+"_sum", labels, h.sum)
+	writeMetricSample(w, name+"_count", labels, float64(h.count))
+}
+
+func writeBandHistograms(w *bufio.Writer, name string, histograms map[bandKey]*histogram) {
+	keys := make([]bandKey, 0, len(histograms))
+	for k := range histograms {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].queue != keys[j].queue {
+			return keys[i].queue < keys[j].queue
+		}
+		return keys[i].band < keys[j].band
+	})
+	for _, k := range keys {
+		writeHistogram(w, name, `queue="`+k.queue+`",band="`+strconv.Itoa(k.band)+`"`, histograms[k])
+	}
+}
+
+func formatMetricValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// 请求类型为路径去掉开头的 /，未知的路径都记为 unknown
+func requestType(path string) string {
+	return strings.TrimPrefix(path, "/")
+}
+
+func writeMetricsResponse(ctx *fasthttp.RequestCtx, m *serverMetrics, gauges []queueGauges) {
+	ctx.SetContentType("text/plain; version=0.0.4")
+	_ = m.writeTo(ctx, gauges)
+}
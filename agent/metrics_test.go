@@ -0,0 +1,103 @@
+package agent_test
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/ganlvtech/go-game-matching/agent"
+	"github.com/ganlvtech/go-game-matching/matcher"
+)
+
+type metricsServer interface {
+	HandleHTTP(ctx *fasthttp.RequestCtx)
+	SetClock(c matcher.Clock)
+	Now() matcher.Time
+	Match(currentTime matcher.Time, count int)
+}
+
+func request(s metricsServer, uri string) *fasthttp.RequestCtx {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI(uri)
+	s.HandleHTTP(ctx)
+	return ctx
+}
+
+func TestHttpMatchingServer_Metrics(t *testing.T) {
+	servers := map[string]metricsServer{
+		"single":  agent.NewHttpMatchingServer(180, 300, 10),
+		"sharded": agent.NewHttpShardedMatchingServer(2, 180, 300, 10),
+	}
+	for name, s := range servers {
+		clock := matcher.NewVirtualClock(time.Unix(1600000000, 0))
+		s.SetClock(clock)
+		for i := 0; i < 7; i++ {
+			request(s, "/join?id="+strconv.Itoa(i)+"&score="+strconv.Itoa(100+i))
+		}
+		request(s, "/join?id=0&score=100")
+		request(s, "/join?id=x&score=abc")
+		request(s, "/not_found")
+		clock.Advance(10 * time.Second)
+		s.Match(s.Now(), 5)
+
+		body := string(request(s, "/metrics").Response.Body())
+		// 第二次采集的计数器只会增加
+		body2 := string(request(s, "/metrics").Response.Body())
+		for _, line := range []string{
+			`matching_requests_total{type="join",code="0"} 7`,
+			`matching_requests_total{type="join",code="1"} 1`,
+			`matching_requests_total{type="join",code="http_400"} 1`,
+			`matching_requests_total{type="unknown",code="http_200"} 1`,
+			`matching_wait_time_seconds_bucket{queue="0",band="10",le="10"} 5`,
+			`matching_wait_time_seconds_count{queue="0",band="10"} 5`,
+			`matching_group_score_spread_count{queue="0",band="10"} 1`,
+			`matching_band_players_in_queue{queue="0",band="10"} 2`,
+		} {
+			if !strings.Contains(body, line+"\n") {
+				t.Errorf("%s: missing %s\n%s", name, line, body)
+			}
+		}
+		if !strings.Contains(body2, `matching_requests_total{type="metrics",code="http_200"} 1`+"\n") {
+			t.Errorf("%s: metrics request not counted\n%s", name, body2)
+		}
+		if !strings.Contains(body, "# TYPE matching_tick_duration_seconds histogram\n") || !strings.Contains(body, "matching_tick_duration_seconds_count{") {
+			t.Errorf("%s: missing tick duration\n%s", name, body)
+		}
+	}
+}
+
+func TestHttpShardedMatchingServer_OnGroupMatched(t *testing.T) {
+	s := agent.NewHttpShardedMatchingServer(2, 180, 300, 10)
+	clock := matcher.NewVirtualClock(time.Unix(1600000000, 0))
+	s.SetClock(clock)
+	var mu sync.Mutex
+	shards := make([]int, 0)
+	s.OnGroupMatched = func(shard int, g *matcher.Group) {
+		mu.Lock()
+		shards = append(shards, shard)
+		mu.Unlock()
+	}
+	for i, score := range []int{100, 101, 146, 148, 152, 154} {
+		request(s, "/join?id="+strconv.Itoa(i)+"&score="+strconv.Itoa(score))
+	}
+	clock.Advance(10 * time.Second)
+	s.Match(s.Now(), 2)
+
+	// 设置回调之后统计仍然有效
+	if len(shards) != 3 {
+		t.Errorf("callback shards %v, want 3 groups", shards)
+	}
+	body := string(request(s, "/metrics").Response.Body())
+	for _, line := range []string{
+		`matching_wait_time_seconds_count{queue="0",band="10"} 2`,
+		`matching_group_score_spread_count{queue="0",band="10"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("missing %s\n%s", line, body)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package agent
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/ganlvtech/go-game-matching/matcher"
+)
+
+type PreviewData struct {
+	MatchCount             int         `json:"match_count"`
+	Groups                 []GroupData `json:"groups"`
+	MatchedCount           int         `json:"matched"`
+	PlayerInQueueCount     int         `json:"player_in_queue"`
+	TimedOutCount          int         `json:"timed_out"`
+	AverageWaitTime        float64     `json:"average_wait_time"`
+	GroupStandardDeviation float64     `json:"group_standard_deviation"`
+	MaxStandardDeviation   float64     `json:"max_standard_deviation"`
+}
+
+// /admin/preview?match_count=&curve=
+// 在匹配器的副本上按当前时间执行一次完整的匹配，返回会组成的小组，不会修改匹配器
+// match_count 默认为最近一次匹配的人数，curve 格式与 /admin/radius_curve 相同，POST 时使用请求体
+// 启用自适应分数容忍区间时使用当前的系数
+func (s *HttpMatchingServer) HandlePreview(ctx *fasthttp.RequestCtx) {
+	args := ctx.Request.URI().QueryArgs()
+	opts := matcher.PreviewOptions{}
+	if c := args.Peek("match_count"); len(c) > 0 {
+		n, err := strconv.Atoi(string(c))
+		if err != nil || n < 1 {
+			atomic.AddInt64(&s.Stats.BadRequestCount, 1)
+			ctx.SetStatusCode(http.StatusBadRequest)
+			return
+		}
+		opts.MatchCount = n
+	}
+	spec := args.Peek("curve")
+	if ctx.IsPost() {
+		spec = ctx.PostBody()
+	}
+	var err error
+	if len(spec) > 0 {
+		opts.RadiusCurve, err = matcher.ParseRadiusCurve(string(spec))
+	}
+	var r *matcher.PreviewResult
+	now := s.Now()
+	s.mu.Lock()
+	if err == nil {
+		if s.AdaptiveScoreRadius != nil {
+			opts.PlayerScoreRadius = s.AdaptiveScoreRadius.Frozen
+		}
+		r, err = s.Matcher.Preview(now, opts)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		log.Println(ctx.RemoteIP(), ctx.RequestURI(), err)
+		atomic.AddInt64(&s.Stats.ErrorCount, 1)
+		writeJsonResponseError(ctx, 10, err)
+		return
+	}
+	data := &PreviewData{
+		MatchCount:             r.MatchCount,
+		Groups:                 make([]GroupData, len(r.Groups)),
+		MatchedCount:           r.MatchedCount,
+		PlayerInQueueCount:     r.PlayerInQueueCount,
+		TimedOutCount:          r.TimedOutCount,
+		AverageWaitTime:        r.AverageWaitTime,
+		GroupStandardDeviation: r.GroupStandardDeviation,
+		MaxStandardDeviation:   r.MaxStandardDeviation,
+	}
+	for i, g := range r.Groups {
+		data.Groups[i] = newGroupData(g, now)
+	}
+	writeJsonResponseOKWithData(ctx, data)
+}
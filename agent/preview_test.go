@@ -0,0 +1,50 @@
+package agent_test
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	json "github.com/json-iterator/go"
+
+	"github.com/ganlvtech/go-game-matching/agent"
+	"github.com/ganlvtech/go-game-matching/matcher"
+)
+
+func TestHttpMatchingServer_Preview(t *testing.T) {
+	s := agent.NewHttpMatchingServer(180, 300, 10)
+	clock := matcher.NewVirtualClock(time.Unix(1600000000, 0))
+	s.SetClock(clock)
+	s.EnableAdaptiveScoreRadius(2, 30)
+	request(s, "/join?id=a&score=100")
+	request(s, "/join?id=b&score=102")
+	request(s, "/join?id=c&score=200")
+	request(s, "/join?id=d&score=202")
+	clock.Advance(time.Second)
+	stats := s.AdaptiveScoreRadius.Stats()
+
+	resp := &struct {
+		Code int               `json:"code"`
+		Data agent.PreviewData `json:"data"`
+	}{}
+	_ = json.Unmarshal(request(s, "/admin/preview?match_count=2").Response.Body(), resp)
+	if resp.Code != 0 || len(resp.Data.Groups) != 2 || resp.Data.MatchedCount != 4 || resp.Data.Groups[0].Players[0].JoinTime != -1 {
+		t.Errorf("preview %+v", resp)
+	}
+	_ = json.Unmarshal(request(s, "/admin/preview?match_count=4&curve="+url.QueryEscape(`{"type":"linear","base":300}`)).Response.Body(), resp)
+	if resp.Code != 0 || len(resp.Data.Groups) != 1 || resp.Data.PlayerInQueueCount != 0 {
+		t.Errorf("preview with curve %+v", resp)
+	}
+	if s.Matcher.GroupCount() != 0 || s.Matcher.PlayerInQueueCount() != 4 || s.Matcher.RadiusCurve() != nil || !reflect.DeepEqual(stats, s.AdaptiveScoreRadius.Stats()) {
+		t.Error("preview changed the server")
+	}
+
+	_ = json.Unmarshal(request(s, "/admin/preview?curve=x").Response.Body(), resp)
+	if resp.Code != 10 {
+		t.Errorf("preview with bad curve %+v", resp)
+	}
+	if ctx := request(s, "/admin/preview?match_count=0"); ctx.Response.StatusCode() != 400 {
+		t.Errorf("status %d", ctx.Response.StatusCode())
+	}
+}
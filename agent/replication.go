@@ -0,0 +1,197 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"log"
+	"net"
+	"sync/atomic"
+	"time"
+
+	json "github.com/json-iterator/go"
+
+	"github.com/ganlvtech/go-game-matching/matcher"
+)
+
+// 复制协议：从节点连接主节点后，主节点先发送一行快照，之后每行是一条操作，与操作日志的格式相同
+// 从节点跟不上时主节点会断开连接，从节点重新连接后从新的快照开始
+
+const replicaBufferSize = 65536
+
+var ErrFollowerReadOnly = errors.New("server is a read-only follower")
+
+type replica struct {
+	ops chan []byte
+}
+
+// 主节点监听复制连接，会阻塞直到监听失败
+func (s *HttpMatchingServer) ServeReplication(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Println("Replication listening " + addr)
+	return s.ServeReplicationListener(ln)
+}
+
+// 在已经打开的 ln 上接受复制连接，会阻塞直到 ln 关闭
+func (s *HttpMatchingServer) ServeReplicationListener(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveReplica(conn)
+	}
+}
+
+func (s *HttpMatchingServer) serveReplica(conn net.Conn) {
+	defer conn.Close()
+	log.Println("Replica connected from " + conn.RemoteAddr().String())
+
+	// 在锁内生成快照并开始接收操作，保证快照与之后的操作是连续的
+	var snapshot bytes.Buffer
+	r := &replica{ops: make(chan []byte, replicaBufferSize)}
+	s.mu.Lock()
+	s.flushIngestion()
+	err := s.Matcher.WriteSnapshot(&snapshot)
+	if err == nil {
+		if s.replicas == nil {
+			s.replicas = make(map[*replica]struct{})
+		}
+		s.replicas[r] = struct{}{}
+	}
+	s.mu.Unlock()
+	if err != nil {
+		log.Println("Replica snapshot failed:", err)
+		return
+	}
+	defer func() {
+		s.mu.Lock()
+		delete(s.replicas, r)
+		s.mu.Unlock()
+	}()
+
+	w := bufio.NewWriter(conn)
+	if _, err := w.Write(snapshot.Bytes()); err != nil {
+		log.Println("Replica disconnected:", err)
+		return
+	}
+	for {
+		if len(r.ops) == 0 {
+			if err := w.Flush(); err != nil {
+				log.Println("Replica disconnected:", err)
+				return
+			}
+		}
+		line, ok := <-r.ops
+		if !ok {
+			log.Println("Replica " + conn.RemoteAddr().String() + " is too slow, disconnected.")
+			return
+		}
+		if _, err := w.Write(line); err != nil {
+			log.Println("Replica disconnected:", err)
+			return
+		}
+	}
+}
+
+// 把操作发送给所有从节点，调用前需要加锁
+func (s *HttpMatchingServer) replicate(op *matcher.Operation) {
+	if len(s.replicas) == 0 {
+		return
+	}
+	b, err := json.Marshal(op)
+	if err != nil {
+		return
+	}
+	line := append(b, '\n')
+	for r := range s.replicas {
+		select {
+		case r.ops <- line:
+		default:
+			close(r.ops)
+			delete(s.replicas, r)
+		}
+	}
+}
+
+// 作为从节点跟随主节点，断开后自动重连，直到 Promote 为止
+// 调用后立即成为只读的从节点，复制在后台进行
+func (s *HttpMatchingServer) Follow(addr string) {
+	atomic.StoreInt32(&s.following, 1)
+	go s.followLoop(addr)
+}
+
+func (s *HttpMatchingServer) followLoop(addr string) {
+	for s.IsFollower() {
+		err := s.followOnce(addr)
+		if !s.IsFollower() {
+			break
+		}
+		log.Println("Replication from "+addr+" interrupted:", err)
+		time.Sleep(time.Second)
+	}
+}
+
+func (s *HttpMatchingServer) followOnce(addr string) error {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.followConn = conn
+	s.mu.Unlock()
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	err = s.Matcher.ReadSnapshot(bytes.NewReader(line))
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	log.Println("Replication snapshot loaded from " + addr)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return err
+		}
+		op := &matcher.Operation{}
+		if err := json.Unmarshal(line, op); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		if s.IsFollower() {
+			_ = s.Matcher.Apply(op)
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *HttpMatchingServer) IsFollower() bool {
+	return atomic.LoadInt32(&s.following) == 1
+}
+
+// 停止跟随，成为主节点，之后调用 OnPromote
+func (s *HttpMatchingServer) Promote() {
+	s.mu.Lock()
+	if !atomic.CompareAndSwapInt32(&s.following, 1, 0) {
+		s.mu.Unlock()
+		return
+	}
+	if s.followConn != nil {
+		_ = s.followConn.Close()
+		s.followConn = nil
+	}
+	s.mu.Unlock()
+	log.Println("Promoted to primary.")
+	if s.OnPromote != nil {
+		s.OnPromote()
+	}
+}
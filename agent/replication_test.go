@@ -0,0 +1,158 @@
+package agent_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ganlvtech/go-game-matching/agent"
+	"github.com/ganlvtech/go-game-matching/matcher"
+)
+
+func newReplicationServer() *agent.HttpMatchingServer {
+	s := agent.NewHttpMatchingServer(180, 300, 10)
+	s.SetClock(matcher.NewVirtualClock(time.Unix(1600000000, 0)))
+	return s
+}
+
+func startPrimary(t *testing.T) (*agent.HttpMatchingServer, net.Listener) {
+	t.Helper()
+	s := newReplicationServer()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = s.ServeReplicationListener(ln)
+	}()
+	return s, ln
+}
+
+func snapshotOf(t *testing.T, s *agent.HttpMatchingServer) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	if err := s.WriteSnapshot(&b); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// 等待从节点追上主节点
+func waitForReplica(t *testing.T, primary *agent.HttpMatchingServer, follower *agent.HttpMatchingServer) {
+	t.Helper()
+	want := snapshotOf(t, primary)
+	deadline := time.Now().Add(5 * time.Second)
+	for !bytes.Equal(snapshotOf(t, follower), want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("follower snapshot differs\nprimary:  %s\nfollower: %s", want, snapshotOf(t, follower))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func joinPlayers(s *agent.HttpMatchingServer, prefix string, count int) {
+	for i := 0; i < count; i++ {
+		request(s, "/join?id="+prefix+strconv.Itoa(i)+"&score="+strconv.Itoa(i*37%300))
+	}
+}
+
+func TestReplication_Loopback(t *testing.T) {
+	p, ln := startPrimary(t)
+	defer ln.Close()
+	joinPlayers(p, "a", 20)
+	p.Match(p.Now(), 4)
+
+	f1 := newReplicationServer()
+	f1.Follow(ln.Addr().String())
+	defer f1.Promote()
+	waitForReplica(t, p, f1)
+
+	joinPlayers(p, "b", 20)
+	request(p, "/leave?id=b1")
+	p.Match(p.Now()+10, 4)
+	waitForReplica(t, p, f1)
+
+	// 中途连接的从节点从当时的快照开始
+	f2 := newReplicationServer()
+	f2.Follow(ln.Addr().String())
+	defer f2.Promote()
+	waitForReplica(t, p, f2)
+
+	joinPlayers(p, "c", 20)
+	request(p, "/remove?id=c2")
+	p.Match(p.Now()+20, 4)
+	waitForReplica(t, p, f1)
+	waitForReplica(t, p, f2)
+
+	// 从节点是只读的
+	request(f1, "/join?id=x&score=100")
+	waitForReplica(t, p, f1)
+}
+
+func TestReplication_SlowReplica(t *testing.T) {
+	p, ln := startPrimary(t)
+	defer ln.Close()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 不读取数据，主节点的缓冲区和连接的缓冲区都满了之后应该断开这个从节点
+	const count = 1000000
+	for i := 0; i < count; i++ {
+		p.Sweep(matcher.Time(i))
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	n, err := io.Copy(ioutil.Discard, conn)
+	if err != nil {
+		t.Fatalf("slow replica was not disconnected: %v", err)
+	}
+	if n >= count*int64(len(`{"type":"sweep"}`)) {
+		t.Errorf("slow replica received %d bytes", n)
+	}
+
+	// 其他从节点不受影响
+	f := newReplicationServer()
+	f.Follow(ln.Addr().String())
+	defer f.Promote()
+	joinPlayers(p, "a", 10)
+	waitForReplica(t, p, f)
+}
+
+func TestReplication_Promote(t *testing.T) {
+	p, ln := startPrimary(t)
+	defer ln.Close()
+	joinPlayers(p, "a", 10)
+
+	f := newReplicationServer()
+	promoted := make(chan struct{})
+	f.OnPromote = func() {
+		close(promoted)
+	}
+	f.Follow(ln.Addr().String())
+	waitForReplica(t, p, f)
+
+	f.Promote()
+	select {
+	case <-promoted:
+	case <-time.After(time.Second):
+		t.Fatal("OnPromote was not called")
+	}
+	if f.IsFollower() {
+		t.Error("still a follower after Promote")
+	}
+
+	// 成为主节点后可以写入，不再接收原来主节点的操作
+	request(f, "/join?id=x&score=100")
+	joinPlayers(p, "b", 10)
+	time.Sleep(50 * time.Millisecond)
+	if f.Matcher.PlayerInQueueCount() != 11 {
+		t.Errorf("promoted server has %d players, want 11", f.Matcher.PlayerInQueueCount())
+	}
+	f.Promote()
+}
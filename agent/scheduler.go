@@ -0,0 +1,111 @@
+package agent
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/ganlvtech/go-game-matching/matcher"
+)
+
+// 调度器需要的匹配服务
+type matchSchedulerTarget interface {
+	TimeOf(t time.Time) matcher.Time
+	Match(currentTime matcher.Time, count int)
+	BandJoinCounts() []int
+	NextRadiusCrossing(after matcher.Time) (matcher.Time, bool)
+}
+
+// 事件驱动的匹配调度器
+//
+// 每隔 TickInterval 检查一次触发条件，满足任意一个就执行一次匹配：
+// 距上次匹配超过 MaxInterval；某个分段新加入的人数达到 BandArrivals；
+// 有玩家的分数容忍区间扩大到了新的分段。空闲时不会每秒扫描整个队列，繁忙时也不用等满一秒。
+type MatchScheduler struct {
+	TickInterval   time.Duration // 检查触发条件的间隔
+	MaxInterval    time.Duration // 两次匹配的最长间隔
+	BandArrivals   int           // 某个分段新加入多少人后立即匹配，0 表示不按加入人数触发
+	RadiusCrossing bool          // 分数容忍区间扩大到新的分段时立即匹配
+	Count          int           // 每组匹配人数
+	Clock          matcher.Clock // Run 使用的时钟
+	Stats          MatchSchedulerStats
+
+	target             matchSchedulerTarget
+	lastMatch          time.Time
+	lastMatchTime      matcher.Time
+	lastBandJoinCounts []int
+}
+
+type MatchSchedulerStats struct {
+	MatchCount          int64 `json:"match_count"`
+	IntervalTriggers    int64 `json:"interval_triggers"`
+	ArrivalTriggers     int64 `json:"arrival_triggers"`
+	RadiusTriggers      int64 `json:"radius_triggers"`
+	LastMatchUnixMillis int64 `json:"last_match_unix_millis"`
+}
+
+// 默认每秒匹配一次，与固定间隔的匹配循环相同
+func NewMatchScheduler(target matchSchedulerTarget, count int) *MatchScheduler {
+	return &MatchScheduler{
+		TickInterval: time.Second,
+		MaxInterval:  time.Second,
+		Count:        count,
+		Clock:        matcher.SystemClock,
+		target:       target,
+	}
+}
+
+// 检查触发条件，需要时执行匹配，返回是否执行了匹配
+func (sc *MatchScheduler) Tick(now time.Time) bool {
+	currentTime := sc.target.TimeOf(now)
+	var trigger *int64
+	var counts []int
+	if sc.lastMatch.IsZero() || now.Sub(sc.lastMatch) >= sc.MaxInterval {
+		trigger = &sc.Stats.IntervalTriggers
+	}
+	if trigger == nil && sc.BandArrivals > 0 {
+		counts = sc.target.BandJoinCounts()
+		for i := range counts {
+			if i < len(sc.lastBandJoinCounts) && counts[i]-sc.lastBandJoinCounts[i] >= sc.BandArrivals {
+				trigger = &sc.Stats.ArrivalTriggers
+				break
+			}
+		}
+	}
+	if trigger == nil && sc.RadiusCrossing {
+		if t, ok := sc.target.NextRadiusCrossing(sc.lastMatchTime); ok && t <= currentTime {
+			trigger = &sc.Stats.RadiusTriggers
+		}
+	}
+	if trigger == nil {
+		return false
+	}
+
+	sc.target.Match(currentTime, sc.Count)
+	atomic.AddInt64(trigger, 1)
+	atomic.AddInt64(&sc.Stats.MatchCount, 1)
+	atomic.StoreInt64(&sc.Stats.LastMatchUnixMillis, now.UnixNano()/int64(time.Millisecond))
+	sc.lastMatch = now
+	sc.lastMatchTime = currentTime
+	if sc.BandArrivals > 0 {
+		sc.lastBandJoinCounts = sc.target.BandJoinCounts()
+	}
+	return true
+}
+
+// 按 TickInterval 循环调用 Tick，直到 isRun 返回 false
+func (sc *MatchScheduler) Run(isRun func() bool) {
+	for isRun() {
+		sc.Tick(sc.Clock.Now())
+		time.Sleep(sc.TickInterval)
+	}
+}
+
+func (sc *MatchScheduler) StatsData() *MatchSchedulerStats {
+	return &MatchSchedulerStats{
+		MatchCount:          atomic.LoadInt64(&sc.Stats.MatchCount),
+		IntervalTriggers:    atomic.LoadInt64(&sc.Stats.IntervalTriggers),
+		ArrivalTriggers:     atomic.LoadInt64(&sc.Stats.ArrivalTriggers),
+		RadiusTriggers:      atomic.LoadInt64(&sc.Stats.RadiusTriggers),
+		LastMatchUnixMillis: atomic.LoadInt64(&sc.Stats.LastMatchUnixMillis),
+	}
+}
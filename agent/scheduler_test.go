@@ -0,0 +1,72 @@
+package agent_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ganlvtech/go-game-matching/agent"
+	"github.com/ganlvtech/go-game-matching/matcher"
+)
+
+type fakeScheduleTarget struct {
+	matches   []matcher.Time
+	counts    []int
+	crossing  matcher.Time
+	crossesOk bool
+}
+
+func (f *fakeScheduleTarget) TimeOf(t time.Time) matcher.Time {
+	return matcher.Time(t.Unix())
+}
+
+func (f *fakeScheduleTarget) Match(currentTime matcher.Time, count int) {
+	f.matches = append(f.matches, currentTime)
+}
+
+func (f *fakeScheduleTarget) BandJoinCounts() []int {
+	return append([]int(nil), f.counts...)
+}
+
+func (f *fakeScheduleTarget) NextRadiusCrossing(after matcher.Time) (matcher.Time, bool) {
+	return f.crossing, f.crossesOk && f.crossing > after
+}
+
+func TestMatchScheduler_Tick(t *testing.T) {
+	f := &fakeScheduleTarget{counts: []int{0, 0}}
+	sc := agent.NewMatchScheduler(f, 5)
+	sc.MaxInterval = 10 * time.Second
+	sc.BandArrivals = 3
+	sc.RadiusCrossing = true
+	start := time.Unix(1000, 0)
+
+	if !sc.Tick(start) {
+		t.Fatal("first tick should match")
+	}
+	if sc.Tick(start.Add(time.Second)) {
+		t.Error("idle tick should not match")
+	}
+	f.counts[1] = 2
+	if sc.Tick(start.Add(2 * time.Second)) {
+		t.Error("2 arrivals should not trigger")
+	}
+	f.counts[1] = 3
+	if !sc.Tick(start.Add(3 * time.Second)) {
+		t.Error("3 arrivals should trigger")
+	}
+	f.crossing, f.crossesOk = 1005, true
+	if sc.Tick(start.Add(4 * time.Second)) {
+		t.Error("crossing in the future should not trigger")
+	}
+	if !sc.Tick(start.Add(5 * time.Second)) {
+		t.Error("crossing should trigger")
+	}
+	if sc.Tick(start.Add(14 * time.Second)) {
+		t.Error("tick before max interval should not match")
+	}
+	if !sc.Tick(start.Add(15 * time.Second)) {
+		t.Error("max interval should trigger")
+	}
+	if sc.Stats.IntervalTriggers != 2 || sc.Stats.ArrivalTriggers != 1 || sc.Stats.RadiusTriggers != 1 || len(f.matches) != 4 {
+		t.Errorf("unexpected stats %+v, matches %v", sc.Stats, f.matches)
+	}
+}
@@ -0,0 +1,258 @@
+package agent
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+
+	"github.com/ganlvtech/go-game-matching/matcher"
+)
+
+// 使用分片匹配器的 HTTP 服务，只支持玩家相关的接口和 /stats
+//
+// 各分片的 OnGroupMatchedEventCallback 用于统计匹配结果，替换后 /metrics 中不再有等待时间和小组分数分布，需要回调时设置 OnGroupMatched
+type HttpShardedMatchingServer struct {
+	Matcher        *matcher.ShardedMatcher
+	Stats          HttpMatchingServerStats
+	Scheduler      *MatchScheduler                       // 只用于在 /stats 中显示调度情况
+	OnGroupMatched func(shard int, group *matcher.Group) // 在登记小组的分片的锁内调用，各分片并行匹配时可能同时调用
+	clock          matcher.Clock
+	metrics        *serverMetrics
+	matchTime      int64 // 正在执行的 Match 的时间，原子操作，用于计算匹配成功的玩家的等待时间
+}
+
+type ShardedMatcherStatsData struct {
+	ShardCount         int                  `json:"shard_count"`
+	TimeUnit           string               `json:"time_unit"`
+	PlayerCount        int                  `json:"player_count"`
+	PlayerInQueueCount int                  `json:"player_in_queue_count"`
+	GroupCount         int                  `json:"group_count"`
+	ServerRunningTime  float64              `json:"server_running_time"`
+	JoinRequestCount   int                  `json:"join_request_count"`
+	StatusRequestCount int                  `json:"status_request_count"`
+	LeaveRequestCount  int                  `json:"leave_request_count"`
+	RemoveRequestCount int                  `json:"remove_request_count"`
+	BadRequestCount    int                  `json:"bad_request_count"`
+	ErrorCount         int                  `json:"error_count"`
+	JoinOKCount        int                  `json:"join_ok_count"`
+	GetStatusOKCount   int                  `json:"get_status_ok_count"`
+	Shards             []ShardedStatsData   `json:"shards"`
+	Scheduler          *MatchSchedulerStats `json:"scheduler,omitempty"`
+	Prediction         *PredictionStatsData `json:"prediction"`
+}
+
+type ShardedStatsData struct {
+	PlayerCount        int     `json:"player_count"`
+	PlayerInQueueCount int     `json:"player_in_queue_count"`
+	GroupCount         int     `json:"group_count"`
+	AverageWaitTime    float64 `json:"average_wait_time"`
+}
+
+func NewHttpShardedMatchingServer(shardCount int, maxTime matcher.Time, maxScore matcher.PlayerScore, scoreGroupLen int) *HttpShardedMatchingServer {
+	return NewHttpShardedMatchingServerWithTimeUnit(shardCount, maxTime, maxScore, scoreGroupLen, time.Second)
+}
+
+func NewHttpShardedMatchingServerWithTimeUnit(shardCount int, maxTime matcher.Time, maxScore matcher.PlayerScore, scoreGroupLen int, unit time.Duration) *HttpShardedMatchingServer {
+	s := &HttpShardedMatchingServer{
+		Matcher: matcher.NewShardedMatcherWithTimeUnit(shardCount, maxTime, maxScore, scoreGroupLen, unit),
+		metrics: newServerMetrics(unit, scoreGroupLen),
+	}
+	s.clock = matcher.SystemClock
+	s.Stats.ServerStartTime = s.clock.Now()
+	// 跨分片的小组由登记该小组的分片回调，分片原有的回调在统计之后继续调用
+	s.Matcher.Each(func(i int, m *matcher.Matcher) {
+		queue := strconv.Itoa(i)
+		next := m.OnGroupMatchedEventCallback
+		m.OnGroupMatchedEventCallback = func(g *matcher.Group) {
+			s.metrics.observeGroup(queue, m, g, matcher.Time(atomic.LoadInt64(&s.matchTime)))
+			if next != nil {
+				next(g)
+			}
+			if s.OnGroupMatched != nil {
+				s.OnGroupMatched(i, g)
+			}
+		}
+	})
+	return s
+}
+
+// 各分片可能并行匹配，耗时按整体记录，queue 标签为 all
+func (s *HttpShardedMatchingServer) Match(currentTime matcher.Time, count int) {
+	atomic.StoreInt64(&s.matchTime, int64(currentTime))
+	// 耗时使用真实时间，虚拟时钟在匹配过程中不会前进
+	start := time.Now()
+	s.Matcher.Match(currentTime, count)
+	s.metrics.observeTick("all", time.Since(start))
+}
+
+func (s *HttpShardedMatchingServer) TimeOf(t time.Time) matcher.Time {
+	return s.Matcher.TimeOf(t)
+}
+
+// 替换服务器和各分片使用的时钟，需要在开始处理请求之前调用
+func (s *HttpShardedMatchingServer) SetClock(c matcher.Clock) {
+	s.clock = c
+	s.Matcher.SetClock(c)
+	s.Stats.ServerStartTime = c.Now()
+}
+
+func (s *HttpShardedMatchingServer) Clock() matcher.Clock {
+	return s.clock
+}
+
+func (s *HttpShardedMatchingServer) Now() matcher.Time {
+	return s.TimeOf(s.clock.Now())
+}
+
+func (s *HttpShardedMatchingServer) BandJoinCounts() []int {
+	return s.Matcher.BandJoinCounts()
+}
+
+func (s *HttpShardedMatchingServer) NextRadiusCrossing(after matcher.Time) (matcher.Time, bool) {
+	return s.Matcher.NextRadiusCrossing(after)
+}
+
+func (s *HttpShardedMatchingServer) Sweep(before matcher.Time) {
+	s.Matcher.Sweep(before)
+}
+
+func (s *HttpShardedMatchingServer) HandleHTTP(ctx *fasthttp.RequestCtx) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Println(r)
+		}
+	}()
+	path := string(ctx.Request.URI().Path())
+	typ := requestType(path)
+	switch path {
+	case "/join":
+		atomic.AddInt64(&s.Stats.JoinRequestCount, 1)
+		s.HandleJoin(ctx)
+	case "/status":
+		atomic.AddInt64(&s.Stats.StatusRequestCount, 1)
+		s.HandleGetStatus(ctx)
+	case "/player_status":
+		atomic.AddInt64(&s.Stats.StatusRequestCount, 1)
+		s.HandlePlayerStatus(ctx)
+	case "/accept":
+		id := matcher.PlayerId(ctx.Request.URI().QueryArgs().Peek("id"))
+		s.writeResult(ctx, 6, s.Matcher.Accept(id))
+	case "/leave":
+		atomic.AddInt64(&s.Stats.LeaveRequestCount, 1)
+		id := matcher.PlayerId(ctx.Request.URI().QueryArgs().Peek("id"))
+		s.writeResult(ctx, 3, s.Matcher.LeaveQueue(id))
+	case "/remove":
+		atomic.AddInt64(&s.Stats.RemoveRequestCount, 1)
+		id := matcher.PlayerId(ctx.Request.URI().QueryArgs().Peek("id"))
+		s.Matcher.Remove(id)
+		writeJsonResponseOK(ctx)
+	case "/stats":
+		s.HandleStats(ctx)
+	case "/metrics":
+		s.HandleMetrics(ctx)
+	default:
+		typ = "unknown"
+	}
+	s.metrics.observeRequest(typ, ctx)
+}
+
+func (s *HttpShardedMatchingServer) HandleMetrics(ctx *fasthttp.RequestCtx) {
+	gauges := make([]queueGauges, 0, s.Matcher.ShardCount())
+	s.Matcher.Each(func(i int, m *matcher.Matcher) {
+		gauges = append(gauges, newQueueGauges(strconv.Itoa(i), m))
+	})
+	writeMetricsResponse(ctx, s.metrics, gauges)
+}
+
+func (s *HttpShardedMatchingServer) writeResult(ctx *fasthttp.RequestCtx, code int, err error) {
+	if err != nil {
+		log.Println(ctx.RemoteIP(), ctx.RequestURI(), err)
+		atomic.AddInt64(&s.Stats.ErrorCount, 1)
+		writeJsonResponseError(ctx, code, err)
+		return
+	}
+	writeJsonResponseOK(ctx)
+}
+
+func (s *HttpShardedMatchingServer) HandleJoin(ctx *fasthttp.RequestCtx) {
+	id := matcher.PlayerId(ctx.Request.URI().QueryArgs().Peek("id"))
+	score1, err := strconv.Atoi(string(ctx.Request.URI().QueryArgs().Peek("score")))
+	if id == "" || err != nil || score1 < 0 {
+		atomic.AddInt64(&s.Stats.BadRequestCount, 1)
+		ctx.SetStatusCode(http.StatusBadRequest)
+		return
+	}
+	score := matcher.PlayerScore(score1)
+	err = s.Matcher.JoinQueue(id, s.Now(), score)
+	if err != nil {
+		log.Println(ctx.RemoteIP(), ctx.RequestURI(), err)
+		atomic.AddInt64(&s.Stats.ErrorCount, 1)
+		writeJsonResponseError(ctx, 1, err)
+		return
+	}
+	atomic.AddInt64(&s.Stats.JoinOKCount, 1)
+	writeJsonResponseOKWithData(ctx, newMatchingJoinData(s.Matcher.GetWaitTimeEstimateByScore(score)))
+}
+
+func (s *HttpShardedMatchingServer) HandleGetStatus(ctx *fasthttp.RequestCtx) {
+	id := matcher.PlayerId(ctx.Request.URI().QueryArgs().Peek("id"))
+	ids, err := s.Matcher.GetMatchedPlayers(id)
+	if err != nil {
+		log.Println(ctx.RemoteIP(), ctx.RequestURI(), err)
+		atomic.AddInt64(&s.Stats.ErrorCount, 1)
+		writeJsonResponseError(ctx, 2, err)
+		return
+	}
+	atomic.AddInt64(&s.Stats.GetStatusOKCount, 1)
+	writeJsonResponseOKWithData(ctx, MatchingStatusData{Ids: ids})
+}
+
+func (s *HttpShardedMatchingServer) HandlePlayerStatus(ctx *fasthttp.RequestCtx) {
+	id := matcher.PlayerId(ctx.Request.URI().QueryArgs().Peek("id"))
+	status, err := s.Matcher.GetPlayerStatus(id, s.Now())
+	if err != nil {
+		log.Println(ctx.RemoteIP(), ctx.RequestURI(), err)
+		atomic.AddInt64(&s.Stats.ErrorCount, 1)
+		writeJsonResponseError(ctx, 5, err)
+		return
+	}
+	atomic.AddInt64(&s.Stats.GetStatusOKCount, 1)
+	writeJsonResponseOKWithData(ctx, newMatchingPlayerStatusData(status))
+}
+
+func (s *HttpShardedMatchingServer) HandleStats(ctx *fasthttp.RequestCtx) {
+	data := &ShardedMatcherStatsData{
+		ShardCount: s.Matcher.ShardCount(),
+		TimeUnit:   s.Matcher.TimeUnit().String(),
+		Shards:     make([]ShardedStatsData, s.Matcher.ShardCount()),
+	}
+	s.Matcher.Each(func(i int, m *matcher.Matcher) {
+		data.Shards[i] = ShardedStatsData{
+			PlayerCount:        m.PlayerCount(),
+			PlayerInQueueCount: m.PlayerInQueueCount(),
+			GroupCount:         m.GroupCount(),
+			AverageWaitTime:    m.AverageWaitTime(),
+		}
+		data.PlayerCount += data.Shards[i].PlayerCount
+		data.PlayerInQueueCount += data.Shards[i].PlayerInQueueCount
+		data.GroupCount += data.Shards[i].GroupCount
+	})
+	data.Prediction = newPredictionStatsData(s.Matcher.PredictionStats())
+	data.ServerRunningTime = s.clock.Now().Sub(s.Stats.ServerStartTime).Seconds()
+	data.JoinRequestCount = int(atomic.LoadInt64(&s.Stats.JoinRequestCount))
+	data.StatusRequestCount = int(atomic.LoadInt64(&s.Stats.StatusRequestCount))
+	data.LeaveRequestCount = int(atomic.LoadInt64(&s.Stats.LeaveRequestCount))
+	data.RemoveRequestCount = int(atomic.LoadInt64(&s.Stats.RemoveRequestCount))
+	data.BadRequestCount = int(atomic.LoadInt64(&s.Stats.BadRequestCount))
+	data.ErrorCount = int(atomic.LoadInt64(&s.Stats.ErrorCount))
+	data.JoinOKCount = int(atomic.LoadInt64(&s.Stats.JoinOKCount))
+	data.GetStatusOKCount = int(atomic.LoadInt64(&s.Stats.GetStatusOKCount))
+	if s.Scheduler != nil {
+		data.Scheduler = s.Scheduler.StatsData()
+	}
+	writeJsonResponseOKWithData(ctx, data)
+}
@@ -0,0 +1,105 @@
+package agent_test
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	json "github.com/json-iterator/go"
+
+	"github.com/ganlvtech/go-game-matching/agent"
+	"github.com/ganlvtech/go-game-matching/matcher"
+)
+
+func shardedPlayerState(t *testing.T, s *agent.HttpShardedMatchingServer, id string) string {
+	t.Helper()
+	resp := &struct {
+		Code int                            `json:"code"`
+		Data agent.MatchingPlayerStatusData `json:"data"`
+	}{}
+	_ = json.Unmarshal(request(s, "/player_status?id="+id).Response.Body(), resp)
+	if resp.Code != 0 {
+		return ""
+	}
+	return resp.Data.State
+}
+
+func TestHttpShardedMatchingServer_CrossShardGroup(t *testing.T) {
+	s := agent.NewHttpShardedMatchingServer(2, 180, 300, 10)
+	clock := matcher.NewVirtualClock(time.Unix(1600000000, 0))
+	s.SetClock(clock)
+	s.Matcher.Each(func(i int, m *matcher.Matcher) {
+		m.RequireAccept = true
+	})
+	// 两个分片各两人，组成跨分片的小组
+	for i, score := range []int{146, 148, 152, 154} {
+		r := &agent.HttpJsonResponse{}
+		_ = json.Unmarshal(request(s, "/join?id="+strconv.Itoa(i)+"&score="+strconv.Itoa(score)).Response.Body(), r)
+		if r.Code != 0 {
+			t.Fatalf("join %d: %+v", i, r)
+		}
+	}
+	if ctx := request(s, "/join?score=100"); ctx.Response.StatusCode() != http.StatusBadRequest {
+		t.Errorf("join without id: status %d", ctx.Response.StatusCode())
+	}
+	clock.Advance(time.Second)
+	s.Match(s.Now(), 4)
+
+	status := &struct {
+		Code int                      `json:"code"`
+		Data agent.MatchingStatusData `json:"data"`
+	}{}
+	_ = json.Unmarshal(request(s, "/status?id=3").Response.Body(), status)
+	if status.Code != 0 || len(status.Data.Ids) != 4 {
+		t.Fatalf("status %+v", status)
+	}
+	for i := 0; i < 4; i++ {
+		if state := shardedPlayerState(t, s, strconv.Itoa(i)); state != "pending-accept" {
+			t.Errorf("%d: state %q", i, state)
+		}
+	}
+
+	// 分片 1 中的玩家拒绝，两个分片中的其他玩家都回到队列
+	r := &agent.HttpJsonResponse{}
+	_ = json.Unmarshal(request(s, "/accept?id=0").Response.Body(), r)
+	if r.Code != 0 {
+		t.Errorf("accept %+v", r)
+	}
+	_ = json.Unmarshal(request(s, "/leave?id=3").Response.Body(), r)
+	if r.Code != 0 {
+		t.Errorf("leave %+v", r)
+	}
+	for _, id := range []string{"0", "1", "2"} {
+		if state := shardedPlayerState(t, s, id); state != "searching" {
+			t.Errorf("%s: state %q after decline", id, state)
+		}
+	}
+
+	// 在跨分片的小组中删除，再清理
+	request(s, "/join?id=4&score=150")
+	s.Match(s.Now(), 4)
+	if state := shardedPlayerState(t, s, "2"); state != "pending-accept" {
+		t.Fatalf("2: state %q after rematch", state)
+	}
+	request(s, "/remove?id=4")
+	for _, id := range []string{"0", "1", "2"} {
+		if state := shardedPlayerState(t, s, id); state != "searching" {
+			t.Errorf("%s: state %q after remove", id, state)
+		}
+	}
+	clock.Advance(time.Second)
+	s.Sweep(s.Now())
+	if shardedPlayerState(t, s, "0") != "" || s.Matcher.PlayerCount() != 0 {
+		t.Errorf("%d players after sweep", s.Matcher.PlayerCount())
+	}
+
+	stats := &struct {
+		Code int                           `json:"code"`
+		Data agent.ShardedMatcherStatsData `json:"data"`
+	}{}
+	_ = json.Unmarshal(request(s, "/stats").Response.Body(), stats)
+	if stats.Code != 0 || stats.Data.ShardCount != 2 || stats.Data.JoinOKCount != 5 || stats.Data.BadRequestCount != 1 || stats.Data.GroupCount != 0 {
+		t.Errorf("stats %+v", stats.Data)
+	}
+}
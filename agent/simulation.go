@@ -0,0 +1,85 @@
+package agent
+
+import (
+	"strconv"
+	"time"
+
+	json "github.com/json-iterator/go"
+	"github.com/valyala/fasthttp"
+
+	"github.com/ganlvtech/go-game-matching/matcher"
+)
+
+// 模拟驱动
+//
+// 使用虚拟时钟推进时间，请求直接交给 HandleHTTP 处理，不经过网络，也不需要等待真实时间，
+// 一天的流量可以在几秒内跑完。使用的是完整的服务器代码，输入相同时结果完全相同。
+type Simulation struct {
+	Server        *HttpMatchingServer
+	Clock         *matcher.VirtualClock
+	Scheduler     *MatchScheduler
+	Step          time.Duration       // 每次推进的虚拟时间
+	SweepInterval time.Duration       // 清理的间隔
+	SweepBefore   time.Duration       // 清理多久之前加入的玩家
+	OnStep        func(now time.Time) // 每次推进之后、调度之前调用，可以在这里发出请求
+	lastSweep     time.Time
+}
+
+// 默认每次推进调度器的 TickInterval，清理的参数与 main 相同
+func NewSimulation(server *HttpMatchingServer, start time.Time, matchCount int) *Simulation {
+	clock := matcher.NewVirtualClock(start)
+	server.SetClock(clock)
+	scheduler := NewMatchScheduler(server, matchCount)
+	scheduler.Clock = clock
+	server.Scheduler = scheduler
+	maxTime := server.Matcher.TimeToDuration(server.Matcher.MaxTime())
+	return &Simulation{
+		Server:        server,
+		Clock:         clock,
+		Scheduler:     scheduler,
+		Step:          scheduler.TickInterval,
+		SweepInterval: maxTime,
+		SweepBefore:   2 * maxTime,
+		lastSweep:     start,
+	}
+}
+
+// 发出一个 GET 请求，例如 /join?id=1&score=100
+func (sim *Simulation) Request(uri string) *HttpJsonResponse {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI(uri)
+	sim.Server.HandleHTTP(ctx)
+	r := &HttpJsonResponse{}
+	if err := json.Unmarshal(ctx.Response.Body(), r); err != nil {
+		return &HttpJsonResponse{Code: -1, Msg: err.Error()}
+	}
+	return r
+}
+
+func (sim *Simulation) Join(id matcher.PlayerId, score matcher.PlayerScore) *HttpJsonResponse {
+	return sim.Request("/join?id=" + string(id) + "&score=" + strconv.Itoa(int(score)))
+}
+
+func (sim *Simulation) Leave(id matcher.PlayerId) *HttpJsonResponse {
+	return sim.Request("/leave?id=" + string(id))
+}
+
+func (sim *Simulation) Remove(id matcher.PlayerId) *HttpJsonResponse {
+	return sim.Request("/remove?id=" + string(id))
+}
+
+// 推进虚拟时间 d，每一步依次调用 OnStep、调度器和清理
+func (sim *Simulation) Run(d time.Duration) {
+	end := sim.Clock.Now().Add(d)
+	for sim.Clock.Now().Before(end) {
+		now := sim.Clock.Advance(sim.Step)
+		if sim.OnStep != nil {
+			sim.OnStep(now)
+		}
+		sim.Scheduler.Tick(now)
+		if now.Sub(sim.lastSweep) >= sim.SweepInterval {
+			sim.Server.Sweep(sim.Server.TimeOf(now.Add(-sim.SweepBefore)))
+			sim.lastSweep = now
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package agent_test
+
+import (
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ganlvtech/go-game-matching/agent"
+	"github.com/ganlvtech/go-game-matching/matcher"
+)
+
+type simulationResult struct {
+	joined  int
+	matched [][]matcher.PlayerId
+	stats   agent.HttpMatchingServerStats
+	waits   []float64
+}
+
+// 模拟一天，每秒平均加入 2 人，分数均匀分布
+func simulateDay(seed int64) *simulationResult {
+	r := rand.New(rand.NewSource(seed))
+	server := agent.NewHttpMatchingServer(180, 300, 10)
+	res := &simulationResult{}
+	server.Matcher.OnGroupMatchedEventCallback = func(g *matcher.Group) {
+		res.matched = append(res.matched, g.PlayerIds())
+	}
+	sim := agent.NewSimulation(server, time.Unix(1600000000, 0), 5)
+	id := 0
+	sim.OnStep = func(now time.Time) {
+		for n := r.Intn(5); n > 0; n-- {
+			sim.Join(matcher.PlayerId(strconv.Itoa(id)), matcher.PlayerScore(r.Intn(300)))
+			id++
+		}
+	}
+	sim.Run(24 * time.Hour)
+	res.joined = id
+	res.stats = server.Stats
+	res.stats.ServerStartTime = time.Time{}
+	res.waits = server.Matcher.GroupWaitTime()
+	return res
+}
+
+func TestSimulation_Day(t *testing.T) {
+	r1 := simulateDay(1)
+	if r1.joined < 100000 || len(r1.matched) < r1.joined/10 {
+		t.Fatalf("joined %d, matched %d groups", r1.joined, len(r1.matched))
+	}
+	r2 := simulateDay(1)
+	if !reflect.DeepEqual(r1, r2) {
+		t.Error("simulation is not deterministic")
+	}
+}
+
+// 模拟一天需要的真实时间
+func BenchmarkSimulation_Day(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		simulateDay(int64(i))
+	}
+}
+
+func TestHttpMatchingServer_TrafficRecording(t *testing.T) {
+	dir, err := ioutil.TempDir("", "traffic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "traffic.jsonl")
+
+	r := rand.New(rand.NewSource(1))
+	server := agent.NewHttpMatchingServer(180, 300, 10)
+	server.Matcher.MatchBudget = matcher.MatchBudget{MaxPlayers: 20}
+	sim := agent.NewSimulation(server, time.Unix(1600000000, 0), 5)
+	if err := server.StartTrafficRecording(path); err != nil {
+		t.Fatal(err)
+	}
+	matched := make([][]matcher.PlayerId, 0)
+	server.Matcher.OnGroupMatchedEventCallback = func(g *matcher.Group) {
+		matched = append(matched, g.PlayerIds())
+	}
+	id := 0
+	sim.OnStep = func(now time.Time) {
+		for n := r.Intn(5); n > 0; n-- {
+			sim.Join(matcher.PlayerId(strconv.Itoa(id)), matcher.PlayerScore(r.Intn(300)))
+			id++
+		}
+		sim.Leave(matcher.PlayerId(strconv.Itoa(r.Intn(id + 1))))
+	}
+	sim.Run(time.Hour)
+	if err := server.StopTrafficRecording(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	reader := matcher.NewTrafficReader(f)
+	var m *matcher.Matcher
+	replayed := make([][]matcher.PlayerId, 0)
+	for {
+		rec, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.Header != nil {
+			if m, _, err = rec.Header.NewMatcher(); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		groups, _ := m.ApplyWithGroups(rec.Operation)
+		for _, g := range groups {
+			replayed = append(replayed, g.PlayerIds())
+		}
+	}
+	if len(matched) < 100 || !reflect.DeepEqual(matched, replayed) {
+		t.Errorf("matched %d groups, replayed %d groups", len(matched), len(replayed))
+	}
+}
@@ -0,0 +1,119 @@
+package agent
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/ganlvtech/go-game-matching/matcher"
+)
+
+// 保存快照，先写入临时文件再重命名，避免写到一半时进程退出导致快照损坏
+// 批量写入缓冲区中的操作会先执行，保证快照包含已经返回成功的请求
+func (s *HttpMatchingServer) SaveSnapshot(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushIngestion()
+	return s.saveSnapshot(path)
+}
+
+func (s *HttpMatchingServer) saveSnapshot(path string) error {
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	err = s.Matcher.WriteSnapshot(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// 把匹配器的快照写入 w
+func (s *HttpMatchingServer) WriteSnapshot(w io.Writer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushIngestion()
+	return s.Matcher.WriteSnapshot(w)
+}
+
+func (s *HttpMatchingServer) LoadSnapshot(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Matcher.ReadSnapshot(bufio.NewReader(f))
+}
+
+// 重放已有的操作日志，然后打开日志继续追加，应该在 LoadSnapshot 之后调用
+func (s *HttpMatchingServer) OpenJournal(path string, policy matcher.SyncPolicy, syncInterval time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := 0
+	if f, err := os.Open(path); err == nil {
+		count, err = s.Matcher.ReplayJournal(bufio.NewReader(f))
+		_ = f.Close()
+		if err != nil {
+			return count, err
+		}
+	} else if !os.IsNotExist(err) {
+		return 0, err
+	}
+	j, err := matcher.OpenJournal(path, s.Matcher.AppliedSeq(), policy, syncInterval)
+	if err != nil {
+		return count, err
+	}
+	s.Journal = j
+	return count, nil
+}
+
+// 操作日志写入失败之后拒绝全部写入，见 HttpMatchingServer.apply
+var ErrJournalFailed = errors.New("journal append failed, server is read-only until the journal is compacted")
+
+// 保存快照后清空操作日志，日志写入失败之后压缩成功即可恢复写入
+func (s *HttpMatchingServer) CompactJournal(snapshotPath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushIngestion()
+	if err := s.saveSnapshot(snapshotPath); err != nil {
+		return err
+	}
+	if s.Journal == nil {
+		return nil
+	}
+	if err := s.Journal.Truncate(); err != nil {
+		return err
+	}
+	s.journalErr = nil
+	return nil
+}
+
+// 关闭前先执行批量写入缓冲区中的操作，使它们写入日志
+func (s *HttpMatchingServer) CloseJournal() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushIngestion()
+	if s.Journal == nil {
+		return nil
+	}
+	err := s.Journal.Close()
+	s.Journal = nil
+	return err
+}
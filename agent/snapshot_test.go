@@ -0,0 +1,102 @@
+package agent_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ganlvtech/go-game-matching/agent"
+	"github.com/ganlvtech/go-game-matching/matcher"
+)
+
+func TestHttpMatchingServer_SnapshotFlushesIngestion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	snapshotPath := filepath.Join(dir, "snapshot.json")
+	journalPath := filepath.Join(dir, "journal.jsonl")
+
+	s := newReplicationServer()
+	s.EnableBatchedIngestion()
+	if _, err := s.OpenJournal(journalPath, matcher.SyncAlways, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// 缓冲区中的加入请求在保存快照前执行
+	request(s, "/join?id=a&score=100")
+	if err := s.SaveSnapshot(snapshotPath); err != nil {
+		t.Fatal(err)
+	}
+	restored := newReplicationServer()
+	if err := restored.LoadSnapshot(snapshotPath); err != nil {
+		t.Fatal(err)
+	}
+	if !restored.Matcher.Exists("a") {
+		t.Error("snapshot is missing a buffered join")
+	}
+
+	// 缓冲区中的加入请求在压缩日志时写入快照
+	request(s, "/join?id=b&score=100")
+	if err := s.CompactJournal(snapshotPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.LoadSnapshot(snapshotPath); err != nil {
+		t.Fatal(err)
+	}
+	if !restored.Matcher.Exists("b") {
+		t.Error("compacted snapshot is missing a buffered join")
+	}
+
+	// 缓冲区中的加入请求在关闭日志前写入日志
+	request(s, "/join?id=c&score=100")
+	if err := s.CloseJournal(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(journalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := restored.Matcher.ReplayJournal(bufio.NewReader(f)); err != nil {
+		t.Fatal(err)
+	}
+	if !restored.Matcher.Exists("c") {
+		t.Error("journal is missing a buffered join")
+	}
+	if !s.Matcher.Exists("c") {
+		t.Error("buffered join was not applied")
+	}
+}
+
+func TestHttpMatchingServer_JournalAppendFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	journalPath := filepath.Join(dir, "journal.jsonl")
+
+	s := newReplicationServer()
+	if _, err := s.OpenJournal(journalPath, matcher.SyncAlways, 0); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "b", "c", "d"} {
+		request(s, "/join?id="+id+"&score=100")
+	}
+	// 关闭日志文件使之后的写入失败，match 已经执行，之后拒绝全部写入
+	_ = s.Journal.Close()
+	s.Match(s.Now(), 4)
+	if len(s.Matcher.Groups()) != 1 {
+		t.Fatalf("match was not applied, %d groups", len(s.Matcher.Groups()))
+	}
+	r := &agent.HttpJsonResponse{}
+	_ = json.Unmarshal(request(s, "/join?id=e&score=100").Response.Body(), r)
+	if r.Code == 0 || s.Matcher.Exists("e") {
+		t.Errorf("join accepted after journal failure, response %+v", r)
+	}
+}
@@ -0,0 +1,363 @@
+// 重放服务器使用 -record 记录的流量
+//
+// 默认按记录时的配置重放，检查形成的小组与记录完全相同。-step 每次匹配后暂停，显示这一次匹配的结果。
+// 指定 -match_count -radius_curve -target_wait_time 中的任意一个时，同时用修改后的配置再重放一次，比较两次的结果。
+//
+//	go run ./cmd/replay -step traffic.jsonl
+//	go run ./cmd/replay -radius_curve '{"type":"linear","base":5,"rate":1}' traffic.jsonl
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ganlvtech/go-game-matching/internal/cli"
+	"github.com/ganlvtech/go-game-matching/matcher"
+)
+
+var step bool
+var matchCount int
+var radiusCurve string
+var targetWaitTime int
+var maxMismatches int
+
+func init() {
+	flag.BoolVar(&step, "step", false, "每次匹配后暂停，回车继续下一次，c 不再暂停，q 退出")
+	flag.IntVar(&matchCount, "match_count", 0, "比较用的每组匹配人数，0 表示使用记录中的人数")
+	flag.StringVar(&radiusCurve, "radius_curve", "", "比较用的分数容忍区间曲线，JSON 格式，以 @ 开头则从文件读取，指定后忽略记录中的曲线修改")
+	flag.IntVar(&targetWaitTime, "target_wait_time", 0, "比较用的自适应分数容忍区间目标等待时间，单位为秒，小于 0 表示关闭自适应，0 表示与记录相同")
+	flag.IntVar(&maxMismatches, "max_mismatches", 10, "最多显示多少条与记录不一致的操作")
+}
+
+// 一次重放，base 按记录的配置，alt 按修改后的配置
+type run struct {
+	name    string
+	alt     bool
+	curve   *matcher.RadiusCurve
+	m       *matcher.Matcher
+	sub     *matcher.EventSubscription
+	groups  map[string]bool             // 按玩家 id 排序拼接作为小组的 key
+	players map[matcher.PlayerId]string // 玩家所在小组的 key
+	waits   []float64                   // 秒
+	sds     []float64                   // 小组分数标准差
+	ticks   int
+	timeout int
+	errors  int
+	dropped int64            // 丢失的事件数，不为 0 时超时人数不准确
+	last    []*matcher.Group // 最近一次操作形成的小组
+}
+
+func newRun(name string, alt bool) *run {
+	return &run{
+		name:    name,
+		alt:     alt,
+		groups:  make(map[string]bool),
+		players: make(map[matcher.PlayerId]string),
+	}
+}
+
+func (r *run) reset(h *matcher.TrafficHeader) error {
+	if r.sub != nil {
+		r.dropped += r.sub.Dropped()
+	}
+	if r.alt && targetWaitTime != 0 {
+		copied := *h
+		h = &copied
+		if targetWaitTime < 0 {
+			h.AdaptiveRadius = nil
+		} else {
+			count := matchCount
+			if count == 0 && h.AdaptiveRadius != nil {
+				count = h.AdaptiveRadius.MatchCount
+			}
+			if count == 0 {
+				count = 25
+			}
+			target := float64(time.Duration(targetWaitTime) * time.Second / h.TimeUnit)
+			targets := make([]float64, (int(h.MaxScore)+h.ScoreGroupLen-1)/h.ScoreGroupLen)
+			for i := range targets {
+				targets[i] = target
+			}
+			h.AdaptiveRadius = &matcher.TrafficAdaptiveRadius{MatchCount: count, TargetWaitTimes: targets}
+		}
+	}
+	m, _, err := h.NewMatcher()
+	if err != nil {
+		return err
+	}
+	if r.alt && r.curve != nil {
+		if err := m.SetRadiusCurve(r.curve); err != nil {
+			return err
+		}
+	}
+	r.m = m
+	// 每次操作之后都会读空，只有一次操作超时的人数超过缓冲区时才会丢失
+	r.sub = m.Events.Subscribe(1 << 16)
+	return nil
+}
+
+func (r *run) apply(op matcher.Operation) error {
+	if r.alt {
+		if op.Type == matcher.OperationRadiusCurve && r.curve != nil {
+			return nil
+		}
+		if op.Type == matcher.OperationMatch {
+			// 配置不同时记录的处理人数没有意义，不限制预算
+			op.Processed = 0
+			if matchCount > 0 {
+				op.Count = matchCount
+			}
+		}
+	}
+	groups, err := r.m.ApplyWithGroups(&op)
+	if err != nil {
+		r.errors++
+	}
+	if op.Type == matcher.OperationMatch {
+		r.ticks++
+	}
+	r.last = groups
+	for _, g := range groups {
+		key := groupKey(g.PlayerIds())
+		r.groups[key] = true
+		for _, p := range g.Players {
+			r.players[p.Id] = key
+			r.waits = append(r.waits, r.m.TimeToDuration(op.Time-p.JoinTime).Seconds())
+		}
+		r.sds = append(r.sds, g.StandardDeviation())
+	}
+	for {
+		select {
+		case e := <-r.sub.C:
+			if e.Type == matcher.EventPlayerTimedOut {
+				r.timeout++
+			}
+		default:
+			return err
+		}
+	}
+}
+
+func groupKey(ids []matcher.PlayerId) string {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = string(id)
+	}
+	sort.Strings(s)
+	return strings.Join(s, ",")
+}
+
+func sameGroups(a []*matcher.Group, b []matcher.TrafficGroup) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i, g := range a {
+		if g.Id != b[i].Id {
+			return false
+		}
+		ids := g.PlayerIds()
+		if len(ids) != len(b[i].PlayerIds) {
+			return false
+		}
+		for j := range ids {
+			if ids[j] != b[i].PlayerIds[j] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() < 1 {
+		log.Fatal("Usage: replay [flags] traffic.jsonl")
+	}
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	base := newRun("recorded", false)
+	var alt *run
+	if matchCount > 0 || radiusCurve != "" || targetWaitTime != 0 {
+		alt = newRun("alt", true)
+		if alt.curve, err = cli.LoadRadiusCurve(radiusCurve); err != nil {
+			log.Fatal(err)
+		}
+	}
+	runs := []*run{base}
+	if alt != nil {
+		runs = append(runs, alt)
+	}
+
+	stdin := bufio.NewReader(os.Stdin)
+	reader := matcher.NewTrafficReader(f)
+	records := 0
+	mismatches := 0
+	for {
+		rec, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		records++
+		if rec.Header != nil {
+			for _, r := range runs {
+				if err := r.reset(rec.Header); err != nil {
+					log.Fatal(err)
+				}
+			}
+			fmt.Printf("header at %s\n", time.Unix(0, rec.Header.At).Format(time.RFC3339Nano))
+			continue
+		}
+		for _, r := range runs {
+			err := r.apply(*rec.Operation)
+			if r.alt {
+				continue
+			}
+			errString := ""
+			if err != nil {
+				errString = err.Error()
+			}
+			if errString != rec.Error || !sameGroups(r.last, rec.Groups) {
+				mismatches++
+				if mismatches <= maxMismatches {
+					fmt.Printf("mismatch at record %d (%s %s): recorded error %q groups %d, replayed error %q groups %d\n",
+						records, rec.Type, rec.Id, rec.Error, len(rec.Groups), errString, len(r.last))
+				}
+			}
+		}
+		if step && rec.Type == matcher.OperationMatch {
+			printTick(base.ticks, rec, runs)
+			fmt.Print("[enter] next, c continue, q quit > ")
+			line, _ := stdin.ReadString('\n')
+			switch strings.TrimSpace(line) {
+			case "c":
+				step = false
+			case "q":
+				return
+			}
+		}
+	}
+
+	fmt.Printf("records %d, mismatches %d\n", records, mismatches)
+	printSummary(runs)
+	if alt != nil {
+		printDiff(base, alt)
+	}
+	if mismatches > 0 {
+		os.Exit(1)
+	}
+}
+
+func printTick(tick int, rec *matcher.TrafficRecord, runs []*run) {
+	fmt.Printf("tick %d at %s, time %d, count %d, processed %d\n",
+		tick, time.Unix(0, rec.At).Format(time.RFC3339Nano), rec.Time, rec.Count, rec.Processed)
+	for _, r := range runs {
+		fmt.Printf("  %s: queue %d, groups %d\n", r.name, r.m.PlayerInQueueCount(), len(r.last))
+		for _, g := range r.last {
+			fmt.Printf("    #%d sd %.2f %v\n", g.Id, g.StandardDeviation(), g.PlayerIds())
+		}
+	}
+}
+
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	k := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if k < 0 {
+		k = 0
+	}
+	return sorted[k]
+}
+
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+func printSummary(runs []*run) {
+	rows := [][]string{{"", "ticks", "groups", "matched", "timed_out", "errors", "wait_mean", "wait_p50", "wait_p90", "wait_p99", "group_sd"}}
+	for _, r := range runs {
+		if r.sub != nil {
+			r.dropped += r.sub.Dropped()
+			r.sub = nil
+		}
+		if r.dropped > 0 {
+			log.Printf("%s: %d events dropped, timed_out is incomplete", r.name, r.dropped)
+		}
+		sorted := append([]float64(nil), r.waits...)
+		sort.Float64s(sorted)
+		rows = append(rows, []string{
+			r.name,
+			strconv.Itoa(r.ticks),
+			strconv.Itoa(len(r.groups)),
+			strconv.Itoa(len(r.players)),
+			strconv.Itoa(r.timeout),
+			strconv.Itoa(r.errors),
+			strconv.FormatFloat(mean(r.waits), 'f', 3, 64),
+			strconv.FormatFloat(percentile(sorted, 50), 'f', 3, 64),
+			strconv.FormatFloat(percentile(sorted, 90), 'f', 3, 64),
+			strconv.FormatFloat(percentile(sorted, 99), 'f', 3, 64),
+			strconv.FormatFloat(mean(r.sds), 'f', 3, 64),
+		})
+	}
+	for _, row := range rows {
+		for i, cell := range row {
+			fmt.Printf("%-10s", cell)
+			if i < len(row)-1 {
+				fmt.Print(" ")
+			}
+		}
+		fmt.Println()
+	}
+}
+
+func printDiff(base *run, alt *run) {
+	same := 0
+	for key := range base.groups {
+		if alt.groups[key] {
+			same++
+		}
+	}
+	changed := 0
+	onlyBase := 0
+	for id, key := range base.players {
+		altKey, ok := alt.players[id]
+		if !ok {
+			onlyBase++
+		} else if altKey != key {
+			changed++
+		}
+	}
+	onlyAlt := 0
+	for id := range alt.players {
+		if _, ok := base.players[id]; !ok {
+			onlyAlt++
+		}
+	}
+	fmt.Printf("identical groups %d of %d recorded, %d alt\n", same, len(base.groups), len(alt.groups))
+	fmt.Printf("players in a different group %d, matched only in recorded %d, matched only in alt %d\n", changed, onlyBase, onlyAlt)
+}
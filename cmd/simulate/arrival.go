@@ -0,0 +1,144 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 突发流量，从 Start 开始持续 Duration，期间到达速度乘以 Multiplier
+type burst struct {
+	Start      time.Duration
+	Duration   time.Duration
+	Multiplier float64
+}
+
+// 解析 "3h:15m:4,14h:30m:2"
+func parseBursts(s string) ([]burst, error) {
+	bursts := make([]burst, 0)
+	if s == "" {
+		return bursts, nil
+	}
+	for _, item := range strings.Split(s, ",") {
+		parts := strings.Split(item, ":")
+		if len(parts) != 3 {
+			return nil, &burstFormatError{item}
+		}
+		start, err := time.ParseDuration(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		duration, err := time.ParseDuration(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		multiplier, err := strconv.ParseFloat(parts[2], 64)
+		if err != nil {
+			return nil, err
+		}
+		bursts = append(bursts, burst{Start: start, Duration: duration, Multiplier: multiplier})
+	}
+	return bursts, nil
+}
+
+type burstFormatError struct {
+	item string
+}
+
+func (e *burstFormatError) Error() string {
+	return "burst should be start:duration:multiplier, got " + strconv.Quote(e.item)
+}
+
+// 到达过程，每秒平均到达 Rate 人，按一天中的时间和突发流量调整
+type arrivalProcess struct {
+	Rate             float64
+	DiurnalAmplitude float64       // 0 表示全天相同，1 表示低谷时没有人
+	DiurnalPeak      time.Duration // 一天中人最多的时刻
+	Bursts           []burst
+	r                *rand.Rand
+}
+
+// 模拟开始后 elapsed 时刻每秒的平均到达人数
+func (a *arrivalProcess) rateAt(elapsed time.Duration) float64 {
+	rate := a.Rate
+	if a.DiurnalAmplitude > 0 {
+		phase := float64(elapsed-a.DiurnalPeak) / float64(24*time.Hour) * 2 * math.Pi
+		rate *= 1 + a.DiurnalAmplitude*math.Cos(phase)
+	}
+	for _, b := range a.Bursts {
+		if elapsed >= b.Start && elapsed < b.Start+b.Duration {
+			rate *= b.Multiplier
+		}
+	}
+	if rate < 0 {
+		rate = 0
+	}
+	return rate
+}
+
+// 一步 step 内到达的人数，服从泊松分布
+func (a *arrivalProcess) arrivals(elapsed time.Duration, step time.Duration) int {
+	return poisson(a.r, a.rateAt(elapsed)*step.Seconds())
+}
+
+func poisson(r *rand.Rand, lambda float64) int {
+	if lambda <= 0 {
+		return 0
+	}
+	if lambda > 30 {
+		// 均值较大时用正态分布近似
+		n := int(math.Round(lambda + math.Sqrt(lambda)*r.NormFloat64()))
+		if n < 0 {
+			n = 0
+		}
+		return n
+	}
+	l := math.Exp(-lambda)
+	k := 0
+	p := 1.0
+	for {
+		p *= r.Float64()
+		if p <= l {
+			return k
+		}
+		k++
+	}
+}
+
+const (
+	scoreDistNormal  = "normal"
+	scoreDistUniform = "uniform"
+	scoreDistSkewed  = "skewed"
+)
+
+// 分数分布，skewed 为偏正态分布，Skew 为正时右侧拖尾，为负时左侧拖尾
+type scoreDistribution struct {
+	Type     string
+	Mean     float64
+	SD       float64
+	Skew     float64
+	MaxScore int
+	r        *rand.Rand
+}
+
+func (d *scoreDistribution) sample() int {
+	var x float64
+	switch d.Type {
+	case scoreDistUniform:
+		return d.r.Intn(d.MaxScore)
+	case scoreDistSkewed:
+		delta := d.Skew / math.Sqrt(1+d.Skew*d.Skew)
+		x = delta*math.Abs(d.r.NormFloat64()) + math.Sqrt(1-delta*delta)*d.r.NormFloat64()
+	default:
+		x = d.r.NormFloat64()
+	}
+	score := int(d.Mean + d.SD*x)
+	if score < 0 {
+		score = 0
+	} else if score >= d.MaxScore {
+		score = d.MaxScore - 1
+	}
+	return score
+}
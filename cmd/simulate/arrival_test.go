@@ -0,0 +1,82 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestParseBursts(t *testing.T) {
+	bursts, err := parseBursts("3h:15m:4,14h:30m:2.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []burst{
+		{Start: 3 * time.Hour, Duration: 15 * time.Minute, Multiplier: 4},
+		{Start: 14 * time.Hour, Duration: 30 * time.Minute, Multiplier: 2.5},
+	}
+	if len(bursts) != len(want) || bursts[0] != want[0] || bursts[1] != want[1] {
+		t.Errorf("bursts %+v, want %+v", bursts, want)
+	}
+	if bursts, err := parseBursts(""); err != nil || len(bursts) != 0 {
+		t.Errorf("empty bursts %+v, %v", bursts, err)
+	}
+	for _, s := range []string{"3h:15m", "3x:15m:4", "3h:15x:4", "3h:15m:x"} {
+		if _, err := parseBursts(s); err == nil {
+			t.Errorf("%q should fail", s)
+		}
+	}
+}
+
+func TestArrivalProcess_RateAt(t *testing.T) {
+	a := &arrivalProcess{
+		Rate:             10,
+		DiurnalAmplitude: 0.5,
+		DiurnalPeak:      20 * time.Hour,
+		Bursts:           []burst{{Start: 3 * time.Hour, Duration: time.Hour, Multiplier: 4}},
+	}
+	for _, c := range []struct {
+		elapsed time.Duration
+		want    float64
+	}{
+		{20 * time.Hour, 15},
+		{8 * time.Hour, 5},
+		{2 * time.Hour, 10 * (1 + 0.5*math.Cos(-18.0/24*2*math.Pi))},
+		{3*time.Hour + 30*time.Minute, 4 * 10 * (1 + 0.5*math.Cos(-16.5/24*2*math.Pi))},
+		{4 * time.Hour, 10 * (1 + 0.5*math.Cos(-16.0/24*2*math.Pi))},
+	} {
+		if got := a.rateAt(c.elapsed); math.Abs(got-c.want) > 1e-9 {
+			t.Errorf("rate at %v = %v, want %v", c.elapsed, got, c.want)
+		}
+	}
+	a.DiurnalAmplitude = 2
+	if got := a.rateAt(8 * time.Hour); got != 0 {
+		t.Errorf("rate should not be negative, got %v", got)
+	}
+}
+
+func TestPoisson(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	if n := poisson(r, 0); n != 0 {
+		t.Errorf("poisson(0) = %d", n)
+	}
+	for _, lambda := range []float64{2, 100} {
+		const count = 20000
+		sum := 0.0
+		sumSquares := 0.0
+		for i := 0; i < count; i++ {
+			n := float64(poisson(r, lambda))
+			if n < 0 {
+				t.Fatalf("poisson(%v) = %v", lambda, n)
+			}
+			sum += n
+			sumSquares += n * n
+		}
+		mean := sum / count
+		variance := sumSquares/count - mean*mean
+		if math.Abs(mean-lambda) > lambda*0.05 || math.Abs(variance-lambda) > lambda*0.1 {
+			t.Errorf("poisson(%v) mean %v, variance %v", lambda, mean, variance)
+		}
+	}
+}
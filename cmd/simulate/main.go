@@ -0,0 +1,210 @@
+// 离线匹配模拟器，不启动服务器，按设定的到达过程和分数分布直接驱动匹配器，输出各分段的等待时间、超时率和小组分数标准差报告
+//
+//	go run ./cmd/simulate -duration 24h -rate 40 -bursts 20h:1h:3 -out report
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/ganlvtech/go-game-matching/internal/cli"
+	"github.com/ganlvtech/go-game-matching/matcher"
+)
+
+var duration time.Duration
+var step time.Duration
+var matchInterval time.Duration
+var timeUnit time.Duration
+var maxTime int
+var maxScore int
+var scoreGroupLen int
+var matchCount int
+var radiusCurve string
+var adaptiveRadius bool
+var targetWaitTime int
+var rate float64
+var diurnalAmplitude float64
+var diurnalPeak time.Duration
+var bursts string
+var scoreDist string
+var scoreMean float64
+var scoreSD float64
+var scoreSkew float64
+var seed int64
+var out string
+var sdBucket float64
+var estimator cli.EstimatorConfig
+
+func init() {
+	flag.DurationVar(&duration, "duration", 24*time.Hour, "模拟的总时长")
+	flag.DurationVar(&step, "step", time.Second, "模拟的时间步长，每步生成一批到达的玩家")
+	flag.DurationVar(&matchInterval, "match_interval", time.Second, "两次匹配的间隔")
+	flag.DurationVar(&timeUnit, "time_unit", time.Second, "匹配器中时间的单位")
+	flag.IntVar(&maxTime, "max_time", 180, "最长匹配时间，单位为秒")
+	flag.IntVar(&maxScore, "max_score", 300, "最大分数")
+	flag.IntVar(&scoreGroupLen, "score_group_len", 10, "每一分段长度")
+	flag.IntVar(&matchCount, "match_count", 25, "每组匹配人数")
+	flag.StringVar(&radiusCurve, "radius_curve", "", "分数容忍区间曲线，JSON 格式，以 @ 开头则从文件读取")
+	flag.BoolVar(&adaptiveRadius, "adaptive_radius", false, "根据分段人数、加入速度和目标等待时间自动调整分数容忍区间")
+	flag.IntVar(&targetWaitTime, "target_wait_time", 30, "自适应分数容忍区间的目标等待时间，单位为秒")
+	flag.Float64Var(&rate, "rate", 40, "每秒平均加入人数")
+	flag.Float64Var(&diurnalAmplitude, "diurnal_amplitude", 0.5, "一天内加入速度的波动幅度，0 表示全天相同")
+	flag.DurationVar(&diurnalPeak, "diurnal_peak", 20*time.Hour, "一天中加入速度最高的时刻，相对模拟开始的时间")
+	flag.StringVar(&bursts, "bursts", "", "突发流量，格式为 开始:持续时间:倍数，多个用逗号分隔，例如 3h:15m:4,14h:30m:2")
+	flag.StringVar(&scoreDist, "score_dist", scoreDistNormal, "分数分布：normal uniform skewed")
+	flag.Float64Var(&scoreMean, "score_mean", 150, "分数均值（normal）或位置参数（skewed）")
+	flag.Float64Var(&scoreSD, "score_sd", 50, "分数标准差（normal）或尺度参数（skewed）")
+	flag.Float64Var(&scoreSkew, "score_skew", 4, "skewed 分布的偏度参数")
+	flag.Int64Var(&seed, "seed", 1, "随机数种子，相同的参数和种子得到相同的结果")
+	flag.StringVar(&out, "out", "simulation", "报告文件名前缀，生成 <out>_bands.csv <out>_group_sd.csv <out>_hours.csv <out>.html")
+	flag.Float64Var(&sdBucket, "sd_bucket", 1, "小组分数标准差分布的分档宽度")
+	flag.StringVar(&estimator.Name, "estimator", matcher.EstimatorGaussian, "预计等待时间的算法：gaussian ewma quantile，报告中会比较预计值与实际等待时间")
+	flag.IntVar(&estimator.HalfLife, "estimator_half_life", 60, "ewma 的半衰期，单位为秒")
+	flag.IntVar(&estimator.Window, "estimator_window", 256, "quantile 每个分段统计最近多少个匹配成功的玩家")
+	flag.BoolVar(&estimator.QueueETA, "queue_eta", false, "同时根据分段中正在等待的人数和加入速度估计等待时间，安静一段时间之后不会全部变成最长等待时间")
+}
+
+func main() {
+	flag.Parse()
+
+	burstList, err := parseBursts(bursts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if scoreDist != scoreDistNormal && scoreDist != scoreDistUniform && scoreDist != scoreDistSkewed {
+		log.Fatal("Unknown score distribution " + scoreDist)
+	}
+	r := rand.New(rand.NewSource(seed))
+	arrival := &arrivalProcess{
+		Rate:             rate,
+		DiurnalAmplitude: diurnalAmplitude,
+		DiurnalPeak:      diurnalPeak,
+		Bursts:           burstList,
+		r:                r,
+	}
+	scores := &scoreDistribution{
+		Type:     scoreDist,
+		Mean:     scoreMean,
+		SD:       scoreSD,
+		Skew:     scoreSkew,
+		MaxScore: maxScore,
+		r:        r,
+	}
+
+	m := matcher.NewMatcherWithTimeUnit(cli.SecondsToTime(maxTime, timeUnit), matcher.PlayerScore(maxScore), scoreGroupLen, timeUnit)
+	if err := estimator.Apply(m); err != nil {
+		log.Fatal(err)
+	}
+	c, err := cli.LoadRadiusCurve(radiusCurve)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if c != nil {
+		if err := m.SetRadiusCurve(c); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if adaptiveRadius {
+		a := matcher.NewAdaptiveScoreRadius(m, matchCount, float64(cli.SecondsToTime(targetWaitTime, timeUnit)))
+		m.SetAdaptiveScoreRadius(a)
+	}
+	clock := matcher.NewVirtualClock(time.Unix(1600000000, 0))
+	m.Clock = clock
+	start := clock.Now()
+
+	rep := newReport(m.ScoreBandCount(), scoreGroupLen, m.TimeUnit().Seconds())
+	var elapsed time.Duration
+	var currentTime matcher.Time
+	m.OnGroupMatchedEventCallback = func(g *matcher.Group) {
+		h := rep.hour(int(elapsed / time.Hour))
+		for _, p := range g.Players {
+			wait := m.TimeToDuration(currentTime - p.JoinTime).Seconds()
+			b := rep.bands[m.ScoreBandIndex(p.Score)]
+			b.Matched++
+			b.waits = append(b.waits, wait)
+			h.Matched++
+			h.waitSum += wait
+		}
+		rep.groupSDs = append(rep.groupSDs, g.StandardDeviation())
+	}
+
+	wallStart := time.Now()
+	nextId := 0
+	var nextMatch, nextSweep time.Duration
+	sweepInterval := time.Duration(maxTime) * time.Second
+	for elapsed = 0; elapsed < duration; elapsed += step {
+		clock.Set(start.Add(elapsed))
+		currentTime = m.TimeOf(clock.Now())
+		n := arrival.arrivals(elapsed, step)
+		rep.hour(int(elapsed / time.Hour)).Arrivals += n
+		for i := 0; i < n; i++ {
+			nextId++
+			score := scores.sample()
+			if err := m.JoinQueue(matcher.PlayerId("p"+strconv.Itoa(nextId)), currentTime, matcher.PlayerScore(score)); err != nil {
+				log.Fatal(err)
+			}
+			rep.bands[m.ScoreBandIndex(matcher.PlayerScore(score))].Joined++
+		}
+		if elapsed >= nextMatch {
+			m.Match(currentTime, matchCount)
+			nextMatch = elapsed + matchInterval
+		}
+		if elapsed >= nextSweep {
+			// 已经匹配成功的小组会一直保留，定期清理以免占用过多内存
+			m.Sweep(m.TimeOf(clock.Now().Add(-2 * sweepInterval)))
+			nextSweep = elapsed + sweepInterval
+		}
+	}
+	wallTime := time.Since(wallStart)
+
+	predictions := m.PredictionStats()
+	for _, b := range rep.bands {
+		b.InQueue = m.BandPlayerInQueueCount(b.Band)
+		b.TimedOut = b.Joined - b.Matched - b.InQueue
+		b.prediction = predictions[b.Band]
+	}
+	total := rep.total()
+	p := percentiles(total.waits, 50, 90, 99)
+	summary := [][2]string{
+		{"duration", duration.String()},
+		{"seed", strconv.FormatInt(seed, 10)},
+		{"joined", strconv.Itoa(total.Joined)},
+		{"matched", strconv.Itoa(total.Matched)},
+		{"timed_out", strconv.Itoa(total.TimedOut)},
+		{"in_queue", strconv.Itoa(total.InQueue)},
+		{"timeout_rate", formatFloat(total.timeoutRate())},
+		{"groups", strconv.Itoa(len(rep.groupSDs))},
+		{"group_sd_mean", formatFloat(mean(rep.groupSDs))},
+		{"wait_mean", formatFloat(mean(total.waits))},
+		{"wait_p50", formatFloat(p[0])},
+		{"wait_p90", formatFloat(p[1])},
+		{"wait_p99", formatFloat(p[2])},
+		{"estimator", m.Estimator().Name()},
+		{"prediction_bias", formatFloat(total.prediction.Bias() * rep.unitSeconds)},
+		{"prediction_mae", formatFloat(total.prediction.MAE() * rep.unitSeconds)},
+		{"within_expected", formatFloat(total.prediction.WithinExpected())},
+		{"within_high", formatFloat(total.prediction.WithinHigh())},
+		{"wall_time", wallTime.String()},
+	}
+	for _, kv := range summary {
+		fmt.Printf("%-16s %s\n", kv[0], kv[1])
+	}
+
+	if err := writeCSV(out+"_bands.csv", rep.bandRows()); err != nil {
+		log.Fatal(err)
+	}
+	if err := writeCSV(out+"_group_sd.csv", rep.groupSDRows(sdBucket)); err != nil {
+		log.Fatal(err)
+	}
+	if err := writeCSV(out+"_hours.csv", rep.hourRows()); err != nil {
+		log.Fatal(err)
+	}
+	if err := rep.writeHTML(out+".html", "匹配模拟报告", summary, sdBucket); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Report written to " + out + ".html")
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ganlvtech/go-game-matching/matcher"
+)
+
+type bandReport struct {
+	Band     int
+	ScoreMin int
+	ScoreMax int
+	Joined   int
+	Matched  int
+	TimedOut int
+	InQueue  int
+	waits    []float64 // 秒
+
+	prediction matcher.PredictionStats // 以匹配器的时间单位为单位
+}
+
+func (b *bandReport) timeoutRate() float64 {
+	finished := b.Matched + b.TimedOut
+	if finished == 0 {
+		return 0
+	}
+	return float64(b.TimedOut) / float64(finished)
+}
+
+type hourReport struct {
+	Arrivals int
+	Matched  int
+	waitSum  float64
+}
+
+func (h *hourReport) meanWait() float64 {
+	if h.Matched == 0 {
+		return 0
+	}
+	return h.waitSum / float64(h.Matched)
+}
+
+type report struct {
+	unitSeconds float64 // 匹配器的时间单位是多少秒
+	bands       []*bandReport
+	hours       []*hourReport
+	groupSDs    []float64
+}
+
+func newReport(bandCount int, scoreGroupLen int, unitSeconds float64) *report {
+	r := &report{
+		unitSeconds: unitSeconds,
+		bands:       make([]*bandReport, bandCount),
+		hours:       make([]*hourReport, 0),
+	}
+	for i := range r.bands {
+		r.bands[i] = &bandReport{Band: i, ScoreMin: i * scoreGroupLen, ScoreMax: (i+1)*scoreGroupLen - 1}
+	}
+	return r
+}
+
+func (r *report) hour(i int) *hourReport {
+	for len(r.hours) <= i {
+		r.hours = append(r.hours, &hourReport{})
+	}
+	return r.hours[i]
+}
+
+func (r *report) total() *bandReport {
+	t := &bandReport{Band: -1, ScoreMax: r.bands[len(r.bands)-1].ScoreMax}
+	for _, b := range r.bands {
+		t.Joined += b.Joined
+		t.Matched += b.Matched
+		t.TimedOut += b.TimedOut
+		t.InQueue += b.InQueue
+		t.prediction.Merge(b.prediction)
+		t.waits = append(t.waits, b.waits...)
+	}
+	return t
+}
+
+// 最近秩法，ps 为 0 到 100
+func percentiles(values []float64, ps ...float64) []float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	result := make([]float64, len(ps))
+	if len(sorted) == 0 {
+		return result
+	}
+	for i, p := range ps {
+		k := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+		if k < 0 {
+			k = 0
+		}
+		result[i] = sorted[k]
+	}
+	return result
+}
+
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', 3, 64)
+}
+
+func writeCSV(path string, rows [][]string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	if err := w.WriteAll(rows); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (r *report) bandRows() [][]string {
+	rows := [][]string{{"band", "score_min", "score_max", "joined", "matched", "timed_out", "in_queue", "timeout_rate", "wait_mean", "wait_p50", "wait_p90", "wait_p99",
+		"prediction_count", "prediction_bias", "prediction_mae", "within_expected", "within_high"}}
+	for _, b := range append(r.bands, r.total()) {
+		p := percentiles(b.waits, 50, 90, 99)
+		band := strconv.Itoa(b.Band)
+		if b.Band < 0 {
+			band = "all"
+		}
+		rows = append(rows, []string{
+			band, strconv.Itoa(b.ScoreMin), strconv.Itoa(b.ScoreMax),
+			strconv.Itoa(b.Joined), strconv.Itoa(b.Matched), strconv.Itoa(b.TimedOut), strconv.Itoa(b.InQueue),
+			formatFloat(b.timeoutRate()), formatFloat(mean(b.waits)), formatFloat(p[0]), formatFloat(p[1]), formatFloat(p[2]),
+			strconv.Itoa(b.prediction.Count), formatFloat(b.prediction.Bias() * r.unitSeconds), formatFloat(b.prediction.MAE() * r.unitSeconds),
+			formatFloat(b.prediction.WithinExpected()), formatFloat(b.prediction.WithinHigh()),
+		})
+	}
+	return rows
+}
+
+// 小组分数标准差的分布，每 bucket 分一档
+func (r *report) groupSDHistogram(bucket float64) ([]float64, []int) {
+	maxSD := 0.0
+	for _, sd := range r.groupSDs {
+		maxSD = math.Max(maxSD, sd)
+	}
+	n := int(maxSD/bucket) + 1
+	lows := make([]float64, n)
+	counts := make([]int, n)
+	for i := range lows {
+		lows[i] = float64(i) * bucket
+	}
+	for _, sd := range r.groupSDs {
+		counts[int(sd/bucket)]++
+	}
+	return lows, counts
+}
+
+func (r *report) groupSDRows(bucket float64) [][]string {
+	rows := [][]string{{"sd_min", "sd_max", "groups"}}
+	lows, counts := r.groupSDHistogram(bucket)
+	for i := range lows {
+		rows = append(rows, []string{formatFloat(lows[i]), formatFloat(lows[i] + bucket), strconv.Itoa(counts[i])})
+	}
+	return rows
+}
+
+func (r *report) hourRows() [][]string {
+	rows := [][]string{{"hour", "arrivals", "matched", "wait_mean"}}
+	for i, h := range r.hours {
+		rows = append(rows, []string{strconv.Itoa(i), strconv.Itoa(h.Arrivals), strconv.Itoa(h.Matched), formatFloat(h.meanWait())})
+	}
+	return rows
+}
+
+type chartSeries struct {
+	Name   string
+	Color  string
+	Values []float64
+}
+
+const (
+	chartWidth   = 720
+	chartHeight  = 260
+	chartPadding = 40
+)
+
+// 折线图或柱状图，不依赖任何外部脚本
+func writeSVGChart(w io.Writer, title string, labels []string, series []chartSeries, bar bool) {
+	maxValue := 0.0
+	for _, s := range series {
+		for _, v := range s.Values {
+			maxValue = math.Max(maxValue, v)
+		}
+	}
+	if maxValue == 0 {
+		maxValue = 1
+	}
+	n := len(labels)
+	if n == 0 {
+		n = 1
+	}
+	plotW := float64(chartWidth - 2*chartPadding)
+	plotH := float64(chartHeight - 2*chartPadding)
+	x := func(i int) float64 {
+		return chartPadding + plotW*(float64(i)+0.5)/float64(n)
+	}
+	y := func(v float64) float64 {
+		return chartPadding + plotH*(1-v/maxValue)
+	}
+
+	_, _ = fmt.Fprintf(w, "<h2>%s</h2>\n<svg width=\"%d\" height=\"%d\" xmlns=\"http://www.w3.org/2000/svg\" font-size=\"10\">\n", html.EscapeString(title), chartWidth, chartHeight)
+	_, _ = fmt.Fprintf(w, "<line x1=\"%d\" y1=\"%d\" x2=\"%d\" y2=\"%d\" stroke=\"#999\"/>\n", chartPadding, chartHeight-chartPadding, chartWidth-chartPadding, chartHeight-chartPadding)
+	for k := 0; k <= 4; k++ {
+		v := maxValue * float64(k) / 4
+		_, _ = fmt.Fprintf(w, "<text x=\"%d\" y=\"%.1f\" text-anchor=\"end\">%s</text>\n", chartPadding-4, y(v)+3, strconv.FormatFloat(v, 'g', 4, 64))
+		_, _ = fmt.Fprintf(w, "<line x1=\"%d\" y1=\"%.1f\" x2=\"%d\" y2=\"%.1f\" stroke=\"#eee\"/>\n", chartPadding, y(v), chartWidth-chartPadding, y(v))
+	}
+	labelEvery := (len(labels) + 19) / 20
+	for i, label := range labels {
+		if labelEvery > 0 && i%labelEvery == 0 {
+			_, _ = fmt.Fprintf(w, "<text x=\"%.1f\" y=\"%d\" text-anchor=\"middle\">%s</text>\n", x(i), chartHeight-chartPadding+14, html.EscapeString(label))
+		}
+	}
+	barW := plotW / float64(n) / float64(len(series)+1)
+	for k, s := range series {
+		if bar {
+			for i, v := range s.Values {
+				_, _ = fmt.Fprintf(w, "<rect x=\"%.1f\" y=\"%.1f\" width=\"%.1f\" height=\"%.1f\" fill=\"%s\"><title>%s %s: %s</title></rect>\n",
+					x(i)-plotW/float64(n)/2+barW*(float64(k)+0.5), y(v), barW, y(0)-y(v), s.Color, html.EscapeString(s.Name), html.EscapeString(labels[i]), formatFloat(v))
+			}
+		} else {
+			points := make([]string, len(s.Values))
+			for i, v := range s.Values {
+				points[i] = fmt.Sprintf("%.1f,%.1f", x(i), y(v))
+			}
+			_, _ = fmt.Fprintf(w, "<polyline fill=\"none\" stroke=\"%s\" stroke-width=\"2\" points=\"%s\"/>\n", s.Color, strings.Join(points, " "))
+		}
+		_, _ = fmt.Fprintf(w, "<rect x=\"%d\" y=\"%d\" width=\"10\" height=\"10\" fill=\"%s\"/><text x=\"%d\" y=\"%d\">%s</text>\n",
+			chartPadding+k*120, 10, s.Color, chartPadding+k*120+14, 19, html.EscapeString(s.Name))
+	}
+	_, _ = fmt.Fprintln(w, "</svg>")
+}
+
+func (r *report) writeHTML(path string, title string, summary [][2]string, sdBucket float64) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := f
+	_, _ = fmt.Fprintf(w, "<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>%s</title>\n", html.EscapeString(title))
+	_, _ = fmt.Fprintln(w, "<style>body{font-family:sans-serif;margin:20px}table{border-collapse:collapse}td,th{border:1px solid #ccc;padding:2px 8px;text-align:right}</style></head><body>")
+	_, _ = fmt.Fprintf(w, "<h1>%s</h1>\n<table>\n", html.EscapeString(title))
+	for _, kv := range summary {
+		_, _ = fmt.Fprintf(w, "<tr><th>%s</th><td>%s</td></tr>\n", html.EscapeString(kv[0]), html.EscapeString(kv[1]))
+	}
+	_, _ = fmt.Fprintln(w, "</table>")
+
+	labels := make([]string, len(r.bands))
+	p50 := make([]float64, len(r.bands))
+	p90 := make([]float64, len(r.bands))
+	p99 := make([]float64, len(r.bands))
+	timeout := make([]float64, len(r.bands))
+	bias := make([]float64, len(r.bands))
+	mae := make([]float64, len(r.bands))
+	withinExpected := make([]float64, len(r.bands))
+	withinHigh := make([]float64, len(r.bands))
+	for i, b := range r.bands {
+		bias[i] = b.prediction.Bias() * r.unitSeconds
+		mae[i] = b.prediction.MAE() * r.unitSeconds
+		withinExpected[i] = b.prediction.WithinExpected() * 100
+		withinHigh[i] = b.prediction.WithinHigh() * 100
+		labels[i] = strconv.Itoa(b.ScoreMin)
+		p := percentiles(b.waits, 50, 90, 99)
+		p50[i], p90[i], p99[i] = p[0], p[1], p[2]
+		timeout[i] = b.timeoutRate() * 100
+	}
+	writeSVGChart(w, "各分段等待时间（秒）", labels, []chartSeries{
+		{Name: "p50", Color: "#4e79a7", Values: p50},
+		{Name: "p90", Color: "#f28e2b", Values: p90},
+		{Name: "p99", Color: "#e15759", Values: p99},
+	}, false)
+	writeSVGChart(w, "各分段超时率（%）", labels, []chartSeries{
+		{Name: "timeout", Color: "#e15759", Values: timeout},
+	}, true)
+
+	// 偏差可能为负数，图中只画绝对误差和偏差的绝对值，正负看 CSV
+	absBias := make([]float64, len(bias))
+	for i, v := range bias {
+		absBias[i] = math.Abs(v)
+	}
+	writeSVGChart(w, "各分段预计等待时间误差（秒）", labels, []chartSeries{
+		{Name: "mae", Color: "#4e79a7", Values: mae},
+		{Name: "|bias|", Color: "#e15759", Values: absBias},
+	}, false)
+	writeSVGChart(w, "各分段实际不超过预计的比例（%）", labels, []chartSeries{
+		{Name: "within expected", Color: "#f28e2b", Values: withinExpected},
+		{Name: "within high", Color: "#59a14f", Values: withinHigh},
+	}, false)
+
+	lows, counts := r.groupSDHistogram(sdBucket)
+	sdLabels := make([]string, len(lows))
+	sdCounts := make([]float64, len(lows))
+	for i := range lows {
+		sdLabels[i] = strconv.FormatFloat(lows[i], 'g', 4, 64)
+		sdCounts[i] = float64(counts[i])
+	}
+	writeSVGChart(w, "小组分数标准差分布", sdLabels, []chartSeries{
+		{Name: "groups", Color: "#59a14f", Values: sdCounts},
+	}, true)
+
+	hourLabels := make([]string, len(r.hours))
+	arrivals := make([]float64, len(r.hours))
+	waits := make([]float64, len(r.hours))
+	for i, h := range r.hours {
+		hourLabels[i] = strconv.Itoa(i)
+		arrivals[i] = float64(h.Arrivals)
+		waits[i] = h.meanWait()
+	}
+	writeSVGChart(w, "每小时加入人数", hourLabels, []chartSeries{
+		{Name: "arrivals", Color: "#4e79a7", Values: arrivals},
+	}, true)
+	writeSVGChart(w, "每小时平均等待时间（秒）", hourLabels, []chartSeries{
+		{Name: "wait", Color: "#f28e2b", Values: waits},
+	}, false)
+
+	_, _ = fmt.Fprintln(w, "</body></html>")
+	return f.Close()
+}
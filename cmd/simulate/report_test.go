@@ -0,0 +1,19 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPercentiles(t *testing.T) {
+	values := []float64{5, 1, 4, 2, 3, 10, 9, 8, 7, 6}
+	if got := percentiles(values, 0, 10, 50, 90, 99, 100); !reflect.DeepEqual(got, []float64{1, 1, 5, 9, 10, 10}) {
+		t.Errorf("percentiles %v", got)
+	}
+	if values[0] != 5 {
+		t.Error("percentiles should not sort the input")
+	}
+	if got := percentiles(nil, 50, 90); !reflect.DeepEqual(got, []float64{0, 0}) {
+		t.Errorf("percentiles of empty values %v", got)
+	}
+}
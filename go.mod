@@ -1,6 +1,6 @@
 module github.com/ganlvtech/go-game-matching
 
-go 1.13
+go 1.16
 
 require (
 	github.com/json-iterator/go v1.1.8
@@ -0,0 +1,66 @@
+// 服务器和 cmd 下各个工具共用的命令行参数处理
+package cli
+
+import (
+	"io/ioutil"
+	"time"
+
+	"github.com/ganlvtech/go-game-matching/matcher"
+)
+
+// 以秒为单位的参数转换为 unit 为单位的 Time
+func SecondsToTime(seconds int, unit time.Duration) matcher.Time {
+	return matcher.Time(time.Duration(seconds) * time.Second / unit)
+}
+
+// 解析 -radius_curve 参数，JSON 格式，以 @ 开头则从文件读取，为空时返回 nil
+func LoadRadiusCurve(spec string) (*matcher.RadiusCurve, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	if spec[0] == '@' {
+		b, err := ioutil.ReadFile(spec[1:])
+		if err != nil {
+			return nil, err
+		}
+		spec = string(b)
+	}
+	return matcher.ParseRadiusCurve(spec)
+}
+
+// 预计等待时间算法的参数，对应 -estimator -estimator_half_life -estimator_window -queue_eta
+type EstimatorConfig struct {
+	Name     string
+	HalfLife int // ewma 的半衰期，单位为秒
+	Window   int // quantile 每个分段统计的人数
+	QueueETA bool
+}
+
+// 为 m 创建预计等待时间算法，参数会保存在快照中，从快照恢复时使用快照中的参数
+func (c EstimatorConfig) NewEstimator(m *matcher.Matcher) (matcher.Estimator, error) {
+	var e matcher.Estimator
+	switch c.Name {
+	case matcher.EstimatorEWMA:
+		e = matcher.NewEWMAEstimator(m.ScoreBandCount(), float64(m.MaxTime()), float64(SecondsToTime(c.HalfLife, m.TimeUnit())))
+	case matcher.EstimatorQuantile:
+		e = matcher.NewQuantileEstimator(m.ScoreBandCount(), float64(m.MaxTime()), c.Window)
+	default:
+		var err error
+		if e, err = matcher.NewEstimator(c.Name, m); err != nil {
+			return nil, err
+		}
+	}
+	if c.QueueETA {
+		e = matcher.NewQueueEstimator(m, e, float64(m.MaxTime())/3)
+	}
+	return e, nil
+}
+
+// 创建预计等待时间算法并设置到 m
+func (c EstimatorConfig) Apply(m *matcher.Matcher) error {
+	e, err := c.NewEstimator(m)
+	if err != nil {
+		return err
+	}
+	return m.SetEstimator(e)
+}
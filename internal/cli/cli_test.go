@@ -0,0 +1,83 @@
+package cli_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ganlvtech/go-game-matching/internal/cli"
+	"github.com/ganlvtech/go-game-matching/matcher"
+)
+
+func TestSecondsToTime(t *testing.T) {
+	if v := cli.SecondsToTime(3, time.Second); v != 3 {
+		t.Errorf("3s in seconds = %d", v)
+	}
+	if v := cli.SecondsToTime(3, time.Millisecond); v != 3000 {
+		t.Errorf("3s in milliseconds = %d", v)
+	}
+}
+
+func TestLoadRadiusCurve(t *testing.T) {
+	if c, err := cli.LoadRadiusCurve(""); c != nil || err != nil {
+		t.Errorf("empty spec = %v, %v", c, err)
+	}
+	spec := `{"type":"linear","base":10,"rate":2}`
+	c, err := cli.LoadRadiusCurve(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Type != matcher.RadiusCurveTypeLinear || c.Base != 10 || c.Rate != 2 {
+		t.Errorf("curve %+v", c)
+	}
+
+	dir, err := ioutil.TempDir("", "cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "curve.json")
+	if err := ioutil.WriteFile(path, []byte(spec), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if c, err := cli.LoadRadiusCurve("@" + path); err != nil || c.Base != 10 {
+		t.Errorf("curve from file = %+v, %v", c, err)
+	}
+	if _, err := cli.LoadRadiusCurve("@" + filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("missing file should fail")
+	}
+	if _, err := cli.LoadRadiusCurve(`{"type":"unknown"}`); err == nil {
+		t.Error("unknown curve type should fail")
+	}
+}
+
+func TestEstimatorConfig_Apply(t *testing.T) {
+	for _, c := range []struct {
+		config cli.EstimatorConfig
+		name   string
+	}{
+		{cli.EstimatorConfig{Name: matcher.EstimatorGaussian}, matcher.EstimatorGaussian},
+		{cli.EstimatorConfig{Name: matcher.EstimatorEWMA, HalfLife: 60}, matcher.EstimatorEWMA},
+		{cli.EstimatorConfig{Name: matcher.EstimatorQuantile, Window: 16}, matcher.EstimatorQuantile},
+	} {
+		m := matcher.NewMatcher(180, 300, 10)
+		if err := c.config.Apply(m); err != nil {
+			t.Fatal(err)
+		}
+		if m.Estimator().Name() != c.name {
+			t.Errorf("estimator %s, want %s", m.Estimator().Name(), c.name)
+		}
+	}
+	m := matcher.NewMatcher(180, 300, 10)
+	if err := (cli.EstimatorConfig{Name: matcher.EstimatorGaussian, QueueETA: true}).Apply(m); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.Estimator().(*matcher.QueueEstimator); !ok {
+		t.Errorf("estimator %T, want *matcher.QueueEstimator", m.Estimator())
+	}
+	if err := (cli.EstimatorConfig{Name: "unknown"}).Apply(m); err == nil {
+		t.Error("unknown estimator should fail")
+	}
+}
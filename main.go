@@ -2,55 +2,128 @@ package main
 
 import (
 	"flag"
-	"io/ioutil"
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
 	"github.com/valyala/fasthttp"
 
 	"github.com/ganlvtech/go-game-matching/agent"
+	"github.com/ganlvtech/go-game-matching/internal/cli"
 	"github.com/ganlvtech/go-game-matching/matcher"
+	"github.com/ganlvtech/go-game-matching/web"
 )
 
 var maxTime int
 var maxScore int
 var scoreGroupLen int
 var matchCount int
+var adaptiveRadius bool
+var targetWaitTime int
+var radiusCurve string
+var requireAccept bool
+var acceptTimeout int
+var snapshotPath string
+var journalPath string
+var journalSync string
+var journalSyncInterval time.Duration
+var journalCompactInterval time.Duration
+var replicationListen string
+var followAddr string
+var shardCount int
+var batchedIngestion bool
+var matchBudget time.Duration
+var matchBudgetPlayers int
+var matchTick time.Duration
+var matchInterval time.Duration
+var matchBandArrivals int
+var matchRadiusCrossing bool
+var timeUnit time.Duration
+var recordPath string
+var estimator cli.EstimatorConfig
+var historySize int
 
 func init() {
 	flag.IntVar(&maxTime, "max_time", 180, "最长匹配时间，超过时间会被移出匹配队列，不超过二倍时间会保留玩家匹配信息，超过二倍时间会自动清除该角色的所有信息")
 	flag.IntVar(&maxScore, "max_score", 300, "最大分数")
 	flag.IntVar(&scoreGroupLen, "score_group_len", 10, "每一分段长度，越短匹配越精确，稍长性能会好，但是过长性能会很差")
 	flag.IntVar(&matchCount, "match_count", 25, "每组匹配人数")
+	flag.BoolVar(&adaptiveRadius, "adaptive_radius", false, "根据分段人数、加入速度和目标等待时间自动调整分数容忍区间")
+	flag.IntVar(&targetWaitTime, "target_wait_time", 30, "自适应分数容忍区间的目标等待时间")
+	flag.BoolVar(&requireAccept, "require_accept", false, "匹配成功后需要小组内所有玩家调用 /accept 确认")
+	flag.IntVar(&acceptTimeout, "accept_timeout", 0, "等待确认的最长秒数，超时后没有确认的玩家被移出，其他玩家回到队列，0 表示不限制")
+	flag.StringVar(&snapshotPath, "snapshot", "", "快照文件路径，启动时从快照恢复，退出时保存快照")
+	flag.StringVar(&journalPath, "journal", "", "操作日志文件路径，启动时在快照之后重放，崩溃后不会丢失快照之后的操作")
+	flag.StringVar(&journalSync, "journal_sync", "interval", "操作日志 fsync 策略：always interval never")
+	flag.DurationVar(&journalSyncInterval, "journal_sync_interval", time.Second, "journal_sync 为 interval 时的 fsync 间隔")
+	flag.DurationVar(&journalCompactInterval, "journal_compact_interval", 10*time.Minute, "保存快照并清空操作日志的间隔，需要同时指定 snapshot，0 表示只在退出时压缩")
+	flag.StringVar(&replicationListen, "replication_listen", "", "主节点监听复制连接的地址，例如 127.0.0.1:9000")
+	flag.StringVar(&followAddr, "follow", "", "作为只读的从节点跟随该地址的主节点，通过 /admin/promote 成为主节点")
+	flag.IntVar(&shardCount, "shards", 1, "按分数范围分片的数量，大于 1 时启用分片模式，分片模式不支持快照、操作日志、热备、自适应分数容忍区间和批量写入")
+	flag.BoolVar(&batchedIngestion, "batched_ingestion", false, "加入、离开、删除请求写入无锁缓冲区后立即返回，在下一次匹配之前批量执行，加入返回的等待时间为预计值")
+	flag.DurationVar(&matchBudget, "match_budget", 0, "每次匹配的最长处理时间，用完后下一次匹配从停下的位置继续，0 表示不限制")
+	flag.IntVar(&matchBudgetPlayers, "match_budget_players", 0, "每次匹配最多处理的玩家数，0 表示不限制")
+	flag.DurationVar(&matchTick, "match_tick", time.Second, "检查匹配触发条件的间隔，使用 match_band_arrivals 或 match_radius_crossing 时应设置得更短，例如 50ms")
+	flag.DurationVar(&matchInterval, "match_interval", time.Second, "两次匹配的最长间隔")
+	flag.IntVar(&matchBandArrivals, "match_band_arrivals", 0, "某个分段新加入多少人后立即匹配，0 表示不按加入人数触发")
+	flag.BoolVar(&matchRadiusCrossing, "match_radius_crossing", false, "有玩家的分数容忍区间扩大到新的分段时立即匹配")
+	flag.DurationVar(&timeUnit, "time_unit", time.Second, "匹配器和接口中时间的单位，例如 1ms，max_time 和 target_wait_time 仍然以秒为单位")
+	flag.StringVar(&recordPath, "record", "", "把执行的每个操作连同时间和形成的小组记录到该文件（JSONL 格式），可以用 cmd/replay 重放，例如 traffic.jsonl")
+	flag.StringVar(&estimator.Name, "estimator", matcher.EstimatorGaussian, "预计等待时间的算法：gaussian（相邻分段高斯模糊的平均值）ewma（按时间衰减的加权平均）quantile（最近匹配成功玩家的 p50 和 p90）")
+	flag.IntVar(&estimator.HalfLife, "estimator_half_life", 60, "ewma 的半衰期，单位为秒")
+	flag.IntVar(&estimator.Window, "estimator_window", 256, "quantile 每个分段统计最近多少个匹配成功的玩家")
+	flag.IntVar(&historySize, "history_size", agent.DefaultHistorySize, "/stats/history 保存最近多少次匹配的采样，0 表示不保存")
+	flag.BoolVar(&estimator.QueueETA, "queue_eta", false, "同时根据分段中正在等待的人数和加入速度估计等待时间，安静一段时间之后不会全部变成最长等待时间")
+	flag.StringVar(&radiusCurve, "radius_curve", "", "分数容忍区间曲线，JSON 格式，以 @ 开头则从文件读取，例如 {\"type\":\"linear\",\"base\":10,\"rate\":2,\"max\":150}")
+}
+
+type matchingService interface {
+	HandleHTTP(ctx *fasthttp.RequestCtx)
+	TimeOf(t time.Time) matcher.Time
+	Clock() matcher.Clock
+	Match(currentTime matcher.Time, count int)
+	BandJoinCounts() []int
+	NextRadiusCrossing(after matcher.Time) (matcher.Time, bool)
+	Sweep(before matcher.Time)
 }
 
 func main() {
 	flag.Parse()
 
-	matchingServer := agent.NewHttpMatchingServer(matcher.Time(maxTime), matcher.PlayerScore(maxScore), scoreGroupLen)
+	var service matchingService
+	var matchingServer *agent.HttpMatchingServer
+	var scheduler *agent.MatchScheduler
+	if shardCount > 1 {
+		shardedServer := newShardedMatchingServer()
+		scheduler = newMatchScheduler(shardedServer)
+		shardedServer.Scheduler = scheduler
+		service = shardedServer
+	} else {
+		matchingServer = newMatchingServer()
+		scheduler = newMatchScheduler(matchingServer)
+		matchingServer.Scheduler = scheduler
+		service = matchingServer
+	}
 	server := fasthttp.Server{
 		Handler: func(ctx *fasthttp.RequestCtx) {
-			if string(ctx.Request.URI().Path()) == "/" {
-				b, err := ioutil.ReadFile("web/index.html")
-				if err != nil {
-					ctx.SetStatusCode(404)
-					return
-				}
+			// 分片模式没有监控页面使用的 /grid /score_radius /groups /stats/history 等接口
+			if matchingServer != nil && string(ctx.Request.URI().Path()) == "/" {
 				ctx.SetStatusCode(200)
 				ctx.SetContentType("text/html; charset=utf-8")
-				_, _ = ctx.Write(b)
+				_, _ = ctx.Write(web.IndexHTML)
 				return
 			}
-			matchingServer.HandleHTTP(ctx)
+			service.HandleHTTP(ctx)
 		},
 		Name: "go-game-matching",
 	}
 	isRun := true
+	shutdownFinished := make(chan struct{})
 
-	signalChan := make(chan os.Signal)
+	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-signalChan
@@ -59,32 +132,180 @@ func main() {
 		if err := server.Shutdown(); err != nil {
 			log.Fatal(err)
 		}
+		if matchingServer != nil {
+			if snapshotPath != "" && !matchingServer.IsFollower() {
+				if err := matchingServer.CompactJournal(snapshotPath); err != nil {
+					log.Fatal(err)
+				}
+				log.Println("Snapshot saved to " + snapshotPath)
+			}
+			if err := matchingServer.CloseJournal(); err != nil {
+				log.Fatal(err)
+			}
+			if err := matchingServer.StopTrafficRecording(); err != nil {
+				log.Fatal(err)
+			}
+		}
 		log.Println("Server shutdown finished.")
+		close(shutdownFinished)
 	}()
 
 	go func() {
 		log.Println("Matching service started.")
-		for isRun {
-			matchingServer.Match(matcher.Time(time.Now().Unix()), matchCount)
-			time.Sleep(time.Second)
-		}
+		scheduler.Run(func() bool {
+			return isRun
+		})
 	}()
 
 	go func() {
 		log.Println("Sweeping service started.")
 		for isRun {
-			matchingServer.Sweep(matcher.Time(int(time.Now().Unix()) - maxTime*2))
+			service.Sweep(service.TimeOf(service.Clock().Now().Add(-2 * time.Duration(maxTime) * time.Second)))
 			time.Sleep(time.Duration(maxTime) * time.Second)
 		}
 	}()
 
+	if matchingServer != nil && snapshotPath != "" && journalPath != "" && journalCompactInterval > 0 {
+		go func() {
+			for isRun {
+				time.Sleep(journalCompactInterval)
+				if err := matchingServer.CompactJournal(snapshotPath); err != nil {
+					log.Println("Journal compaction failed:", err)
+				}
+			}
+		}()
+	}
+
 	addr := ":8000"
-	if len(os.Args) >= 2 {
-		addr = os.Args[1]
+	if flag.NArg() >= 1 {
+		addr = flag.Arg(0)
 	}
 	log.Println("HTTP server listening " + addr)
 	if err := server.ListenAndServe(addr); err != nil {
 		log.Fatal(err)
 	}
+	<-shutdownFinished
 	log.Println("Server main exit.")
 }
+
+func newMatchScheduler(service matchingService) *agent.MatchScheduler {
+	scheduler := agent.NewMatchScheduler(service, matchCount)
+	scheduler.TickInterval = matchTick
+	scheduler.MaxInterval = matchInterval
+	scheduler.BandArrivals = matchBandArrivals
+	scheduler.RadiusCrossing = matchRadiusCrossing
+	return scheduler
+}
+
+func openJournal(matchingServer *agent.HttpMatchingServer) {
+	policy, ok := matcher.ParseSyncPolicy(journalSync)
+	if !ok {
+		log.Fatal("Unknown journal sync policy " + journalSync)
+	}
+	count, err := matchingServer.OpenJournal(journalPath, policy, journalSyncInterval)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Journal replayed " + strconv.Itoa(count) + " operations from " + journalPath)
+}
+
+func newMatchingServer() *agent.HttpMatchingServer {
+	matchingServer := agent.NewHttpMatchingServerWithTimeUnit(cli.SecondsToTime(maxTime, timeUnit), matcher.PlayerScore(maxScore), scoreGroupLen, timeUnit)
+	if err := estimator.Apply(matchingServer.Matcher); err != nil {
+		log.Fatal(err)
+	}
+	matchingServer.Matcher.RequireAccept = requireAccept
+	matchingServer.Matcher.AcceptTimeout = cli.SecondsToTime(acceptTimeout, timeUnit)
+	if historySize != agent.DefaultHistorySize {
+		matchingServer.SetHistorySize(historySize)
+	}
+	matchingServer.Matcher.MatchBudget = matcher.MatchBudget{MaxPlayers: matchBudgetPlayers, MaxDuration: matchBudget}
+	c, err := cli.LoadRadiusCurve(radiusCurve)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if c != nil {
+		if err := matchingServer.Matcher.SetRadiusCurve(c); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if adaptiveRadius {
+		matchingServer.EnableAdaptiveScoreRadius(matchCount, float64(cli.SecondsToTime(targetWaitTime, timeUnit)))
+	}
+	if batchedIngestion {
+		matchingServer.EnableBatchedIngestion()
+	}
+	if followAddr != "" {
+		// 从节点的状态全部来自主节点，成为主节点后再开始写自己的操作日志
+		matchingServer.OnPromote = func() {
+			if journalPath != "" {
+				if err := os.Remove(journalPath); err != nil && !os.IsNotExist(err) {
+					log.Fatal(err)
+				}
+				openJournal(matchingServer)
+			}
+			if snapshotPath != "" {
+				if err := matchingServer.CompactJournal(snapshotPath); err != nil {
+					log.Println("Snapshot failed:", err)
+				}
+			}
+			startTrafficRecording(matchingServer)
+		}
+		matchingServer.Follow(followAddr)
+	} else {
+		if snapshotPath != "" {
+			if err := matchingServer.LoadSnapshot(snapshotPath); err == nil {
+				log.Println("Snapshot restored from " + snapshotPath)
+			} else if !os.IsNotExist(err) {
+				log.Fatal(err)
+			}
+		}
+		if journalPath != "" {
+			openJournal(matchingServer)
+		}
+		startTrafficRecording(matchingServer)
+	}
+	if replicationListen != "" {
+		go func() {
+			log.Fatal(matchingServer.ServeReplication(replicationListen))
+		}()
+	}
+	return matchingServer
+}
+
+// 在恢复快照和重放操作日志之后开始记录，记录的 header 中包含恢复后的状态
+func startTrafficRecording(matchingServer *agent.HttpMatchingServer) {
+	if recordPath == "" {
+		return
+	}
+	if err := matchingServer.StartTrafficRecording(recordPath); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Recording traffic to " + recordPath)
+}
+
+func newShardedMatchingServer() *agent.HttpShardedMatchingServer {
+	if snapshotPath != "" || journalPath != "" || followAddr != "" || replicationListen != "" || adaptiveRadius || batchedIngestion || recordPath != "" || historySize != agent.DefaultHistorySize {
+		log.Fatal("Sharded mode does not support snapshot, journal, replication, adaptive radius, batched ingestion, traffic recording or stats history.")
+	}
+	matchingServer := agent.NewHttpShardedMatchingServerWithTimeUnit(shardCount, cli.SecondsToTime(maxTime, timeUnit), matcher.PlayerScore(maxScore), scoreGroupLen, timeUnit)
+	c, err := cli.LoadRadiusCurve(radiusCurve)
+	if err != nil {
+		log.Fatal(err)
+	}
+	matchingServer.Matcher.Each(func(i int, m *matcher.Matcher) {
+		if err := estimator.Apply(m); err != nil {
+			log.Fatal(err)
+		}
+		m.RequireAccept = requireAccept
+		m.AcceptTimeout = cli.SecondsToTime(acceptTimeout, timeUnit)
+		m.MatchBudget = matcher.MatchBudget{MaxPlayers: matchBudgetPlayers, MaxDuration: matchBudget}
+		if c != nil {
+			if err := m.SetRadiusCurve(c); err != nil {
+				log.Fatal(err)
+			}
+		}
+	})
+	log.Println("Sharded mode with " + strconv.Itoa(shardCount) + " shards.")
+	return matchingServer
+}
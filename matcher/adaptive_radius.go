@@ -0,0 +1,176 @@
+package matcher
+
+import (
+	"math"
+)
+
+// 自适应分数容忍区间
+//
+// ScoreRadiusFunc 只和等待时间有关，高峰期区间没必要放得那么快，深夜又放得太慢。
+// 这里在 ScoreRadiusFunc 的基础上乘一个系数，系数由两部分组成：
+//  1. 人数系数：分段中正在匹配的人数加上目标等待时间内预计加入的人数，与每组人数比较，人多则收窄，人少则放宽
+//  2. 等待系数：分段预计等待时间与目标等待时间比较，每次 Match 逐步调整，超过目标则放宽，低于目标则收窄
+type AdaptiveScoreRadius struct {
+	matcher         *Matcher
+	MatchCount      int       // 每组匹配人数
+	TargetWaitTimes []float64 // 各分段的目标等待时间
+	MinFactor       float64   // 系数下限
+	MaxFactor       float64   // 系数上限
+	AdjustRate      float64   // 等待系数每次调整的比例
+	ArrivalSmooth   float64   // 加入速度的平滑系数，越小越平滑
+	waitFactors     []float64 // 各分段的等待系数
+	densityFactors  []float64 // 各分段的人数系数
+	populations     []int     // 各分段正在匹配的人数
+	arrivalRates    []float64 // 各分段每单位时间加入人数
+	lastJoinCounts  []int
+	lastTime        Time
+}
+
+type AdaptiveScoreRadiusBandStats struct {
+	Band              int
+	Population        int
+	ArrivalRate       float64
+	TargetWaitTime    float64
+	EstimatedWaitTime float64
+	WaitFactor        float64
+	DensityFactor     float64
+	Factor            float64
+}
+
+func NewAdaptiveScoreRadius(m *Matcher, matchCount int, targetWaitTime float64) *AdaptiveScoreRadius {
+	count := m.ScoreBandCount()
+	a := &AdaptiveScoreRadius{
+		matcher:         m,
+		MatchCount:      matchCount,
+		TargetWaitTimes: make([]float64, count),
+		MinFactor:       0.25,
+		MaxFactor:       4,
+		AdjustRate:      0.05,
+		ArrivalSmooth:   0.2,
+	}
+	for i := 0; i < count; i++ {
+		a.TargetWaitTimes[i] = targetWaitTime
+	}
+	a.reset()
+	return a
+}
+
+// 恢复到刚创建时的系数
+func (a *AdaptiveScoreRadius) reset() {
+	count := len(a.TargetWaitTimes)
+	a.waitFactors = make([]float64, count)
+	a.densityFactors = make([]float64, count)
+	a.populations = make([]int, count)
+	a.arrivalRates = make([]float64, count)
+	a.lastJoinCounts = make([]int, count)
+	a.lastTime = -1
+	for i := 0; i < count; i++ {
+		a.waitFactors[i] = 1
+		a.densityFactors[i] = 1
+	}
+}
+
+// 启用自适应分数容忍区间，a 为 nil 时停用
+// 系数在每次 Match 开始时更新，读取分数容忍区间（例如查询玩家状态）不会改变系数
+// 系数保存在快照中，需要在恢复快照之前启用，否则恢复会失败
+func (m *Matcher) SetAdaptiveScoreRadius(a *AdaptiveScoreRadius) {
+	m.adaptiveRadius = a
+	if a == nil {
+		m.PlayerScoreRadiusFunc = nil
+		return
+	}
+	m.PlayerScoreRadiusFunc = a.ScoreRadius
+}
+
+// 作为 Matcher.PlayerScoreRadiusFunc 使用，只读取系数，需要通过 Matcher.SetAdaptiveScoreRadius 启用系数才会更新
+func (a *AdaptiveScoreRadius) ScoreRadius(p *Player, currentTime Time) PlayerScore {
+	return a.scoreRadius(a.matcher, p, currentTime)
+}
+
+// 使用当前的系数和 m 的 ScoreRadiusFunc，不会更新系数，用于在匹配器的副本上预览
+func (a *AdaptiveScoreRadius) Frozen(m *Matcher) PlayerScoreRadiusFunc {
+	return func(p *Player, currentTime Time) PlayerScore {
+		return a.scoreRadius(m, p, currentTime)
+	}
+}
+
+func (a *AdaptiveScoreRadius) scoreRadius(m *Matcher, p *Player, currentTime Time) PlayerScore {
+	return a.scaledRadius(m, currentTime-p.JoinTime, a.factor(m.ScoreBandIndex(p.Score)))
+}
+
+// m 的 ScoreRadiusFunc 乘以系数，不小于 1，不超过最大分数
+func (a *AdaptiveScoreRadius) scaledRadius(m *Matcher, deltaT Time, factor float64) PlayerScore {
+	radius := float64(m.ScoreRadiusFunc(deltaT)) * factor
+	if radius < 1 {
+		radius = 1
+	} else if radius > float64(m.maxScore) {
+		radius = float64(m.maxScore)
+	}
+	return PlayerScore(radius)
+}
+
+// 在 Match 开始时调用，每个时间点只更新一次，同一次 Match 中所有玩家使用相同的系数
+func (a *AdaptiveScoreRadius) update(currentTime Time) {
+	if currentTime == a.lastTime {
+		return
+	}
+	m := a.matcher
+	deltaT := float64(currentTime - a.lastTime)
+	for band := range a.waitFactors {
+		joinCount := m.BandJoinCount(band)
+		if a.lastTime >= 0 && deltaT > 0 {
+			rate := float64(joinCount-a.lastJoinCounts[band]) / deltaT
+			a.arrivalRates[band] += (rate - a.arrivalRates[band]) * a.ArrivalSmooth
+		}
+		a.lastJoinCounts[band] = joinCount
+		a.populations[band] = m.BandPlayerInQueueCount(band)
+
+		// 人数系数，人数恰好够一组时为 1
+		supply := float64(a.populations[band]) + a.arrivalRates[band]*a.TargetWaitTimes[band]
+		a.densityFactors[band] = a.clamp(math.Sqrt(float64(a.MatchCount) / math.Max(supply, 1)))
+
+		// 等待系数，留 10% 的容差避免来回抖动
+		target := a.TargetWaitTimes[band]
+		estimated := m.estimator.GroupWaitTimes()[band]
+		if estimated > target*1.1 {
+			a.waitFactors[band] = a.clamp(a.waitFactors[band] * (1 + a.AdjustRate))
+		} else if estimated < target*0.9 {
+			a.waitFactors[band] = a.clamp(a.waitFactors[band] / (1 + a.AdjustRate))
+		}
+	}
+	a.lastTime = currentTime
+}
+
+func (a *AdaptiveScoreRadius) factor(band int) float64 {
+	return a.clamp(a.waitFactors[band] * a.densityFactors[band])
+}
+
+func (a *AdaptiveScoreRadius) clamp(f float64) float64 {
+	if f < a.MinFactor {
+		return a.MinFactor
+	} else if f > a.MaxFactor {
+		return a.MaxFactor
+	}
+	return f
+}
+
+// 管理统计函数 ==========
+
+func (a *AdaptiveScoreRadius) Stats() []AdaptiveScoreRadiusBandStats {
+	s := make([]AdaptiveScoreRadiusBandStats, len(a.waitFactors))
+	for band := range s {
+		s[band] = AdaptiveScoreRadiusBandStats{
+			Band:              band,
+			Population:        a.populations[band],
+			ArrivalRate:       a.arrivalRates[band],
+			TargetWaitTime:    a.TargetWaitTimes[band],
+			EstimatedWaitTime: a.matcher.estimator.GroupWaitTimes()[band],
+			WaitFactor:        a.waitFactors[band],
+			DensityFactor:     a.densityFactors[band],
+			Factor:            a.factor(band),
+		}
+	}
+	return s
+}
+
+// 管理统计函数结束 ==========
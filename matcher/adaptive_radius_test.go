@@ -0,0 +1,158 @@
+package matcher_test
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/ganlvtech/go-game-matching/matcher"
+)
+
+func newAdaptiveMatcher() (*matcher.Matcher, *matcher.AdaptiveScoreRadius) {
+	m := matcher.NewMatcher(120, 300, 10)
+	a := matcher.NewAdaptiveScoreRadius(m, 4, 30)
+	m.SetAdaptiveScoreRadius(a)
+	return m, a
+}
+
+func TestAdaptiveScoreRadius_DensityFactor(t *testing.T) {
+	m, a := newAdaptiveMatcher()
+	for i := 0; i < 16; i++ {
+		_ = m.JoinQueue(matcher.PlayerId(strconv.Itoa(i)), 1000, 100)
+	}
+	// 每组人数很多，没有人能匹配成功
+	m.Match(1000, 100)
+	stats := a.Stats()
+	// 16 个人是一组的 4 倍，区间收窄为 1/2；没有人的分段放宽为 2 倍
+	if stats[10].Population != 16 || stats[10].DensityFactor != 0.5 || stats[0].DensityFactor != 2 {
+		t.Errorf("band 10 %+v, band 0 %+v", stats[10], stats[0])
+	}
+
+	// 加入速度按时间平滑
+	for i := 0; i < 10; i++ {
+		_ = m.JoinQueue(matcher.PlayerId("late"+strconv.Itoa(i)), 1010, 200)
+	}
+	m.Match(1010, 100)
+	if rate := a.Stats()[20].ArrivalRate; math.Abs(rate-0.2) > 1e-9 {
+		t.Errorf("arrival rate %v", rate)
+	}
+}
+
+func TestAdaptiveScoreRadius_WaitFactor(t *testing.T) {
+	m, a := newAdaptiveMatcher()
+	// 初始的预计等待时间为最长等待时间 120，超过分段 0 的目标，低于分段 1 的目标
+	a.TargetWaitTimes[1] = 200
+	m.Match(1000, 4)
+	stats := a.Stats()
+	if stats[0].WaitFactor != 1.05 || math.Abs(stats[1].WaitFactor-1/1.05) > 1e-9 {
+		t.Errorf("band 0 %+v, band 1 %+v", stats[0], stats[1])
+	}
+	// 在目标附近时不调整
+	a.TargetWaitTimes[2] = 115
+	m.Match(1001, 4)
+	if stats := a.Stats(); stats[2].WaitFactor != 1.05 {
+		t.Errorf("band 2 %+v", stats[2])
+	}
+}
+
+func TestAdaptiveScoreRadius_Clamp(t *testing.T) {
+	m, a := newAdaptiveMatcher()
+	a.MaxFactor = 3
+	for now := matcher.Time(1000); now < 1100; now++ {
+		m.Match(now, 4)
+	}
+	stats := a.Stats()
+	if stats[0].WaitFactor != 3 || stats[0].DensityFactor != 2 || stats[0].Factor != 3 {
+		t.Errorf("band 0 %+v", stats[0])
+	}
+	_ = m.JoinQueue("a", 1100, 5)
+	if r := m.PlayerScoreRadius(m.Players()["a"], 1100); r != matcher.PlayerScore(float64(m.ScoreRadiusFunc(0))*3) {
+		t.Errorf("radius %d", r)
+	}
+}
+
+func TestAdaptiveScoreRadius_UpdateOncePerTick(t *testing.T) {
+	m, a := newAdaptiveMatcher()
+	_ = m.JoinQueue("a", 1000, 100)
+	m.Match(1000, 4)
+	m.Match(1000, 4)
+	stats := a.Stats()
+	if stats[10].WaitFactor != 1.05 {
+		t.Errorf("updated twice in one tick: %+v", stats[10])
+	}
+	// 读取分数容忍区间和玩家状态不会更新系数
+	for now := matcher.Time(1001); now < 1050; now++ {
+		_ = m.PlayerScoreRadius(m.Players()["a"], now)
+		_, _ = m.GetPlayerStatus("a", now)
+	}
+	if !reflect.DeepEqual(stats, a.Stats()) {
+		t.Error("reading the radius changed the factors")
+	}
+	m.Match(1001, 4)
+	if a.Stats()[10].WaitFactor != 1.05*1.05 {
+		t.Errorf("not updated in the next tick: %+v", a.Stats()[10])
+	}
+}
+
+func TestAdaptiveScoreRadius_Explain(t *testing.T) {
+	m, a := newAdaptiveMatcher()
+	_ = m.JoinQueue("a", 1000, 100)
+	_ = m.JoinQueue("b", 1000, 250)
+	m.Match(1000, 4)
+	stats := a.Stats()
+	// Explain 使用自适应的分数容忍区间，但不会更新系数
+	for now := matcher.Time(1001); now < 1050; now++ {
+		e, err := m.Explain("a", now, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r := m.PlayerScoreRadius(m.Players()["a"], now); e.ScoreRadius != r {
+			t.Fatalf("explain radius %d, want %d", e.ScoreRadius, r)
+		}
+	}
+	if !reflect.DeepEqual(stats, a.Stats()) {
+		t.Error("explain changed the factors")
+	}
+}
+
+func TestAdaptiveScoreRadius_Snapshot(t *testing.T) {
+	m, a := newAdaptiveMatcher()
+	for now := matcher.Time(1000); now < 1050; now++ {
+		_ = m.JoinQueue(matcher.PlayerId(strconv.Itoa(int(now))), now, matcher.PlayerScore(now%300))
+		m.Match(now, 4)
+	}
+	buf := &bytes.Buffer{}
+	if err := m.WriteSnapshot(buf); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+
+	// 恢复后系数相同，之后的匹配结果也相同
+	m2, a2 := newAdaptiveMatcher()
+	if err := m2.ReadSnapshot(bytes.NewReader(b)); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a.Stats(), a2.Stats()) {
+		t.Fatalf("factors differ after restore\n%+v\n%+v", a.Stats(), a2.Stats())
+	}
+	for now := matcher.Time(1050); now < 1100; now++ {
+		m.Match(now, 4)
+		m2.Match(now, 4)
+	}
+	if !reflect.DeepEqual(a.Stats(), a2.Stats()) {
+		t.Errorf("factors differ after matching\n%+v\n%+v", a.Stats(), a2.Stats())
+	}
+
+	// 快照和匹配器必须同时启用自适应分数容忍区间
+	if err := matcher.NewMatcher(120, 300, 10).ReadSnapshot(bytes.NewReader(b)); err == nil {
+		t.Error("restored adaptive snapshot without adaptive radius")
+	}
+	buf.Reset()
+	_ = matcher.NewMatcher(120, 300, 10).WriteSnapshot(buf)
+	m3, _ := newAdaptiveMatcher()
+	if err := m3.ReadSnapshot(buf); err == nil {
+		t.Error("restored snapshot without adaptive state")
+	}
+}
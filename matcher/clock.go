@@ -0,0 +1,49 @@
+package matcher
+
+import (
+	"sync"
+	"time"
+)
+
+// 时钟，所有读取当前时间的地方都通过时钟，测试和模拟时可以使用虚拟时间
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// 系统时钟
+var SystemClock Clock = systemClock{}
+
+// 虚拟时钟，只在调用 Advance 或 Set 时前进
+type VirtualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewVirtualClock(start time.Time) *VirtualClock {
+	return &VirtualClock{now: start}
+}
+
+func (c *VirtualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *VirtualClock) Advance(d time.Duration) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	return c.now
+}
+
+func (c *VirtualClock) Set(t time.Time) {
+	c.mu.Lock()
+	c.now = t
+	c.mu.Unlock()
+}
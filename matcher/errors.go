@@ -1,5 +1,7 @@
 package matcher
 
+import "strconv"
+
 type PlayerAlreadyExistsError PlayerId
 
 func (e PlayerAlreadyExistsError) Error() string {
@@ -18,8 +20,44 @@ func (e PlayerAlreadyMatchedError) Error() string {
 	return "player already matched. id = " + string(e)
 }
 
+type PlayerScoreOutOfRangeError PlayerScore
+
+func (e PlayerScoreOutOfRangeError) Error() string {
+	return "player score out of range. score = " + strconv.FormatUint(uint64(e), 10)
+}
+
 type PlayerNotMatchedError PlayerId
 
 func (e PlayerNotMatchedError) Error() string {
 	return "player not matched. id = " + string(e)
 }
+
+type InvalidSnapshotError string
+
+func (e InvalidSnapshotError) Error() string {
+	return "invalid snapshot. " + string(e)
+}
+
+type InvalidJournalError string
+
+func (e InvalidJournalError) Error() string {
+	return "invalid journal. " + string(e)
+}
+
+type GroupNotExistsError GroupId
+
+func (e GroupNotExistsError) Error() string {
+	return "group not exists. id = " + strconv.FormatUint(uint64(e), 10)
+}
+
+type InvalidTrafficError string
+
+func (e InvalidTrafficError) Error() string {
+	return "invalid traffic record. " + string(e)
+}
+
+type InvalidEstimatorError string
+
+func (e InvalidEstimatorError) Error() string {
+	return "invalid estimator. " + string(e)
+}
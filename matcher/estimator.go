@@ -0,0 +1,78 @@
+package matcher
+
+import "strings"
+
+const (
+	EstimatorGaussian = "gaussian"
+	EstimatorEWMA     = "ewma"
+	EstimatorQuantile = "quantile"
+)
+
+// 预计等待时间，Low 到 High 是给玩家看的区间
+type WaitTimeEstimate struct {
+	Expected float64 `json:"expected"` // 单个预计值
+	Low      float64 `json:"low"`      // 大约一半的玩家不超过这个时间
+	High     float64 `json:"high"`     // 大约 90% 的玩家不超过这个时间
+}
+
+// 各分段预计等待时间的算法
+//
+// 每次 Match 开始时调用 AddTimeAuto，匹配成功的玩家调用 AddItem，Match 结束时调用 Merge，
+// Estimate 和 GroupWaitTimes 返回最近一次 Merge 的结果。时间都以 Matcher 的 Time 为单位
+type Estimator interface {
+	Name() string
+	GroupCount() int
+	AddTimeAuto(t float64)
+	AddItem(groupIndex int, t float64)
+	Merge()
+	Estimate(groupIndex int) WaitTimeEstimate
+	GroupWaitTimes() []float64 // 各分段的 Expected，调用者不能修改
+	MarshalState() ([]byte, error)
+	UnmarshalState(data []byte) error // 用于从快照恢复，数据无效时不能修改当前状态
+}
+
+type UnknownEstimatorError string
+
+func (e UnknownEstimatorError) Error() string {
+	return "unknown estimator. name = " + string(e)
+}
+
+// 按名称为 m 创建默认参数的内置算法，EWMA 的半衰期为最长等待时间的三分之一，分位数的窗口为 256 人，
+// queue+ 开头的名称在内部算法外面包装 QueueEstimator
+func NewEstimator(name string, m *Matcher) (Estimator, error) {
+	if strings.HasPrefix(name, EstimatorQueuePrefix) {
+		return newQueueEstimatorByName(name, m)
+	}
+	count := m.ScoreBandCount()
+	maxWaitTime := float64(m.MaxTime())
+	switch name {
+	case EstimatorGaussian:
+		return NewWaitTime(count, maxWaitTime), nil
+	case EstimatorEWMA:
+		return NewEWMAEstimator(count, maxWaitTime, maxWaitTime/3), nil
+	case EstimatorQuantile:
+		return NewQuantileEstimator(count, maxWaitTime, 256), nil
+	}
+	return nil, UnknownEstimatorError(name)
+}
+
+// 分段最近一次有人匹配成功之后过了多久，一直没有人匹配成功时预计时间会逐渐增加到最长等待时间，与高斯模糊算法每次加上经过的时间效果相同
+func idleWaitTime(lastTime float64, lastMatchTime float64) float64 {
+	if lastTime < 0 || lastMatchTime < 0 {
+		return 0
+	}
+	return lastTime - lastMatchTime
+}
+
+func clampWaitTimeEstimate(e WaitTimeEstimate, maxWaitTime float64) WaitTimeEstimate {
+	if e.Expected > maxWaitTime {
+		e.Expected = maxWaitTime
+	}
+	if e.Low > maxWaitTime {
+		e.Low = maxWaitTime
+	}
+	if e.High > maxWaitTime {
+		e.High = maxWaitTime
+	}
+	return e
+}
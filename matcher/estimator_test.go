@@ -0,0 +1,132 @@
+package matcher_test
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/ganlvtech/go-game-matching/matcher"
+)
+
+func TestEWMAEstimator_HalfLife(t *testing.T) {
+	e := matcher.NewEWMAEstimator(3, 180, 60)
+	if got := e.Estimate(1); got.Expected != 180 || got.High != 180 {
+		t.Fatalf("empty estimate %+v", got)
+	}
+	e.AddTimeAuto(1000)
+	for i := 0; i < 10; i++ {
+		e.AddItem(1, 10)
+	}
+	e.Merge()
+	if got := e.Estimate(1); got.Expected != 10 || got.Low != 10 || got.High != 10 {
+		t.Fatalf("estimate %+v", got)
+	}
+	// 一个半衰期之后，之前的玩家权重减半
+	e.AddTimeAuto(1060)
+	for i := 0; i < 10; i++ {
+		e.AddItem(1, 30)
+	}
+	e.Merge()
+	got := e.Estimate(1)
+	if math.Abs(got.Expected-(10*5+30*10)/15.0) > 1e-9 || got.High <= got.Expected {
+		t.Fatalf("estimate %+v", got)
+	}
+	if e.Estimate(0).Expected != 180 {
+		t.Errorf("band without matches %+v", e.Estimate(0))
+	}
+}
+
+func TestQuantileEstimator(t *testing.T) {
+	q := matcher.NewQuantileEstimator(2, 180, 100)
+	q.AddTimeAuto(1000)
+	for i := 200; i > 0; i-- {
+		q.AddItem(0, float64(i))
+	}
+	q.Merge()
+	// 只保留最近 100 个，也就是 1 到 100
+	if got := q.Estimate(0); got.Expected != 50 || got.Low != 50 || got.High != 90 {
+		t.Fatalf("estimate %+v", got)
+	}
+	// 很久没有人匹配成功时，预计时间随时间增加
+	q.AddTimeAuto(1070)
+	q.Merge()
+	if got := q.Estimate(0); got.Expected != 70 || got.High != 90 {
+		t.Errorf("idle estimate %+v", got)
+	}
+	q.AddTimeAuto(1500)
+	q.Merge()
+	if got := q.Estimate(0); got.Expected != 180 || got.High != 180 {
+		t.Errorf("idle estimate %+v", got)
+	}
+}
+
+func TestMatcher_SetEstimator_Snapshot(t *testing.T) {
+	for _, name := range []string{matcher.EstimatorGaussian, matcher.EstimatorEWMA, matcher.EstimatorQuantile, matcher.EstimatorQueuePrefix + matcher.EstimatorEWMA} {
+		m := matcher.NewMatcher(180, 300, 10)
+		var e matcher.Estimator
+		switch name {
+		case matcher.EstimatorEWMA:
+			e = matcher.NewEWMAEstimator(m.ScoreBandCount(), 180, 20)
+		case matcher.EstimatorQuantile:
+			e = matcher.NewQuantileEstimator(m.ScoreBandCount(), 180, 50)
+		case matcher.EstimatorQueuePrefix + matcher.EstimatorEWMA:
+			e = matcher.NewQueueEstimator(m, matcher.NewEWMAEstimator(m.ScoreBandCount(), 180, 20), 30)
+		default:
+			e = matcher.NewWaitTime(m.ScoreBandCount(), 180)
+		}
+		if err := m.SetEstimator(e); err != nil {
+			t.Fatal(err)
+		}
+		r := rand.New(rand.NewSource(1))
+		id := 0
+		for now := matcher.Time(1000); now < 1200; now++ {
+			for i := 0; i < 5; i++ {
+				_ = m.JoinQueue(matcher.PlayerId(strconv.Itoa(id)), now, matcher.PlayerScore(r.Intn(300)))
+				id++
+			}
+			m.Match(now, 5)
+		}
+		var b1 bytes.Buffer
+		if err := m.WriteSnapshot(&b1); err != nil {
+			t.Fatal(err)
+		}
+
+		// 参数从快照中恢复
+		m2 := matcher.NewMatcher(180, 300, 10)
+		e2, err := matcher.NewEstimator(name, m2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = m2.SetEstimator(e2)
+		if err := m2.ReadSnapshot(bytes.NewReader(b1.Bytes())); err != nil {
+			t.Fatal(name, err)
+		}
+		for band := 0; band < m.ScoreBandCount(); band++ {
+			if m.Estimator().Estimate(band) != m2.Estimator().Estimate(band) {
+				t.Fatalf("%s band %d: %+v != %+v", name, band, m.Estimator().Estimate(band), m2.Estimator().Estimate(band))
+			}
+		}
+		m.Match(1200, 5)
+		m2.Match(1200, 5)
+		var b2, b3 bytes.Buffer
+		_ = m.WriteSnapshot(&b2)
+		_ = m2.WriteSnapshot(&b3)
+		if b2.String() != b3.String() {
+			t.Errorf("%s: restored matcher differs", name)
+		}
+
+		if name != matcher.EstimatorGaussian {
+			m3 := matcher.NewMatcher(180, 300, 10)
+			if err := m3.ReadSnapshot(bytes.NewReader(b1.Bytes())); err == nil {
+				t.Errorf("%s: snapshot restored into gaussian matcher", name)
+			}
+		}
+	}
+
+	m := matcher.NewMatcher(180, 300, 10)
+	if err := m.SetEstimator(matcher.NewWaitTime(3, 180)); err == nil {
+		t.Error("estimator with wrong group count accepted")
+	}
+}
@@ -0,0 +1,121 @@
+package matcher
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+type EventType int
+
+const (
+	EventPlayerJoined   EventType = iota + 1 // 加入队列
+	EventPlayerLeft                          // 主动离开队列
+	EventPlayerTimedOut                      // 超过最长匹配时间被移出队列
+	EventGroupFormed                         // 匹配成功
+	EventPlayerRemoved                       // 被删除，Reason 区分是主动删除还是过期清理
+	EventGroupClosed                         // 小组内的玩家全部被删除
+	EventGroupDisbanded                      // 小组被解散，玩家回到队列
+)
+
+const (
+	RemoveReasonRemove = "remove"
+	RemoveReasonSweep  = "sweep"
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventPlayerJoined:
+		return "PlayerJoined"
+	case EventPlayerLeft:
+		return "PlayerLeft"
+	case EventPlayerTimedOut:
+		return "PlayerTimedOut"
+	case EventGroupFormed:
+		return "GroupFormed"
+	case EventPlayerRemoved:
+		return "PlayerRemoved"
+	case EventGroupClosed:
+		return "GroupClosed"
+	case EventGroupDisbanded:
+		return "GroupDisbanded"
+	}
+	return "Unknown"
+}
+
+// 事件是发出时的快照，订阅者在其他 goroutine 中读取也是安全的
+type Event struct {
+	Type      EventType
+	Time      Time     // 加入和匹配事件为准确时间，其他事件为最近一次 Match 的时间
+	PlayerId  PlayerId // 玩家事件
+	Score     PlayerScore
+	GroupId   GroupId    // 小组事件，或者已匹配玩家所在的小组
+	PlayerIds []PlayerId // 小组事件
+	Reason    string     // PlayerRemoved 事件
+}
+
+// 事件总线，每个订阅者有独立的缓冲区，缓冲区满时丢弃事件，不会阻塞 Match
+type EventBus struct {
+	mu          sync.Mutex
+	subscribers []*EventSubscription
+}
+
+type EventSubscription struct {
+	C       <-chan Event
+	c       chan Event
+	bus     *EventBus
+	dropped int64
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{}
+}
+
+func (b *EventBus) Subscribe(bufferSize int) *EventSubscription {
+	c := make(chan Event, bufferSize)
+	s := &EventSubscription{
+		C:   c,
+		c:   c,
+		bus: b,
+	}
+	b.mu.Lock()
+	b.subscribers = append(b.subscribers, s)
+	b.mu.Unlock()
+	return s
+}
+
+func (b *EventBus) Publish(e Event) {
+	b.mu.Lock()
+	for _, s := range b.subscribers {
+		select {
+		case s.c <- e:
+		default:
+			atomic.AddInt64(&s.dropped, 1)
+		}
+	}
+	b.mu.Unlock()
+}
+
+func (b *EventBus) SubscriberCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subscribers)
+}
+
+// 取消订阅并关闭 C
+func (s *EventSubscription) Unsubscribe() {
+	b := s.bus
+	b.mu.Lock()
+	for i, v := range b.subscribers {
+		if v == s {
+			b.subscribers = append(b.subscribers[:i], b.subscribers[i+1:]...)
+			close(s.c)
+			break
+		}
+	}
+	b.mu.Unlock()
+}
+
+// 因为缓冲区满而丢弃的事件数
+func (s *EventSubscription) Dropped() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
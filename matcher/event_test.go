@@ -0,0 +1,56 @@
+package matcher_test
+
+import (
+	"testing"
+
+	"github.com/ganlvtech/go-game-matching/matcher"
+)
+
+func TestEventBus(t *testing.T) {
+	m := matcher.NewMatcher(180, 300, 10)
+	sub := m.Events.Subscribe(100)
+	slow := m.Events.Subscribe(1)
+
+	_ = m.JoinQueue("a", 100, 150)
+	_ = m.JoinQueue("b", 100, 152)
+	_ = m.JoinQueue("c", 100, 10)
+	_ = m.JoinQueue("d", 100, 20)
+	_ = m.LeaveQueue("d")
+	m.Match(101, 2)
+	m.Remove("a")
+	m.Remove("b")
+	m.Match(1000, 2)
+	sub.Unsubscribe()
+
+	want := []matcher.EventType{
+		matcher.EventPlayerJoined,
+		matcher.EventPlayerJoined,
+		matcher.EventPlayerJoined,
+		matcher.EventPlayerJoined,
+		matcher.EventPlayerLeft,
+		matcher.EventGroupFormed,
+		matcher.EventPlayerRemoved,
+		matcher.EventPlayerRemoved,
+		matcher.EventGroupClosed,
+		matcher.EventPlayerTimedOut,
+	}
+	i := 0
+	for e := range sub.C {
+		if i >= len(want) {
+			t.Fatalf("unexpected event %v", e.Type)
+		}
+		if e.Type != want[i] {
+			t.Errorf("event %d = %v, want %v", i, e.Type, want[i])
+		}
+		if e.Type == matcher.EventGroupFormed && len(e.PlayerIds) != 2 {
+			t.Errorf("group formed with %v", e.PlayerIds)
+		}
+		i++
+	}
+	if i != len(want) {
+		t.Errorf("got %d events, want %d", i, len(want))
+	}
+	if slow.Dropped() != int64(len(want)-1) {
+		t.Errorf("slow subscriber dropped %d events, want %d", slow.Dropped(), len(want)-1)
+	}
+}
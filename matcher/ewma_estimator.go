@@ -0,0 +1,133 @@
+package matcher
+
+import (
+	"math"
+
+	json "github.com/json-iterator/go"
+)
+
+// 按时间衰减的指数加权平均，HalfLife 之前匹配成功的玩家权重减半
+//
+// 同时记录加权方差，High 按正态分布取平均值加 1.28 倍标准差
+type EWMAEstimator struct {
+	groupCount     int
+	HalfLife       float64
+	MaxWaitTime    float64
+	weights        []float64
+	sums           []float64
+	squareSums     []float64
+	lastMatchTimes []float64 // 分段最近一次有人匹配成功的时间，-1 表示没有
+	lastTime       float64
+	estimates      []WaitTimeEstimate
+	groups         []float64
+}
+
+type ewmaEstimatorState struct {
+	HalfLife       float64   `json:"half_life"`
+	Weights        []float64 `json:"weights"`
+	Sums           []float64 `json:"sums"`
+	SquareSums     []float64 `json:"square_sums"`
+	LastMatchTimes []float64 `json:"last_match_times"`
+	LastTime       float64   `json:"last_time"`
+}
+
+func NewEWMAEstimator(count int, maxWaitTime float64, halfLife float64) *EWMAEstimator {
+	e := &EWMAEstimator{
+		groupCount:     count,
+		HalfLife:       halfLife,
+		MaxWaitTime:    maxWaitTime,
+		weights:        make([]float64, count),
+		sums:           make([]float64, count),
+		squareSums:     make([]float64, count),
+		lastMatchTimes: make([]float64, count),
+		lastTime:       -1,
+		estimates:      make([]WaitTimeEstimate, count),
+		groups:         make([]float64, count),
+	}
+	for i := range e.lastMatchTimes {
+		e.lastMatchTimes[i] = -1
+	}
+	e.Merge()
+	return e
+}
+
+func (e *EWMAEstimator) Name() string {
+	return EstimatorEWMA
+}
+
+func (e *EWMAEstimator) GroupCount() int {
+	return e.groupCount
+}
+
+func (e *EWMAEstimator) AddTimeAuto(t float64) {
+	if e.lastTime >= 0 && t > e.lastTime && e.HalfLife > 0 {
+		decay := math.Pow(0.5, (t-e.lastTime)/e.HalfLife)
+		for i := range e.weights {
+			e.weights[i] *= decay
+			e.sums[i] *= decay
+			e.squareSums[i] *= decay
+		}
+	}
+	e.lastTime = t
+}
+
+func (e *EWMAEstimator) AddItem(groupIndex int, t float64) {
+	e.weights[groupIndex]++
+	e.sums[groupIndex] += t
+	e.squareSums[groupIndex] += t * t
+	e.lastMatchTimes[groupIndex] = e.lastTime
+}
+
+func (e *EWMAEstimator) Merge() {
+	for i := range e.estimates {
+		est := WaitTimeEstimate{Expected: e.MaxWaitTime, Low: e.MaxWaitTime, High: e.MaxWaitTime}
+		// 权重太小说明很久没有人匹配成功，idleWaitTime 也已经接近最长等待时间
+		if e.weights[i] > 1e-6 {
+			mean := e.sums[i] / e.weights[i]
+			sd := math.Sqrt(math.Max(0, e.squareSums[i]/e.weights[i]-mean*mean))
+			idle := idleWaitTime(e.lastTime, e.lastMatchTimes[i])
+			est.Expected = math.Max(mean, idle)
+			est.Low = est.Expected
+			est.High = math.Max(mean+1.2816*sd, idle)
+		}
+		e.estimates[i] = clampWaitTimeEstimate(est, e.MaxWaitTime)
+		e.groups[i] = e.estimates[i].Expected
+	}
+}
+
+func (e *EWMAEstimator) Estimate(groupIndex int) WaitTimeEstimate {
+	return e.estimates[groupIndex]
+}
+
+func (e *EWMAEstimator) GroupWaitTimes() []float64 {
+	return e.groups
+}
+
+func (e *EWMAEstimator) MarshalState() ([]byte, error) {
+	return json.Marshal(&ewmaEstimatorState{
+		HalfLife:       e.HalfLife,
+		Weights:        e.weights,
+		Sums:           e.sums,
+		SquareSums:     e.squareSums,
+		LastMatchTimes: e.lastMatchTimes,
+		LastTime:       e.lastTime,
+	})
+}
+
+func (e *EWMAEstimator) UnmarshalState(data []byte) error {
+	s := &ewmaEstimatorState{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return InvalidSnapshotError(err.Error())
+	}
+	if len(s.Weights) != e.groupCount || len(s.Sums) != e.groupCount || len(s.SquareSums) != e.groupCount || len(s.LastMatchTimes) != e.groupCount {
+		return InvalidSnapshotError("score group count mismatch")
+	}
+	e.HalfLife = s.HalfLife
+	e.weights = s.Weights
+	e.sums = s.Sums
+	e.squareSums = s.SquareSums
+	e.lastMatchTimes = s.LastMatchTimes
+	e.lastTime = s.LastTime
+	e.Merge()
+	return nil
+}
@@ -0,0 +1,150 @@
+package matcher
+
+// 解释玩家为什么还没有匹配成功，只读取状态，不修改匹配器
+
+// 候选人被排除的原因
+const (
+	ExclusionOutOfRadius     = "out_of_radius"    // 分数不在容忍区间内
+	ExclusionBandGranularity = "band_granularity" // 分数在容忍区间内，但所在分段不在扫描范围内。扫描按分段进行，区间不足一个分段的部分会被舍去
+	ExclusionPendingAccept   = "pending_accept"   // 分段在扫描范围内，但在等待确认的小组中，小组解散后才会回到队列
+	ExclusionJoinedLater     = "joined_later"     // 加入时间晚于 currentTime，不在扫描的时间范围内，只在 currentTime 早于最近的加入时间时出现
+)
+
+// IterPlayerCandidates 扫描到的一个非空单元
+type CandidateCell struct {
+	Column   int // 二维表的时间分段
+	Band     int // 分数分段
+	Players  int // 单元中的人数，不包括玩家自己
+	Eligible int // 其中可以和玩家组成小组的人数
+}
+
+type Explanation struct {
+	Id               PlayerId
+	Score            PlayerScore
+	Band             int
+	ElapsedTime      Time
+	ScoreRadius      PlayerScore
+	MinBand          int // 扫描的分数分段范围，包含两端
+	MaxBand          int
+	ScannedCellCount int             // 扫描的单元数，包括空单元
+	Cells            []CandidateCell // 扫描到的非空单元，按扫描顺序
+	Eligible         int             // 可以和玩家组成小组的人数
+	Needed           int             // 组成小组还需要的人数，即每组人数减一
+	Excluded         map[string]int  // 各原因排除的玩家数，没有排除的原因不出现
+	MatchInProgress  bool            // 上一次 Match 因为预算停在了一轮的中间，玩家可能还没有被处理
+}
+
+// 按 MatchForPlayer 的方式扫描玩家的候选人，但是不会在人数足够时停止
+//
+// count 为每组人数，不大于 0 时使用最近一次 Match 的人数。
+// 候选人只受玩家自己的分数容忍区间限制，其他玩家的区间更大时仍然可能把玩家匹配进小组。
+func (m *Matcher) Explain(id PlayerId, currentTime Time, count int) (*Explanation, error) {
+	p, ok := m.players[id]
+	if !ok {
+		return nil, PlayerNotExistsError(id)
+	}
+	if p.Group != nil {
+		return nil, PlayerAlreadyMatchedError(id)
+	}
+	if count <= 0 {
+		count = m.matchCount
+	}
+	h := m.timeScoreGrid
+	e := &Explanation{
+		Id:              id,
+		Score:           p.Score,
+		Band:            h.GetYGroupIndex(int(p.Score)),
+		ElapsedTime:     currentTime - p.JoinTime,
+		ScoreRadius:     m.PlayerScoreRadius(p, currentTime),
+		Excluded:        make(map[string]int),
+		MatchInProgress: m.matchCursor != nil,
+	}
+	if count > 0 {
+		e.Needed = count - 1
+	}
+	jRadius := int(e.ScoreRadius) / h.YGroupLen
+	e.MinBand = e.Band - jRadius
+	if e.MinBand < 0 {
+		e.MinBand = 0
+	}
+	e.MaxBand = e.Band + jRadius
+	if e.MaxBand >= h.YCount {
+		e.MaxBand = h.YCount - 1
+	}
+
+	// 玩家自己在队列中，所以队列不会为空
+	startTime := Time(m.playerQueue.GetByRank(1, false).Score())
+	startI := h.GetXGroupIndex(m.timeToGridX(startTime))
+	endI := h.GetXGroupIndex(m.timeToGridX(currentTime))
+	columns := endI - startI + 1
+	if columns <= 0 {
+		columns += h.XCount
+	}
+	e.ScannedCellCount = columns * (e.MaxBand - e.MinBand + 1)
+
+	scanned := 0
+	cellIndex := make(map[[2]int]int)
+	m.IterPlayerCandidates(p, startTime, currentTime, e.ScoreRadius, func(v interface{}) bool {
+		candidate := v.(*Player)
+		if candidate == p {
+			return false
+		}
+		scanned++
+		key := [2]int{h.GetXGroupIndex(candidate.gridX), h.GetYGroupIndex(int(candidate.Score))}
+		k, ok := cellIndex[key]
+		if !ok {
+			k = len(e.Cells)
+			cellIndex[key] = k
+			e.Cells = append(e.Cells, CandidateCell{Column: key[0], Band: key[1]})
+		}
+		// 组成小组的玩家会从二维表中删除，扫描到的都可以组成小组
+		e.Cells[k].Players++
+		e.Cells[k].Eligible++
+		e.Eligible++
+		return false
+	})
+
+	// 没有扫描到的玩家，分段在范围内的是时间不在范围内
+	for j := 0; j < h.YCount; j++ {
+		if j < e.MinBand || j > e.MaxBand {
+			for i := 0; i < h.XCount; i++ {
+				h.EachInCell(i, j, func(v interface{}, i int, j int) bool {
+					if scoreDistance(v.(*Player).Score, p.Score) <= e.ScoreRadius {
+						e.Excluded[ExclusionBandGranularity]++
+					} else {
+						e.Excluded[ExclusionOutOfRadius]++
+					}
+					return false
+				})
+			}
+			continue
+		}
+		n := 0
+		for i := 0; i < h.XCount; i++ {
+			n += h.CellLen(i, j)
+		}
+		if j == e.Band {
+			n--
+		}
+		scanned -= n
+	}
+	if scanned < 0 {
+		e.Excluded[ExclusionJoinedLater] = -scanned
+	}
+	for _, g := range m.groups {
+		if !g.isPending() {
+			continue
+		}
+		for i, candidate := range g.Players {
+			if j := h.GetYGroupIndex(int(candidate.Score)); !g.removed[i] && j >= e.MinBand && j <= e.MaxBand {
+				e.Excluded[ExclusionPendingAccept]++
+			}
+		}
+	}
+	for reason, n := range e.Excluded {
+		if n == 0 {
+			delete(e.Excluded, reason)
+		}
+	}
+	return e, nil
+}
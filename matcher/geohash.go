@@ -5,11 +5,30 @@ package matcher
 // 返回 true 则终止迭代器
 type GeoHashIterFunc func(v interface{}, i int, j int) bool
 
+// 单元内的元素，每个单元是一个双向链表
+// 删除时直接从链表中摘下，不需要在单元内查找，也不会改变其他元素的顺序
+type GeoHashNode struct {
+	Value interface{}
+	prev  *GeoHashNode
+	next  *GeoHashNode
+	cell  *geoHashCell // 已删除时为 nil
+}
+
+func (n *GeoHashNode) Next() *GeoHashNode {
+	return n.next
+}
+
+type geoHashCell struct {
+	head *GeoHashNode
+	tail *GeoHashNode
+	len  int
+}
+
 // 二维一级线性映射 Hash 表
 // 这是一个专门为匹配队列设计的二维 Hash 表，不具有通用性
 // GeoHash 并没有真正打散，而是分段连续的映射
 type GeoHash struct {
-	Data [][][]interface{}
+	cells     [][]geoHashCell
 	XCount    int
 	YCount    int
 	XGroupLen int
@@ -23,12 +42,9 @@ func NewGeoHash(xCount int, yCount int, xGroupLen int, yGroupLen int) *GeoHash {
 		XGroupLen: xGroupLen,
 		YGroupLen: yGroupLen,
 	}
-	h.Data = make([][][]interface{}, xCount)
-	for i := range h.Data {
-		h.Data[i] = make([][]interface{}, yCount)
-		for j := range h.Data[i] {
-			h.Data[i][j] = make([]interface{}, 0)
-		}
+	h.cells = make([][]geoHashCell, xCount)
+	for i := range h.cells {
+		h.cells[i] = make([]geoHashCell, yCount)
 	}
 	return h
 }
@@ -45,28 +61,120 @@ func (h *GeoHash) GetXGroupIndex(x int) int {
 func (h *GeoHash) GetYGroupIndex(y int) int {
 	return y / h.YGroupLen
 }
+
+// 第 i 列第 j 行单元的第一个元素，单元为空时返回 nil
+func (h *GeoHash) Front(i int, j int) *GeoHashNode {
+	return h.cells[i][j].head
+}
+
+// 第 i 列第 j 行单元的元素个数
+func (h *GeoHash) CellLen(i int, j int) int {
+	return h.cells[i][j].len
+}
+
+// 按加入顺序遍历第 i 列第 j 行单元，iterFunc 返回 true 时终止并返回 true
+func (h *GeoHash) EachInCell(i int, j int, iterFunc GeoHashIterFunc) bool {
+	for n := h.cells[i][j].head; n != nil; n = n.next {
+		if iterFunc(n.Value, i, j) {
+			return true
+		}
+	}
+	return false
+}
+
+// 加入到单元末尾，返回的节点用于删除
+func (h *GeoHash) Add(x int, y int, item interface{}) *GeoHashNode {
+	c := &h.cells[h.GetXGroupIndex(x)][h.GetYGroupIndex(y)]
+	n := &GeoHashNode{Value: item, prev: c.tail, cell: c}
+	if c.tail == nil {
+		c.head = n
+	} else {
+		c.tail.next = n
+	}
+	c.tail = n
+	c.len++
+	return n
+}
+
+// 插入到同一单元的 before 之前，before 为 nil 时与 Add 相同
+func (h *GeoHash) InsertBefore(x int, y int, item interface{}, before *GeoHashNode) *GeoHashNode {
+	c := &h.cells[h.GetXGroupIndex(x)][h.GetYGroupIndex(y)]
+	if before == nil || before.cell != c {
+		return h.Add(x, y, item)
+	}
+	n := &GeoHashNode{Value: item, prev: before.prev, next: before, cell: c}
+	if before.prev == nil {
+		c.head = n
+	} else {
+		before.prev.next = n
+	}
+	before.prev = n
+	c.len++
+	return n
+}
+
+// 返回 (x, y) 所在单元的全部元素，按加入顺序排列，返回的是副本
+//
+// Deprecated: 单元已经改为链表，这里需要复制整个单元，请使用 Front 或 EachInCell 遍历
 func (h *GeoHash) GetGroup(x int, y int) []interface{} {
 	i := h.GetXGroupIndex(x)
 	j := h.GetYGroupIndex(y)
-	return h.Data[i][j]
+	items := make([]interface{}, 0, h.CellLen(i, j))
+	for n := h.Front(i, j); n != nil; n = n.next {
+		items = append(items, n.Value)
+	}
+	return items
 }
-func (h *GeoHash) Add(x int, y int, item interface{}) {
-	i := h.GetXGroupIndex(x)
-	j := h.GetYGroupIndex(y)
-	h.Data[i][j] = append(h.Data[i][j], item)
+
+// 返回全部单元的元素，Data()[i][j] 与原来的 Data 字段相同，返回的是副本，修改不会影响表
+//
+// Deprecated: Data 字段已经移除，这里需要复制整个表，请使用 Front、CellLen 或 EachInCell
+func (h *GeoHash) Data() [][][]interface{} {
+	data := make([][][]interface{}, h.XCount)
+	for i := range data {
+		data[i] = make([][]interface{}, h.YCount)
+		for j := range data[i] {
+			data[i][j] = make([]interface{}, 0, h.CellLen(i, j))
+			for n := h.Front(i, j); n != nil; n = n.next {
+				data[i][j] = append(data[i][j], n.Value)
+			}
+		}
+	}
+	return data
 }
+
+// 从 (x, y) 所在单元中删除 item，不在单元中时忽略
+//
+// Deprecated: 需要在单元内查找，请保存 Add 返回的节点并使用 Remove
 func (h *GeoHash) Del(x int, y int, item interface{}) {
 	i := h.GetXGroupIndex(x)
 	j := h.GetYGroupIndex(y)
-	for k, v := range h.Data[i][j] {
-		if v == item {
-			// 不是最后一个，则把最后一个拿到前面来
-			if k != len(h.Data[i][j])-1 {
-				h.Data[i][j][k] = h.Data[i][j][len(h.Data[i][j])-1]
-			}
-			// 删除掉最后一个
-			h.Data[i][j] = h.Data[i][j][:len(h.Data[i][j])-1]
-			break
+	for n := h.Front(i, j); n != nil; n = n.next {
+		if n.Value == item {
+			h.Remove(n)
+			return
 		}
 	}
 }
+
+// 删除节点，已经删除过的节点会被忽略
+func (h *GeoHash) Remove(n *GeoHashNode) {
+	if n == nil || n.cell == nil {
+		return
+	}
+	c := n.cell
+	if n.prev == nil {
+		c.head = n.next
+	} else {
+		n.prev.next = n.next
+	}
+	if n.next == nil {
+		c.tail = n.prev
+	} else {
+		n.next.prev = n.prev
+	}
+	c.len--
+	n.prev = nil
+	n.next = nil
+	n.cell = nil
+}
@@ -0,0 +1,176 @@
+package matcher_test
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/ganlvtech/go-game-matching/matcher"
+)
+
+func TestGeoHash_Remove(t *testing.T) {
+	h := matcher.NewGeoHash(2, 2, 10, 10)
+	nodes := make([]*matcher.GeoHashNode, 5)
+	for i := range nodes {
+		nodes[i] = h.Add(3, 15, i)
+	}
+	h.Remove(nodes[0])
+	h.Remove(nodes[2])
+	h.Remove(nodes[4])
+	h.Remove(nodes[2])
+	if h.CellLen(0, 1) != 2 {
+		t.Fatalf("cell len %d, want 2", h.CellLen(0, 1))
+	}
+	got := make([]int, 0)
+	h.EachInCell(0, 1, func(v interface{}, i int, j int) bool {
+		got = append(got, v.(int))
+		return false
+	})
+	if len(got) != 2 || got[0] != 1 || got[1] != 3 {
+		t.Errorf("cell order %v, want [1 3]", got)
+	}
+	h.Add(3, 15, 5)
+	if n := h.Front(0, 1); n.Value != 1 || n.Next().Value != 3 || n.Next().Next().Value != 5 {
+		t.Error("new item should be appended to the end")
+	}
+}
+
+func TestGeoHash_Deprecated(t *testing.T) {
+	h := matcher.NewGeoHash(2, 2, 10, 10)
+	for i := 0; i < 4; i++ {
+		h.Add(3, 15, i)
+	}
+	h.Del(3, 15, 1)
+	h.Del(3, 15, 9)
+	got := h.GetGroup(3, 15)
+	if len(got) != 3 || got[0] != 0 || got[1] != 2 || got[2] != 3 {
+		t.Errorf("group %v, want [0 2 3]", got)
+	}
+	if h.CellLen(0, 1) != 3 {
+		t.Errorf("cell len %d, want 3", h.CellLen(0, 1))
+	}
+	data := h.Data()
+	if len(data) != 2 || len(data[0]) != 2 || len(data[0][1]) != 3 || data[0][1][1] != 2 || len(data[1][1]) != 0 {
+		t.Errorf("data %v", data)
+	}
+}
+
+func TestMatcher_GetGroup(t *testing.T) {
+	m := matcher.NewMatcher(180, 300, 10)
+	for i := 0; i < 20; i++ {
+		_ = m.JoinQueue(matcher.PlayerId(strconv.Itoa(i)), 1000, 100)
+	}
+	m.Match(1000, 5)
+	if m.GroupCount() != 4 {
+		t.Fatalf("group count %d, want 4", m.GroupCount())
+	}
+	first := m.Groups()[0]
+	for _, id := range first.PlayerIds() {
+		m.Remove(id)
+	}
+	if _, err := m.GetGroup(first.Id); err == nil {
+		t.Error("removed group should not exist")
+	}
+	for _, g := range m.Groups() {
+		if g2, err := m.GetGroup(g.Id); err != nil || g2 != g {
+			t.Errorf("group %d lookup failed", g.Id)
+		}
+	}
+}
+
+// 改为链表之前的实现：单元是切片，删除时线性查找，再把最后一个元素移到删除的位置
+func sliceCellDel(cell []interface{}, item interface{}) []interface{} {
+	for k, v := range cell {
+		if v == item {
+			if k != len(cell)-1 {
+				cell[k] = cell[len(cell)-1]
+			}
+			return cell[:len(cell)-1]
+		}
+	}
+	return cell
+}
+
+func benchmarkGeoHashCellSize() []int {
+	return []int{10, 100, 1000, 10000}
+}
+
+func BenchmarkGeoHash_Remove(b *testing.B) {
+	for _, size := range benchmarkGeoHashCellSize() {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			h := matcher.NewGeoHash(1, 1, 10, 10)
+			nodes := make([]*matcher.GeoHashNode, size)
+			for i := range nodes {
+				nodes[i] = h.Add(0, 0, i)
+			}
+			r := rand.New(rand.NewSource(1))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				k := r.Intn(size)
+				h.Remove(nodes[k])
+				nodes[k] = h.Add(0, 0, k)
+			}
+		})
+	}
+}
+
+func BenchmarkGeoHash_RemoveSlice(b *testing.B) {
+	for _, size := range benchmarkGeoHashCellSize() {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			items := make([]*int, size)
+			cell := make([]interface{}, 0, size)
+			for i := range items {
+				items[i] = new(int)
+				cell = append(cell, items[i])
+			}
+			r := rand.New(rand.NewSource(1))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				k := r.Intn(size)
+				cell = sliceCellDel(cell, items[k])
+				cell = append(cell, items[k])
+			}
+		})
+	}
+}
+
+func newBenchmarkGroupMatcher(groupCount int) *matcher.Matcher {
+	m := matcher.NewMatcher(180, 300, 10)
+	for i := 0; i < groupCount*5; i++ {
+		_ = m.JoinQueue(matcher.PlayerId(strconv.Itoa(i)), 1000, 100)
+	}
+	m.Match(1000, 5)
+	return m
+}
+
+func BenchmarkMatcher_GetGroup(b *testing.B) {
+	for _, n := range []int{100, 10000} {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			m := newBenchmarkGroupMatcher(n)
+			r := rand.New(rand.NewSource(1))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, _ = m.GetGroup(matcher.GroupId(r.Intn(n) + 1))
+			}
+		})
+	}
+}
+
+// 改为下标索引之前按 id 找小组只能遍历全部小组
+func BenchmarkMatcher_GetGroupLinear(b *testing.B) {
+	for _, n := range []int{100, 10000} {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			m := newBenchmarkGroupMatcher(n)
+			r := rand.New(rand.NewSource(1))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				id := matcher.GroupId(r.Intn(n) + 1)
+				for _, g := range m.Groups() {
+					if g.Id == id {
+						break
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package matcher
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	json "github.com/json-iterator/go"
+)
+
+type SyncPolicy int
+
+const (
+	SyncAlways   SyncPolicy = iota // 每条操作写入后立即 fsync，最安全也最慢
+	SyncInterval                   // 定时 fsync，崩溃时最多丢失一个间隔内的操作
+	SyncNever                      // 由操作系统决定何时写入磁盘
+)
+
+func ParseSyncPolicy(s string) (SyncPolicy, bool) {
+	switch s {
+	case "always":
+		return SyncAlways, true
+	case "interval":
+		return SyncInterval, true
+	case "never":
+		return SyncNever, true
+	}
+	return 0, false
+}
+
+// 只追加的操作日志，每行一条 JSON 格式的 Operation
+//
+// 操作先写入日志再执行，崩溃后从最近的快照恢复，再重放快照之后的操作，即可得到崩溃前的状态
+type Journal struct {
+	mu        sync.Mutex
+	path      string
+	file      *os.File
+	w         *bufio.Writer
+	policy    SyncPolicy
+	seq       uint64
+	dirty     bool
+	closeChan chan struct{}
+}
+
+// 打开日志并继续追加，日志末尾写了一半的操作会被截断
+// 序号从日志中最后一条操作和 minSeq 中较大的一个继续，日志压缩后为空时，需要传入快照中的 AppliedSeq
+func OpenJournal(path string, minSeq uint64, policy SyncPolicy, syncInterval time.Duration) (*Journal, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	seq, size, err := scanJournal(file)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	if err := file.Truncate(size); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(size, io.SeekStart); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	if seq < minSeq {
+		seq = minSeq
+	}
+	j := &Journal{
+		path:      path,
+		file:      file,
+		w:         bufio.NewWriter(file),
+		policy:    policy,
+		seq:       seq,
+		closeChan: make(chan struct{}),
+	}
+	if policy == SyncInterval {
+		go j.syncLoop(syncInterval)
+	}
+	return j, nil
+}
+
+// 返回最后一条完整操作的序号和完整操作的总长度
+func scanJournal(r io.Reader) (uint64, int64, error) {
+	seq := uint64(0)
+	size := int64(0)
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// 没有换行符结尾的是写了一半的操作
+			return seq, size, nil
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		op := &Operation{}
+		if err := json.Unmarshal(line, op); err != nil {
+			if _, err := reader.Peek(1); err == io.EOF {
+				return seq, size, nil
+			}
+			return 0, 0, InvalidJournalError(err.Error())
+		}
+		seq = op.Seq
+		size += int64(len(line))
+	}
+}
+
+func (j *Journal) syncLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			j.mu.Lock()
+			if j.dirty {
+				_ = j.sync()
+			}
+			j.mu.Unlock()
+		case <-j.closeChan:
+			return
+		}
+	}
+}
+
+// 写入操作，并为操作分配序号
+func (j *Journal) Append(op *Operation) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	op.Seq = j.seq + 1
+	b, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	if _, err := j.w.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	j.seq = op.Seq
+	j.dirty = true
+	switch j.policy {
+	case SyncAlways:
+		return j.sync()
+	case SyncNever:
+		return j.w.Flush()
+	}
+	return nil
+}
+
+func (j *Journal) sync() error {
+	if err := j.w.Flush(); err != nil {
+		return err
+	}
+	j.dirty = false
+	return j.file.Sync()
+}
+
+func (j *Journal) Sync() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.sync()
+}
+
+// 最后一条操作的序号
+func (j *Journal) Seq() uint64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.seq
+}
+
+// 压缩日志，调用者需要先保存包含全部已写入操作的快照，之后日志中的操作就都不需要了
+// 序号会继续递增，不会从头开始
+func (j *Journal) Truncate() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.w.Flush(); err != nil {
+		return err
+	}
+	if err := j.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := j.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	j.dirty = false
+	return j.file.Sync()
+}
+
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.policy == SyncInterval {
+		close(j.closeChan)
+	}
+	if err := j.sync(); err != nil {
+		_ = j.file.Close()
+		return err
+	}
+	return j.file.Close()
+}
+
+// 重放日志中的操作，已经执行过的序号（不大于 AppliedSeq）会被跳过，返回执行的操作数
+// 单个操作执行失败（比如玩家已存在）不影响重放，与写入日志时的结果是一致的
+func (m *Matcher) ReplayJournal(r io.Reader) (int, error) {
+	count := 0
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 && err == nil {
+			op := &Operation{}
+			if err := json.Unmarshal(line, op); err != nil {
+				return count, InvalidJournalError(err.Error())
+			}
+			if op.Seq > m.appliedSeq {
+				_ = m.Apply(op)
+				count++
+			}
+		}
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package matcher_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/ganlvtech/go-game-matching/matcher"
+)
+
+func TestJournal_Replay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal.jsonl")
+
+	j, err := matcher.OpenJournal(path, 0, matcher.SyncNever, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := matcher.NewMatcher(180, 300, 10)
+	r := rand.New(rand.NewSource(2))
+	apply := func(op *matcher.Operation) {
+		if err := j.Append(op); err != nil {
+			t.Fatal(err)
+		}
+		_ = m.Apply(op)
+	}
+	var snapshot bytes.Buffer
+	id := 0
+	for now := matcher.Time(1000); now < 1400; now++ {
+		for i := 0; i < 10; i++ {
+			apply(&matcher.Operation{Type: matcher.OperationJoin, Id: matcher.PlayerId(strconv.Itoa(id)), Time: now, Score: matcher.PlayerScore(r.Intn(300))})
+			id++
+		}
+		apply(&matcher.Operation{Type: matcher.OperationLeave, Id: matcher.PlayerId(strconv.Itoa(r.Intn(id)))})
+		apply(&matcher.Operation{Type: matcher.OperationRemove, Id: matcher.PlayerId(strconv.Itoa(r.Intn(id)))})
+		apply(&matcher.Operation{Type: matcher.OperationMatch, Time: now, Count: 5})
+		if now%100 == 0 {
+			apply(&matcher.Operation{Type: matcher.OperationSweep, Time: now - 150})
+		}
+		if now == 1200 {
+			// 压缩日志
+			snapshot.Reset()
+			if err := m.WriteSnapshot(&snapshot); err != nil {
+				t.Fatal(err)
+			}
+			if err := j.Truncate(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 模拟崩溃时写了一半的操作
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString(`{"seq":99999,"op":"jo`)
+	_ = f.Close()
+
+	m2 := matcher.NewMatcher(180, 300, 10)
+	if err := m2.ReadSnapshot(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+	f, err = os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, err := m2.ReplayJournal(f)
+	_ = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count == 0 {
+		t.Fatal("nothing replayed")
+	}
+
+	var b1, b2 bytes.Buffer
+	_ = m.WriteSnapshot(&b1)
+	_ = m2.WriteSnapshot(&b2)
+	if !bytes.Equal(b1.Bytes(), b2.Bytes()) {
+		t.Fatal("replayed matcher differs")
+	}
+
+	j, err = matcher.OpenJournal(path, 0, matcher.SyncAlways, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if j.Seq() != m.AppliedSeq() {
+		t.Errorf("journal seq = %d, want %d", j.Seq(), m.AppliedSeq())
+	}
+}
@@ -0,0 +1,112 @@
+package matcher
+
+import (
+	"time"
+
+	"github.com/wangjia184/sortedset"
+)
+
+// 一次 Match 的工作预算，都为 0 表示不限制
+//
+// 队列很长时一次 Match 可能持有锁很久，设置预算后每次 Match 只处理一部分玩家，
+// 下一次 Match 从上次停下的位置继续，仍然按加入顺序处理，不会有玩家一直轮不到。
+type MatchBudget struct {
+	MaxPlayers  int           // 最多处理的玩家数
+	MaxDuration time.Duration // 最长处理时间，超时后处理完当前玩家就停止
+}
+
+func (b MatchBudget) IsZero() bool {
+	return b.MaxPlayers == 0 && b.MaxDuration == 0
+}
+
+// 玩家在队列中的位置，按加入时间和 id 排序
+type queuePosition struct {
+	JoinTime Time     `json:"join_time"`
+	Id       PlayerId `json:"id"`
+}
+
+func (a *queuePosition) less(b *queuePosition) bool {
+	return a.JoinTime < b.JoinTime || (a.JoinTime == b.JoinTime && a.Id < b.Id)
+}
+
+// 队列中排在 pos 之后的第一个玩家的排名（从 1 开始），pos 为 nil 时返回 1
+func (m *Matcher) queueRankAfter(pos *queuePosition) int {
+	if pos == nil {
+		return 1
+	}
+	if node := m.playerQueue.GetByKey(string(pos.Id)); node != nil && node.Score() == sortedset.SCORE(pos.JoinTime) {
+		return m.playerQueue.FindRank(string(pos.Id)) + 1
+	}
+	// 上次处理的玩家已经离开队列，二分查找
+	lo, hi := 1, m.playerQueue.GetCount()+1
+	for lo < hi {
+		mid := (lo + hi) / 2
+		node := m.playerQueue.GetByRank(mid, false)
+		if (&queuePosition{JoinTime: Time(node.Score()), Id: PlayerId(node.Key())}).less(pos) {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo
+}
+
+// 按预算匹配，返回处理的玩家数
+//
+// 一轮从队首处理到队尾，预算用完时记住位置，下次从这里继续。如果本次是从上一轮中间开始的，
+// 处理到队尾后预算还有剩余，就从队首开始新的一轮，直到遇到本次开始时的位置。
+// 处理的玩家数相同时结果是确定的，按 MatchBudget{MaxPlayers: 返回值} 重放可以得到相同的状态。
+func (m *Matcher) MatchWithBudget(currentTime Time, count int, budget MatchBudget) int {
+	start := m.Clock.Now()
+	m.updateCurrentTime(currentTime)
+	m.matchCount = count
+	m.AutoRemove(currentTime)
+	m.expirePendingGroups(currentTime)
+	m.estimator.AddTimeAuto(float64(currentTime))
+	if m.adaptiveRadius != nil {
+		m.adaptiveRadius.update(currentTime)
+	}
+
+	tickStart := m.matchCursor
+	wrapped := false
+	processed := 0
+	for {
+		rank := m.queueRankAfter(m.matchCursor)
+		var node *sortedset.SortedSetNode
+		if rank <= m.playerQueue.GetCount() {
+			node = m.playerQueue.GetByRank(rank, false)
+		}
+		if node == nil || Time(node.Score()) > currentTime {
+			// 一轮结束
+			m.matchCursor = nil
+			if wrapped || tickStart == nil {
+				break
+			}
+			wrapped = true
+			continue
+		}
+		pos := &queuePosition{JoinTime: Time(node.Score()), Id: PlayerId(node.Key())}
+		if wrapped && tickStart.less(pos) {
+			// 新的一轮已经追上本次开始的位置，所有玩家都处理过了，下次从队首开始
+			m.matchCursor = nil
+			break
+		}
+		if budget.MaxPlayers > 0 && processed >= budget.MaxPlayers {
+			break
+		}
+		if budget.MaxDuration > 0 && processed > 0 && m.Clock.Now().Sub(start) >= budget.MaxDuration {
+			break
+		}
+		m.matchCursor = pos
+		_ = m.MatchForPlayer(pos.Id, currentTime, count)
+		processed++
+	}
+
+	m.estimator.Merge()
+	return processed
+}
+
+// 上一次 Match 是否因为预算用完停在了一轮的中间
+func (m *Matcher) MatchInProgress() bool {
+	return m.matchCursor != nil
+}
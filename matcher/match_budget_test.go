@@ -0,0 +1,72 @@
+package matcher_test
+
+import (
+	"bytes"
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ganlvtech/go-game-matching/matcher"
+)
+
+func TestMatcher_MatchWithBudget(t *testing.T) {
+	m := matcher.NewMatcher(180, 300, 10)
+	for i := 0; i < 10; i++ {
+		_ = m.JoinQueue(matcher.PlayerId(strconv.Itoa(i)), 1000, matcher.PlayerScore(i*30))
+	}
+	// 每组人数很多，不会匹配成功，只检查处理进度
+	budget := matcher.MatchBudget{MaxPlayers: 4}
+	for i, want := range []int{4, 4, 4, 4} {
+		if n := m.MatchWithBudget(1001, 100, budget); n != want {
+			t.Errorf("tick %d processed %d, want %d", i, n, want)
+		}
+	}
+	if !m.MatchInProgress() {
+		t.Error("match should be in progress")
+	}
+	// 一共 16 人次，第二轮处理到第 6 个人，剩余 4 人处理完后回到队首，遇到本次开始的位置停止，下次从队首开始
+	if n := m.MatchWithBudget(1001, 100, matcher.MatchBudget{}); n != 10 {
+		t.Errorf("unlimited tick processed %d, want 10", n)
+	}
+	if n := m.MatchWithBudget(1001, 100, matcher.MatchBudget{}); n != 10 {
+		t.Errorf("unlimited tick processed %d, want 10", n)
+	}
+	if m.MatchInProgress() {
+		t.Error("match should not be in progress")
+	}
+}
+
+func TestMatcher_MatchWithBudget_Replay(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	m := matcher.NewMatcher(180, 300, 10)
+	m.MatchBudget = matcher.MatchBudget{MaxPlayers: 7}
+	ops := make([]*matcher.Operation, 0)
+	apply := func(op *matcher.Operation) {
+		_ = m.Apply(op)
+		ops = append(ops, op)
+	}
+	id := 0
+	for tm := matcher.Time(1000); tm < 1200; tm++ {
+		for i := 0; i < 10; i++ {
+			apply(&matcher.Operation{Type: matcher.OperationJoin, Id: matcher.PlayerId(strconv.Itoa(id)), Time: tm, Score: matcher.PlayerScore(r.Intn(300))})
+			id++
+		}
+		apply(&matcher.Operation{Type: matcher.OperationMatch, Time: tm, Count: 5})
+	}
+
+	m2 := matcher.NewMatcher(180, 300, 10)
+	m2.MatchBudget = matcher.MatchBudget{MaxDuration: time.Nanosecond}
+	for _, op := range ops {
+		_ = m2.Apply(op)
+	}
+	var b1, b2 bytes.Buffer
+	_ = m.WriteSnapshot(&b1)
+	_ = m2.WriteSnapshot(&b2)
+	if !bytes.Equal(b1.Bytes(), b2.Bytes()) {
+		t.Error("replayed matcher differs")
+	}
+	if m.GroupCount() == 0 {
+		t.Error("no group matched")
+	}
+}
@@ -2,6 +2,9 @@ package matcher
 
 import (
 	"math"
+	"sort"
+	"strconv"
+	"time"
 
 	"github.com/wangjia184/sortedset"
 )
@@ -11,22 +14,30 @@ const (
 )
 
 type PlayerId string
+type GroupId uint64
 type Time int
 type PlayerScore uint
 type OnGroupMatchedEventCallback func(group *Group)
 type ScoreRadiusFunc func(deltaT Time) PlayerScore
+type PlayerScoreRadiusFunc func(p *Player, currentTime Time) PlayerScore
 
 type Group struct {
-	Players      []*Player
-	removed      []bool
-	removedCount int
+	Id            GroupId
+	Players       []*Player
+	removed       []bool
+	removedCount  int
+	accepted      []bool
+	acceptedCount int
+	owner         *Matcher // 登记该小组的匹配器，分片时小组中的玩家可能来自多个匹配器
 }
 
 func NewGroup(count int) *Group {
 	return &Group{
-		Players:      make([]*Player, count),
-		removed:      make([]bool, count),
-		removedCount: 0,
+		Players:       make([]*Player, count),
+		removed:       make([]bool, count),
+		removedCount:  0,
+		accepted:      make([]bool, count),
+		acceptedCount: 0,
 	}
 }
 
@@ -34,6 +45,16 @@ func (g *Group) isEmpty() bool {
 	return g.removedCount >= len(g.Players)
 }
 
+// 还有没被删除的玩家在等待小组内所有玩家确认
+func (g *Group) isPending() bool {
+	for i, p := range g.Players {
+		if !g.removed[i] && p.state == PlayerStatePendingAccept {
+			return true
+		}
+	}
+	return false
+}
+
 func (g *Group) softRemove(p *Player) {
 	for i, v := range g.Players {
 		if v == p && !g.removed[i] {
@@ -112,29 +133,76 @@ func (g *Group) AverageWaitTime(currentTime Time) float64 {
 // 管理统计函数结束 ==========
 
 type Player struct {
-	Id       PlayerId
-	JoinTime Time
-	gridX    int
-	Score    PlayerScore
-	Group    *Group
+	Id        PlayerId
+	JoinTime  Time
+	gridX     int
+	gridNode  *GeoHashNode // 在二维表中的节点，不在队列中时已被删除
+	Score     PlayerScore
+	Group     *Group
+	state     PlayerState
+	matchTime Time
+	predicted *WaitTimeEstimate // 加入时的预计等待时间，有结果后清空
+}
+
+func (p *Player) State() PlayerState {
+	return p.state
 }
 
 type Matcher struct {
-	players                     map[PlayerId]*Player // 全部玩家
-	playerQueue                 *sortedset.SortedSet // 未匹配的玩家队列
-	timeScoreGrid               *GeoHash             // 为匹配的玩家二维 Hash 表
-	groups                      []*Group             // 已匹配成功的队列
-	waitTime                    *WaitTime            // 分组等待时间
+	players                     map[PlayerId]*Player         // 全部玩家
+	finishedPlayers             map[PlayerId]*finishedPlayer // 已离开的玩家的最终状态
+	playerQueue                 *sortedset.SortedSet         // 未匹配的玩家队列
+	timeScoreGrid               *GeoHash                     // 为匹配的玩家二维 Hash 表
+	groups                      []*Group                     // 已匹配成功的队列
+	groupIndex                  map[GroupId]int              // 小组在 groups 中的下标
+	estimator                   Estimator                    // 分组等待时间
+	bandJoinCounts              []int                        // 各分数段累计加入人数
+	bandMatchCounts             []int                        // 各分数段累计匹配成功人数
+	predictionStats             []PredictionStats            // 各分数段预计等待时间的误差
+	timedOutCount               int                          // 累计超时人数
+	nextGroupId                 GroupId
+	matchCount                  int            // 最近一次 Match 每组的人数
+	appliedSeq                  uint64         // 最后一条已执行的操作日志序号
+	currentTime                 Time           // 最近一次 Match 或加入队列的时间
+	matchCursor                 *queuePosition // 上一次 Match 最后处理的玩家，为 nil 表示从队首开始
+	maxTime                     Time
+	maxScore                    PlayerScore
+	timeUnit                    time.Duration        // Time 的单位
+	radiusCurve                 *RadiusCurve         // 为 nil 时使用默认的 ScoreRadiusFunc
+	adaptiveRadius              *AdaptiveScoreRadius // 不为 nil 时每次 Match 开始时更新系数
 	ScoreRadiusFunc             ScoreRadiusFunc
+	PlayerScoreRadiusFunc       PlayerScoreRadiusFunc // 不为 nil 时代替 ScoreRadiusFunc，可以根据玩家和队列状态计算
 	OnGroupMatchedEventCallback OnGroupMatchedEventCallback
+	RequireAccept               bool        // 匹配成功后是否需要小组内所有玩家 Accept
+	AcceptTimeout               Time        // 等待确认的最长时间，超时后没有确认的玩家被移出，其他玩家回到队列，0 表示不限制
+	MatchBudget                 MatchBudget // 每次 Match 的工作预算，默认不限制
+	Clock                       Clock       // 只用于计算 MatchBudget 的处理时间，匹配使用的时间都由调用者传入
+	Events                      *EventBus
 }
 
 func NewMatcher(maxTime Time, maxScore PlayerScore, scoreGroupLen int) *Matcher {
+	return NewMatcherWithTimeUnit(maxTime, maxScore, scoreGroupLen, time.Second)
+}
+
+// Time 使用 unit 为单位，例如 time.Millisecond，maxTime 也使用这个单位
+func NewMatcherWithTimeUnit(maxTime Time, maxScore PlayerScore, scoreGroupLen int, unit time.Duration) *Matcher {
+	if unit <= 0 {
+		unit = time.Second
+	}
+	// 时间分组为 3 到 10 秒
+	minTimeGroupLen := int(3 * time.Second / unit)
+	maxTimeGroupLen := int(10 * time.Second / unit)
+	if minTimeGroupLen < 1 {
+		minTimeGroupLen = 1
+	}
+	if maxTimeGroupLen < 1 {
+		maxTimeGroupLen = 1
+	}
 	timeGroupLen := int(maxTime) / TimeGroupCount
-	if timeGroupLen < 3 {
-		timeGroupLen = 3
-	} else if timeGroupLen > 10 {
-		timeGroupLen = 10
+	if timeGroupLen < minTimeGroupLen {
+		timeGroupLen = minTimeGroupLen
+	} else if timeGroupLen > maxTimeGroupLen {
+		timeGroupLen = maxTimeGroupLen
 	}
 	timeGroupCount := int(maxTime) / timeGroupLen
 	if scoreGroupLen < 1 {
@@ -145,11 +213,21 @@ func NewMatcher(maxTime Time, maxScore PlayerScore, scoreGroupLen int) *Matcher
 		return nil
 	}
 	return &Matcher{
-		players:       make(map[PlayerId]*Player),
-		playerQueue:   sortedset.New(),
-		timeScoreGrid: NewGeoHash(timeGroupCount, scoreGroupCount, timeGroupLen, scoreGroupLen),
-		groups:        make([]*Group, 0, 64),
-		waitTime:      NewWaitTime(scoreGroupCount, float64(maxTime)),
+		players:         make(map[PlayerId]*Player),
+		finishedPlayers: make(map[PlayerId]*finishedPlayer),
+		playerQueue:     sortedset.New(),
+		timeScoreGrid:   NewGeoHash(timeGroupCount, scoreGroupCount, timeGroupLen, scoreGroupLen),
+		groups:          make([]*Group, 0, 64),
+		groupIndex:      make(map[GroupId]int),
+		estimator:       NewWaitTime(scoreGroupCount, float64(maxTime)),
+		bandJoinCounts:  make([]int, scoreGroupCount),
+		bandMatchCounts: make([]int, scoreGroupCount),
+		predictionStats: make([]PredictionStats, scoreGroupCount),
+		maxTime:         maxTime,
+		maxScore:        maxScore,
+		timeUnit:        unit,
+		Clock:           SystemClock,
+		Events:          NewEventBus(),
 		ScoreRadiusFunc: func(deltaT Time) PlayerScore {
 			score := maxScore/30 + maxScore*PlayerScore(deltaT)/PlayerScore(maxTime/2)/2
 			if score > maxScore {
@@ -183,63 +261,222 @@ func (m *Matcher) JoinQueue(id PlayerId, joinTime Time, score PlayerScore) error
 	if m.Exists(id) {
 		return PlayerAlreadyExistsError(id)
 	}
+	// PlayerScore 是无符号数，负数转换后是很大的数，不能先转换为 int 再比较
+	if score >= m.maxScore {
+		return PlayerScoreOutOfRangeError(score)
+	}
 	p := &Player{
 		Id:       id,
 		JoinTime: joinTime,
 		gridX:    m.timeToGridX(joinTime),
 		Score:    score,
 		Group:    nil,
+		state:    PlayerStateSearching,
 	}
 	m.players[id] = p
+	delete(m.finishedPlayers, id)
 	m.playerQueue.AddOrUpdate(string(p.Id), sortedset.SCORE(joinTime), p)
-	m.timeScoreGrid.Add(p.gridX, int(score), p)
+	p.gridNode = m.timeScoreGrid.Add(p.gridX, int(score), p)
+	m.bandJoinCounts[m.ScoreBandIndex(score)]++
+	predicted := m.GetWaitTimeEstimateByScore(score)
+	p.predicted = &predicted
+	m.updateCurrentTime(joinTime)
+	m.Events.Publish(Event{Type: EventPlayerJoined, Time: joinTime, PlayerId: id, Score: score})
 	return nil
 }
 
+func (m *Matcher) updateCurrentTime(t Time) {
+	if t > m.currentTime {
+		m.currentTime = t
+	}
+}
+
 // 离开队列，用于仍在匹配中的玩家
+// 等待确认的玩家离开视为拒绝，小组被解散，其他玩家按原来的加入时间回到队列
 func (m *Matcher) LeaveQueue(id PlayerId) error {
+	return m.leaveQueue(id)
+}
+
+// others 为跨分片小组中其他玩家所在的匹配器
+func (m *Matcher) leaveQueue(id PlayerId, others ...*Matcher) error {
 	p, ok := m.players[id]
 	if !ok {
 		return PlayerNotExistsError(id)
 	}
-	// 如果匹配完成中，禁止离开队列
 	if p.Group != nil {
-		return PlayerAlreadyMatchedError(id)
+		// 如果匹配完成中，禁止离开队列
+		if p.state != PlayerStatePendingAccept {
+			return PlayerAlreadyMatchedError(id)
+		}
+		m.remove(id, Event{Type: EventPlayerLeft}, others...)
+		return nil
 	}
 	m.playerQueue.Remove(string(id))
-	m.timeScoreGrid.Del(p.gridX, int(p.Score), p)
+	m.timeScoreGrid.Remove(p.gridNode)
 	delete(m.players, id)
+	m.finishPlayer(p, PlayerStateLeft)
+	m.Events.Publish(Event{Type: EventPlayerLeft, Time: m.currentTime, PlayerId: id, Score: p.Score})
 	return nil
 }
 
 // 删除用户，用于已匹配到的玩家（如果玩家仍在匹配中，则会强制移出队列）
 func (m *Matcher) Remove(id PlayerId) {
+	m.remove(id, Event{Type: EventPlayerRemoved, Reason: RemoveReasonRemove})
+}
+
+// e 为删除后要发出的事件，others 为跨分片小组中其他玩家所在的匹配器
+// 等待确认的小组中有玩家被删除时，小组被解散，其他玩家回到队列
+func (m *Matcher) remove(id PlayerId, e Event, others ...*Matcher) {
 	p, ok := m.players[id]
 	if ok {
 		m.playerQueue.Remove(string(id))
-		m.timeScoreGrid.Del(p.gridX, int(p.Score), p)
+		m.timeScoreGrid.Remove(p.gridNode)
 		delete(m.players, id)
+		switch e.Type {
+		case EventPlayerTimedOut:
+			m.timedOutCount++
+			m.observePrediction(p, m.currentTime-p.JoinTime, true)
+			m.finishPlayer(p, PlayerStateTimedOut)
+		case EventPlayerLeft:
+			m.finishPlayer(p, PlayerStateLeft)
+		default:
+			m.finishPlayer(p, PlayerStateRemoved)
+		}
+		e.Time = m.currentTime
+		e.PlayerId = id
+		e.Score = p.Score
+		if p.Group != nil {
+			e.GroupId = p.Group.Id
+		}
+		m.Events.Publish(e)
 		if p.Group != nil {
 			p.Group.softRemove(p)
 			if p.Group.isEmpty() {
-				m.removeGroup(p.Group)
+				p.Group.owner.removeGroup(p.Group)
+				m.Events.Publish(Event{Type: EventGroupClosed, Time: m.currentTime, GroupId: p.Group.Id, PlayerIds: p.Group.PlayerIds()})
+			} else if p.Group.isPending() {
+				m.disbandGroup(p.Group, others...)
 			}
 		}
 	}
 }
 
 func (m *Matcher) removeGroup(g *Group) {
-	for i, v := range m.groups {
-		if v == g {
-			if i != len(m.groups)-1 {
-				m.groups[i] = m.groups[len(m.groups)-1]
+	i, ok := m.groupIndex[g.Id]
+	if !ok || m.groups[i] != g {
+		return
+	}
+	last := m.groups[len(m.groups)-1]
+	m.groups[i] = last
+	m.groupIndex[last.Id] = i
+	m.groups = m.groups[:len(m.groups)-1]
+	delete(m.groupIndex, g.Id)
+}
+
+// 解散小组，没有被删除的玩家按原来的加入时间回到队列，仍然按加入顺序优先匹配
+// 回到队列的玩家从分段的累计匹配人数中扣除，再次匹配成功时重新计入
+// 预计等待时间算法中已经记录的等待时间不会撤销，再次匹配成功时会再记录一次；预计误差统计只记录第一次匹配的结果
+func (m *Matcher) DisbandGroup(id GroupId) error {
+	g, err := m.GetGroup(id)
+	if err != nil {
+		return err
+	}
+	m.disbandGroup(g)
+	return nil
+}
+
+// others 为跨分片小组中其他玩家所在的匹配器
+func (m *Matcher) disbandGroup(g *Group, others ...*Matcher) {
+	g.owner.removeGroup(g)
+	m.requeueGroupPlayers(g)
+	for _, o := range others {
+		o.requeueGroupPlayers(g)
+	}
+	g.owner.Events.Publish(Event{Type: EventGroupDisbanded, Time: m.currentTime, GroupId: g.Id, PlayerIds: g.PlayerIds()})
+}
+
+// 小组中属于本匹配器、没有被删除的玩家回到队列
+func (m *Matcher) requeueGroupPlayers(g *Group) {
+	for _, p := range g.Players {
+		if m.players[p.Id] != p || p.Group != g {
+			continue
+		}
+		p.Group = nil
+		p.matchTime = 0
+		p.state = PlayerStateSearching
+		m.bandMatchCounts[m.ScoreBandIndex(p.Score)]--
+		m.playerQueue.AddOrUpdate(string(p.Id), sortedset.SCORE(p.JoinTime), p)
+		// 单元内保持加入顺序
+		h := m.timeScoreGrid
+		before := h.Front(h.GetXGroupIndex(p.gridX), h.GetYGroupIndex(int(p.Score)))
+		for before != nil && before.Value.(*Player).JoinTime <= p.JoinTime {
+			before = before.Next()
+		}
+		p.gridNode = h.InsertBefore(p.gridX, int(p.Score), p, before)
+	}
+}
+
+// 等待确认超过 AcceptTimeout 的小组，没有确认的玩家按超时移出，其他玩家回到队列
+// 只处理玩家都在本匹配器和 others 中的小组，跨分片的小组在匹配分片边界时处理
+func (m *Matcher) expirePendingGroups(currentTime Time, others ...*Matcher) {
+	if m.AcceptTimeout <= 0 {
+		return
+	}
+	matchers := append([]*Matcher{m}, others...)
+	expired := make([]*Group, 0)
+Outer:
+	for _, g := range m.groups {
+		if !g.isPending() {
+			continue
+		}
+		for i, p := range g.Players {
+			if g.removed[i] {
+				continue
+			}
+			if p.matchTime+m.AcceptTimeout > currentTime {
+				continue Outer
+			}
+			if playerOwner(matchers, p) == nil {
+				continue Outer
 			}
-			m.groups = m.groups[:len(m.groups)-1]
-			break
+		}
+		expired = append(expired, g)
+	}
+	sort.Slice(expired, func(i, j int) bool {
+		return expired[i].Id < expired[j].Id
+	})
+	for _, g := range expired {
+		timedOut := make([]*Player, 0)
+		for i, p := range g.Players {
+			if !g.removed[i] && !g.accepted[i] {
+				timedOut = append(timedOut, p)
+			}
+		}
+		m.disbandGroup(g, others...)
+		for _, p := range timedOut {
+			playerOwner(matchers, p).remove(p.Id, Event{Type: EventPlayerTimedOut})
 		}
 	}
 }
 
+// 玩家所在的匹配器，都不在时返回 nil
+func playerOwner(matchers []*Matcher, p *Player) *Matcher {
+	for _, m := range matchers {
+		if m.players[p.Id] == p {
+			return m
+		}
+	}
+	return nil
+}
+
+func (m *Matcher) GetGroup(id GroupId) (*Group, error) {
+	i, ok := m.groupIndex[id]
+	if !ok {
+		return nil, GroupNotExistsError(id)
+	}
+	return m.groups[i], nil
+}
+
 func (m *Matcher) IterPlayerCandidates(p *Player, startTime Time, currentTime Time, scoreRadius PlayerScore, iterFunc func(v interface{}) bool) {
 	h := m.timeScoreGrid
 	startI := h.GetXGroupIndex(m.timeToGridX(startTime))
@@ -255,24 +492,24 @@ Outer:
 		for j := 0; j <= jRadius; j++ {
 			if j == 0 {
 				// 由于 j == 0 所以 j2 = middleJ + j = middleJ
-				for _, v := range h.Data[i2][middleJ] {
-					if iterFunc(v) {
+				for n := h.Front(i2, middleJ); n != nil; n = n.Next() {
+					if iterFunc(n.Value) {
 						break Outer
 					}
 				}
 			} else {
 				j2 := middleJ - j
 				if j2 >= 0 {
-					for _, v := range h.Data[i2][j2] {
-						if iterFunc(v) {
+					for n := h.Front(i2, j2); n != nil; n = n.Next() {
+						if iterFunc(n.Value) {
 							break Outer
 						}
 					}
 				}
 				j2 = middleJ + j
 				if j2 < h.YCount {
-					for _, v := range h.Data[i2][j2] {
-						if iterFunc(v) {
+					for n := h.Front(i2, j2); n != nil; n = n.Next() {
+						if iterFunc(n.Value) {
 							break Outer
 						}
 					}
@@ -295,7 +532,7 @@ func (m *Matcher) MatchForPlayer(id PlayerId, currentTime Time, count int) error
 	}
 	g := NewGroup(count)
 	i := 0
-	scoreRadius := m.ScoreRadiusFunc(currentTime - p.JoinTime)
+	scoreRadius := m.PlayerScoreRadius(p, currentTime)
 	startTime := Time(m.playerQueue.GetByRank(1, false).Score())
 	m.IterPlayerCandidates(p, startTime, currentTime, scoreRadius, func(v interface{}) bool {
 		candidate := v.(*Player)
@@ -304,13 +541,9 @@ func (m *Matcher) MatchForPlayer(id PlayerId, currentTime Time, count int) error
 			i++
 		}
 		if i >= count {
-			m.groups = append(m.groups, g)
-			for _, matchedPlayer := range g.Players {
-				m.playerQueue.Remove(string(matchedPlayer.Id))
-				m.timeScoreGrid.Del(matchedPlayer.gridX, int(matchedPlayer.Score), matchedPlayer)
-				matchedPlayer.Group = g
-				m.waitTime.AddItem(m.timeScoreGrid.GetYGroupIndex(int(matchedPlayer.Score)), float64(currentTime-matchedPlayer.JoinTime))
-			}
+			m.addGroup(g)
+			m.takeGroupPlayers(g, currentTime)
+			m.Events.Publish(Event{Type: EventGroupFormed, Time: currentTime, GroupId: g.Id, PlayerIds: g.PlayerIds()})
 			if m.OnGroupMatchedEventCallback != nil {
 				m.OnGroupMatchedEventCallback(g)
 			}
@@ -321,25 +554,86 @@ func (m *Matcher) MatchForPlayer(id PlayerId, currentTime Time, count int) error
 	return nil
 }
 
+// 收集分数容忍区间内最多 limit 个未匹配的玩家，顺序与 MatchForPlayer 相同
+func (m *Matcher) collectCandidates(p *Player, currentTime Time, scoreRadius PlayerScore, limit int) []*Player {
+	candidates := make([]*Player, 0, limit)
+	if m.playerQueue.GetCount() == 0 {
+		return candidates
+	}
+	startTime := Time(m.playerQueue.GetByRank(1, false).Score())
+	m.IterPlayerCandidates(p, startTime, currentTime, scoreRadius, func(v interface{}) bool {
+		candidate := v.(*Player)
+		if candidate.Group == nil {
+			candidates = append(candidates, candidate)
+		}
+		return len(candidates) >= limit
+	})
+	return candidates
+}
+
+func (m *Matcher) addGroup(g *Group) {
+	m.nextGroupId++
+	g.Id = m.nextGroupId
+	g.owner = m
+	m.groupIndex[g.Id] = len(m.groups)
+	m.groups = append(m.groups, g)
+}
+
+// 把小组中属于本匹配器的玩家移出队列，标记为已匹配
+func (m *Matcher) takeGroupPlayers(g *Group, currentTime Time) {
+	for _, matchedPlayer := range g.Players {
+		if m.players[matchedPlayer.Id] != matchedPlayer {
+			continue
+		}
+		m.playerQueue.Remove(string(matchedPlayer.Id))
+		m.timeScoreGrid.Remove(matchedPlayer.gridNode)
+		matchedPlayer.Group = g
+		matchedPlayer.matchTime = currentTime
+		if m.RequireAccept {
+			matchedPlayer.state = PlayerStatePendingAccept
+		} else {
+			matchedPlayer.state = PlayerStateMatched
+		}
+		m.bandMatchCounts[m.ScoreBandIndex(matchedPlayer.Score)]++
+		m.estimator.AddItem(m.timeScoreGrid.GetYGroupIndex(int(matchedPlayer.Score)), float64(currentTime-matchedPlayer.JoinTime))
+		m.observePrediction(matchedPlayer, currentTime-matchedPlayer.JoinTime, false)
+	}
+}
+
+// 使用声明式的分数容忍区间曲线代替 ScoreRadiusFunc
+func (m *Matcher) SetRadiusCurve(c *RadiusCurve) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	m.radiusCurve = c
+	m.ScoreRadiusFunc = c.ScoreRadiusFuncWithTimeUnit(m.maxScore, m.timeUnit)
+	return nil
+}
+
+func (m *Matcher) RadiusCurve() *RadiusCurve {
+	return m.radiusCurve
+}
+
+// 玩家当前的分数容忍区间，只读取状态，启用自适应分数容忍区间时也不会更新系数
+func (m *Matcher) PlayerScoreRadius(p *Player, currentTime Time) PlayerScore {
+	if m.PlayerScoreRadiusFunc != nil {
+		return m.PlayerScoreRadiusFunc(p, currentTime)
+	}
+	return m.ScoreRadiusFunc(currentTime - p.JoinTime)
+}
+
 func (m *Matcher) getMinTime(currentTime Time) Time {
 	return currentTime - Time(m.timeScoreGrid.XLen())
 }
 
 func (m *Matcher) AutoRemove(currentTime Time) {
 	for _, v := range m.playerQueue.GetByScoreRange(sortedset.SCORE(0), sortedset.SCORE(m.getMinTime(currentTime)), &sortedset.GetByScoreRangeOptions{ExcludeEnd: true}) {
-		m.Remove(PlayerId(v.Key()))
+		m.remove(PlayerId(v.Key()), Event{Type: EventPlayerTimedOut})
 	}
 }
 
 func (m *Matcher) Match(currentTime Time, count int) {
-	m.AutoRemove(currentTime)
-	m.waitTime.AddTimeAuto(float64(currentTime))
-
-	for _, v := range m.playerQueue.GetByScoreRange(sortedset.SCORE(0), sortedset.SCORE(currentTime), nil) {
-		_ = m.MatchForPlayer(PlayerId(v.Key()), currentTime, count)
-	}
-
-	m.waitTime.Merge()
+	m.MatchWithBudget(currentTime, count, m.MatchBudget)
 }
 
 func (m *Matcher) GetMatchedPlayers(id PlayerId) ([]PlayerId, error) {
@@ -365,15 +659,155 @@ func (m *Matcher) GetPlayerApproxWaitTime(id PlayerId) (int, error) {
 }
 
 func (m *Matcher) GetWaitTimeByScore(score PlayerScore) int {
-	return int(m.waitTime.Groups[m.timeScoreGrid.GetYGroupIndex(int(score))])
+	return int(m.estimator.GroupWaitTimes()[m.ScoreBandIndex(score)])
+}
+
+func (m *Matcher) GetWaitTimeEstimateByScore(score PlayerScore) WaitTimeEstimate {
+	return m.estimator.Estimate(m.ScoreBandIndex(score))
+}
+
+// 替换预计等待时间的算法，分段数必须与匹配器一致，已有的统计不会转移到新的算法
+func (m *Matcher) SetEstimator(e Estimator) error {
+	if e.GroupCount() != m.ScoreBandCount() {
+		return InvalidEstimatorError("group count " + strconv.Itoa(e.GroupCount()) + " does not match score band count " + strconv.Itoa(m.ScoreBandCount()))
+	}
+	m.estimator = e
+	return nil
+}
+
+func (m *Matcher) Estimator() Estimator {
+	return m.estimator
+}
+
+// 分数所在的分段，与等待时间分组一致
+func (m *Matcher) ScoreBandIndex(score PlayerScore) int {
+	return m.timeScoreGrid.GetYGroupIndex(int(score))
+}
+
+func (m *Matcher) MaxTime() Time {
+	return m.maxTime
+}
+
+func (m *Matcher) MaxScore() PlayerScore {
+	return m.maxScore
+}
+
+func (m *Matcher) ScoreGroupLen() int {
+	return m.timeScoreGrid.YGroupLen
+}
+
+func (m *Matcher) ScoreBandCount() int {
+	return m.timeScoreGrid.YCount
+}
+
+// 分段中仍在匹配的人数
+func (m *Matcher) BandPlayerInQueueCount(band int) int {
+	sum := 0
+	for i := 0; i < m.timeScoreGrid.XCount; i++ {
+		sum += m.timeScoreGrid.CellLen(i, band)
+	}
+	return sum
+}
+
+// 二维表每个单元中仍在匹配的人数，第一维为时间分段，第二维为分数分段
+// 二维表按时间循环使用，GridColumn 为某个时间所在的时间分段
+func (m *Matcher) GridCellCounts() [][]int {
+	h := m.timeScoreGrid
+	counts := make([][]int, h.XCount)
+	for i := range counts {
+		counts[i] = make([]int, h.YCount)
+		for j := range counts[i] {
+			counts[i][j] = h.CellLen(i, j)
+		}
+	}
+	return counts
+}
+
+func (m *Matcher) GridColumn(t Time) int {
+	return m.timeScoreGrid.GetXGroupIndex(m.timeToGridX(t))
+}
+
+func (m *Matcher) TimeGroupLen() int {
+	return m.timeScoreGrid.XGroupLen
+}
+
+func (m *Matcher) GridColumnCount() int {
+	return m.timeScoreGrid.XCount
+}
+
+// 分段的概况，用于发现长时间匹配不到的分段
+type BandSummary struct {
+	Band              int
+	Queued            int // 仍在匹配的人数
+	Joined            int // 累计加入人数
+	Matched           int // 累计匹配成功人数
+	EstimatedWaitTime WaitTimeEstimate
+	AverageAge        float64 // 仍在匹配的玩家平均已等待时间
+	MaxAge            Time    // 仍在匹配的玩家最长已等待时间
+}
+
+// 各分段的概况，已等待时间按 currentTime 计算
+func (m *Matcher) BandSummaries(currentTime Time) []BandSummary {
+	h := m.timeScoreGrid
+	summaries := make([]BandSummary, h.YCount)
+	for j := range summaries {
+		s := &summaries[j]
+		s.Band = j
+		s.Joined = m.bandJoinCounts[j]
+		s.Matched = m.bandMatchCounts[j]
+		s.EstimatedWaitTime = m.estimator.Estimate(j)
+		sum := Time(0)
+		for i := 0; i < h.XCount; i++ {
+			h.EachInCell(i, j, func(v interface{}, i int, j int) bool {
+				age := currentTime - v.(*Player).JoinTime
+				sum += age
+				if age > s.MaxAge {
+					s.MaxAge = age
+				}
+				s.Queued++
+				return false
+			})
+		}
+		if s.Queued > 0 {
+			s.AverageAge = float64(sum) / float64(s.Queued)
+		}
+	}
+	return summaries
+}
+
+// 累计超时人数，只增不减，从快照恢复后从 0 开始
+func (m *Matcher) TimedOutCount() int {
+	return m.timedOutCount
+}
+
+// 分段累计加入人数，只增不减，用于计算加入速度
+func (m *Matcher) BandJoinCount(band int) int {
+	return m.bandJoinCounts[band]
 }
 
 func (m *Matcher) Sweep(before Time) {
-	for id := range m.players {
-		if m.players[id].JoinTime < before {
-			m.Remove(id)
+	m.sweep(before)
+}
+
+// others 为跨分片的小组可能涉及的其他匹配器，调用前需要全部加锁
+func (m *Matcher) sweep(before Time, others ...*Matcher) {
+	// 按加入时间顺序删除，保证重放操作日志时结果一致
+	expired := make([]*Player, 0)
+	for _, p := range m.players {
+		if p.JoinTime < before {
+			expired = append(expired, p)
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool {
+		if expired[i].JoinTime != expired[j].JoinTime {
+			return expired[i].JoinTime < expired[j].JoinTime
 		}
+		return expired[i].Id < expired[j].Id
+	})
+	for _, p := range expired {
+		m.remove(p.Id, Event{Type: EventPlayerRemoved, Reason: RemoveReasonSweep}, others...)
 	}
+	m.sweepFinishedPlayers(before)
 }
 
 // 仅用于管理的函数 ==========
@@ -448,15 +882,15 @@ func (m *Matcher) GroupsPlayerIds() [][]PlayerId {
 }
 
 func (m *Matcher) GroupWaitTime() []float64 {
-	return m.waitTime.Groups
+	return m.estimator.GroupWaitTimes()
 }
 
 func (m *Matcher) AverageWaitTime() float64 {
 	sum := float64(0)
-	for _, t := range m.waitTime.Groups {
+	for _, t := range m.estimator.GroupWaitTimes() {
 		sum += t
 	}
-	return sum / float64(m.waitTime.GroupCount())
+	return sum / float64(m.estimator.GroupCount())
 }
 
 // 仅用于管理的函数结束 ==========
@@ -2,6 +2,7 @@ package matcher_test
 
 import (
 	"math/rand"
+	"reflect"
 	"strconv"
 	"sync"
 	"testing"
@@ -51,3 +52,190 @@ func BenchmarkMatcher_Match(b *testing.B) {
 func BenchmarkMatcher_Match_1000(b *testing.B) {
 	benchmarkMatcher_Match(b, 1000)
 }
+
+func TestMatcher_JoinQueue_ScoreOutOfRange(t *testing.T) {
+	m := matcher.NewMatcher(120, 300, 10)
+	if err := m.JoinQueue("a", 1000, 299); err != nil {
+		t.Error(err)
+	}
+	if _, ok := m.JoinQueue("b", 1000, 300).(matcher.PlayerScoreOutOfRangeError); !ok {
+		t.Error("score 300 should be out of range")
+	}
+	if m.Exists("b") {
+		t.Error("player with invalid score should not be added")
+	}
+}
+
+func TestMatcher_JoinQueue_NegativeScore(t *testing.T) {
+	m := matcher.NewMatcher(120, 300, 10)
+	score := -20
+	if _, ok := m.JoinQueue("a", 1000, matcher.PlayerScore(score)).(matcher.PlayerScoreOutOfRangeError); !ok {
+		t.Error("negative score should be out of range")
+	}
+	if m.Exists("a") || m.PlayerInQueueCount() != 0 {
+		t.Error("player with negative score should not be added")
+	}
+}
+
+func TestMatcher_DisbandGroup(t *testing.T) {
+	m := matcher.NewMatcher(120, 300, 10)
+	_ = m.JoinQueue("a", 1000, 100)
+	_ = m.JoinQueue("b", 1001, 101)
+	_ = m.JoinQueue("c", 1002, 102)
+	m.Match(1003, 2)
+	g := m.Groups()[0]
+	m.Remove("b")
+	if err := m.Apply(&matcher.Operation{Type: matcher.OperationDisband, GroupId: g.Id}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.GetGroup(g.Id); err == nil {
+		t.Error("disbanded group still exists")
+	}
+	if state, _ := m.GetPlayerState("a"); state != matcher.PlayerStateSearching {
+		t.Errorf("a is %v", state)
+	}
+	if m.Exists("b") || m.PlayerInQueueCount() != 2 {
+		t.Errorf("%d players in queue", m.PlayerInQueueCount())
+	}
+	if err := m.DisbandGroup(g.Id); err == nil {
+		t.Error("disbanded twice")
+	}
+
+	// 回到队列的玩家仍然按原来的加入时间优先匹配
+	_ = m.JoinQueue("d", 1004, 100)
+	m.Match(1005, 2)
+	ids, err := m.GetMatchedPlayers("a")
+	if err != nil || len(ids) != 2 || ids[0] != "a" || ids[1] != "c" {
+		t.Errorf("a matched with %v, %v", ids, err)
+	}
+}
+
+// 记录 AddItem 的次数
+type countingEstimator struct {
+	matcher.Estimator
+	items int
+}
+
+func (e *countingEstimator) AddItem(groupIndex int, t float64) {
+	e.items++
+	e.Estimator.AddItem(groupIndex, t)
+}
+
+func TestMatcher_DisbandGroupCounts(t *testing.T) {
+	m := matcher.NewMatcher(120, 300, 10)
+	e := &countingEstimator{Estimator: m.Estimator()}
+	if err := m.SetEstimator(e); err != nil {
+		t.Fatal(err)
+	}
+	_ = m.JoinQueue("a", 1000, 100)
+	_ = m.JoinQueue("b", 1001, 101)
+	m.Match(1003, 2)
+	if err := m.DisbandGroup(m.Groups()[0].Id); err != nil {
+		t.Fatal(err)
+	}
+	if s := m.BandSummaries(1003)[10]; s.Matched != 0 || s.Queued != 2 {
+		t.Errorf("after disband %+v", s)
+	}
+	m.Match(1010, 2)
+	if s := m.BandSummaries(1010)[10]; s.Matched != 2 || s.Joined != 2 {
+		t.Errorf("after rematch %+v", s)
+	}
+	// 预计误差只按第一次匹配统计，预计等待时间算法两次都记录
+	if s := m.PredictionStats()[10]; s.Count != 2 {
+		t.Errorf("prediction count %d, want 2", s.Count)
+	}
+	if e.items != 4 {
+		t.Errorf("estimator items %d, want 4", e.items)
+	}
+}
+
+func TestMatcher_Explain(t *testing.T) {
+	m := matcher.NewMatcher(120, 300, 10)
+	m.RequireAccept = true
+	m.ScoreRadiusFunc = func(deltaT matcher.Time) matcher.PlayerScore {
+		return 15
+	}
+	// e 和 f 组成的小组等待确认
+	_ = m.JoinQueue("e", 950, 105)
+	_ = m.JoinQueue("f", 950, 106)
+	m.Match(955, 2)
+	_ = m.JoinQueue("a", 960, 100)
+	_ = m.JoinQueue("b", 960, 115)
+	_ = m.JoinQueue("c", 965, 150)
+	// 差 12 分，在容忍区间内，但区间不足两个分段，只扫描相邻的分段 9 到 11
+	_ = m.JoinQueue("d", 970, 88)
+	e, err := m.Explain("a", 975, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	columns := m.GridColumn(975) - m.GridColumn(960) + 1
+	if e.ElapsedTime != 15 || e.ScoreRadius != 15 || e.MinBand != 9 || e.MaxBand != 11 || e.ScannedCellCount != columns*3 {
+		t.Errorf("explanation %+v", e)
+	}
+	if len(e.Cells) != 1 || e.Cells[0].Band != 11 || e.Cells[0].Eligible != 1 {
+		t.Errorf("cells %+v", e.Cells)
+	}
+	want := map[string]int{
+		matcher.ExclusionOutOfRadius:     1,
+		matcher.ExclusionBandGranularity: 1,
+		matcher.ExclusionPendingAccept:   2,
+	}
+	if e.Eligible != 1 || e.Needed != 3 || !reflect.DeepEqual(e.Excluded, want) {
+		t.Errorf("eligible %d, needed %d, excluded %v", e.Eligible, e.Needed, e.Excluded)
+	}
+	if m.PlayerInQueueCount() != 4 || m.GroupCount() != 1 {
+		t.Error("explain changed the matcher")
+	}
+
+	// 小组解散后回到队列，可以组成小组
+	_ = m.LeaveQueue("e")
+	if e, _ := m.Explain("a", 975, 4); e.Eligible != 2 || len(e.Excluded) != 2 {
+		t.Errorf("after decline: eligible %d, excluded %v", e.Eligible, e.Excluded)
+	}
+
+	m.Match(975, 2)
+	if _, err := m.Explain("a", 975, 0); err == nil {
+		t.Error("explained a matched player")
+	}
+	if _, err := m.Explain("x", 975, 0); err == nil {
+		t.Error("explained a player not exists")
+	}
+}
+
+func TestMatcher_Preview(t *testing.T) {
+	m := matcher.NewMatcher(120, 300, 10)
+	_ = m.JoinQueue("a", 960, 100)
+	_ = m.JoinQueue("b", 960, 101)
+	_ = m.JoinQueue("c", 960, 150)
+	_ = m.JoinQueue("d", 960, 151)
+	callbacks := 0
+	m.OnGroupMatchedEventCallback = func(g *matcher.Group) {
+		callbacks++
+	}
+	sub := m.Events.Subscribe(16)
+
+	r, err := m.Preview(961, matcher.PreviewOptions{MatchCount: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Groups) != 2 || r.MatchedCount != 4 || r.PlayerInQueueCount != 0 || r.AverageWaitTime != 1 || r.MaxStandardDeviation != 0.5 {
+		t.Errorf("preview %+v", r)
+	}
+	r, _ = m.Preview(961, matcher.PreviewOptions{MatchCount: 4})
+	if len(r.Groups) != 0 || r.PlayerInQueueCount != 4 {
+		t.Errorf("preview 4 players %+v", r)
+	}
+	c, _ := matcher.ParseRadiusCurve(`{"type":"linear","base":100}`)
+	r, _ = m.Preview(961, matcher.PreviewOptions{MatchCount: 4, RadiusCurve: c})
+	if len(r.Groups) != 1 || r.MaxStandardDeviation < 25 {
+		t.Errorf("preview with curve %+v", r)
+	}
+
+	if m.PlayerInQueueCount() != 4 || m.GroupCount() != 0 || callbacks != 0 || len(sub.C) != 0 || m.RadiusCurve() != nil {
+		t.Errorf("preview changed the matcher, %d callbacks, %d events", callbacks, len(sub.C))
+	}
+	m.Match(961, 2)
+	if m.GroupCount() != 2 || callbacks != 2 {
+		t.Errorf("%d groups after match", m.GroupCount())
+	}
+}
@@ -0,0 +1,79 @@
+package matcher
+
+type OperationType string
+
+const (
+	OperationJoin        OperationType = "join"
+	OperationLeave       OperationType = "leave"
+	OperationRemove      OperationType = "remove"
+	OperationAccept      OperationType = "accept"
+	OperationMatch       OperationType = "match"
+	OperationSweep       OperationType = "sweep"
+	OperationRadiusCurve OperationType = "radius_curve"
+	OperationDisband     OperationType = "disband"
+)
+
+// 会改变匹配器状态的操作，所有状态变化都通过操作完成时，按顺序重放操作可以得到完全相同的匹配器
+//
+// Time 对于 join 是加入时间，对于 match 是当前时间，对于 sweep 是 before
+type Operation struct {
+	Seq         uint64        `json:"seq,omitempty"`
+	Type        OperationType `json:"op"`
+	Id          PlayerId      `json:"id,omitempty"`
+	Time        Time          `json:"time,omitempty"`
+	Score       PlayerScore   `json:"score,omitempty"`
+	Count       int           `json:"count,omitempty"`
+	Processed   int           `json:"processed,omitempty"` // match 处理的玩家数，为 0 时使用 MatchBudget
+	RadiusCurve *RadiusCurve  `json:"radius_curve,omitempty"`
+	GroupId     GroupId       `json:"group_id,omitempty"`
+}
+
+type UnknownOperationError OperationType
+
+func (e UnknownOperationError) Error() string {
+	return "unknown operation. op = " + string(e)
+}
+
+// 执行操作，Seq 不为 0 时记录为最后执行的序号，已经执行过的序号会被忽略
+//
+// match 执行后 op.Processed 会被设置为实际处理的玩家数，之后再写入操作日志可以按相同的人数重放
+//
+// 注意 PlayerScoreRadiusFunc 如果有自己的状态，这部分状态不在快照中，重放结果可能不同
+// AdaptiveScoreRadius 的系数保存在快照中，从同一个快照开始重放可以得到相同的系数
+func (m *Matcher) Apply(op *Operation) error {
+	if op.Seq != 0 {
+		if op.Seq <= m.appliedSeq {
+			return nil
+		}
+		m.appliedSeq = op.Seq
+	}
+	switch op.Type {
+	case OperationJoin:
+		return m.JoinQueue(op.Id, op.Time, op.Score)
+	case OperationLeave:
+		return m.LeaveQueue(op.Id)
+	case OperationRemove:
+		m.Remove(op.Id)
+	case OperationAccept:
+		return m.Accept(op.Id)
+	case OperationMatch:
+		budget := m.MatchBudget
+		if op.Processed > 0 {
+			budget = MatchBudget{MaxPlayers: op.Processed}
+		}
+		op.Processed = m.MatchWithBudget(op.Time, op.Count, budget)
+	case OperationSweep:
+		m.Sweep(op.Time)
+	case OperationRadiusCurve:
+		return m.SetRadiusCurve(op.RadiusCurve)
+	case OperationDisband:
+		return m.DisbandGroup(op.GroupId)
+	default:
+		return UnknownOperationError(op.Type)
+	}
+	return nil
+}
+
+func (m *Matcher) AppliedSeq() uint64 {
+	return m.appliedSeq
+}
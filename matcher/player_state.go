@@ -0,0 +1,158 @@
+package matcher
+
+type PlayerState int
+
+const (
+	PlayerStateSearching     PlayerState = iota + 1 // 正在匹配
+	PlayerStatePendingAccept                        // 已匹配到小组，等待小组内所有玩家确认
+	PlayerStateMatched                              // 匹配完成
+	PlayerStateTimedOut                             // 超过最长匹配时间被移出队列
+	PlayerStateLeft                                 // 主动离开队列
+	PlayerStateRemoved                              // 已被删除
+)
+
+func (s PlayerState) String() string {
+	switch s {
+	case PlayerStateSearching:
+		return "searching"
+	case PlayerStatePendingAccept:
+		return "pending-accept"
+	case PlayerStateMatched:
+		return "matched"
+	case PlayerStateTimedOut:
+		return "timed-out"
+	case PlayerStateLeft:
+		return "left"
+	case PlayerStateRemoved:
+		return "removed"
+	}
+	return "unknown"
+}
+
+// 已经不在匹配器中的玩家的最终状态，Sweep 时清除
+type finishedPlayer struct {
+	state      PlayerState
+	score      PlayerScore
+	joinTime   Time
+	finishTime Time
+}
+
+type PlayerStatus struct {
+	Id                PlayerId
+	State             PlayerState
+	Score             PlayerScore
+	JoinTime          Time
+	ElapsedTime       Time        // 已等待时间，匹配成功或离开后为当时的等待时间
+	ScoreRadius       PlayerScore // 当前分数容忍区间，仅在匹配中有效
+	EstimatedWaitTime Time        // 预计剩余等待时间，仅在匹配中有效
+	BandPosition      int         // 在同分段仍在匹配的玩家中的位置，从 1 开始，仅在匹配中有效
+	BandCount         int         // 同分段仍在匹配的人数
+	GroupId           GroupId
+	PlayerIds         []PlayerId
+}
+
+func (m *Matcher) finishPlayer(p *Player, state PlayerState) {
+	m.finishedPlayers[p.Id] = &finishedPlayer{
+		state:      state,
+		score:      p.Score,
+		joinTime:   p.JoinTime,
+		finishTime: m.currentTime,
+	}
+}
+
+// 确认匹配结果，小组内所有玩家都确认后进入 PlayerStateMatched
+func (m *Matcher) Accept(id PlayerId) error {
+	p, ok := m.players[id]
+	if !ok {
+		return PlayerNotExistsError(id)
+	}
+	if p.Group == nil {
+		return PlayerNotMatchedError(id)
+	}
+	g := p.Group
+	for i, v := range g.Players {
+		if v == p && !g.accepted[i] {
+			g.accepted[i] = true
+			g.acceptedCount++
+		}
+	}
+	if g.acceptedCount >= len(g.Players) {
+		for _, v := range g.Players {
+			v.state = PlayerStateMatched
+		}
+	}
+	return nil
+}
+
+func (m *Matcher) GetPlayerState(id PlayerId) (PlayerState, error) {
+	if p, ok := m.players[id]; ok {
+		return p.state, nil
+	}
+	if f, ok := m.finishedPlayers[id]; ok {
+		return f.state, nil
+	}
+	return 0, PlayerNotExistsError(id)
+}
+
+// 玩家的详细状态，从未加入或者已经被 Sweep 清除的玩家返回 PlayerNotExistsError
+func (m *Matcher) GetPlayerStatus(id PlayerId, currentTime Time) (*PlayerStatus, error) {
+	p, ok := m.players[id]
+	if !ok {
+		f, ok := m.finishedPlayers[id]
+		if !ok {
+			return nil, PlayerNotExistsError(id)
+		}
+		return &PlayerStatus{
+			Id:          id,
+			State:       f.state,
+			Score:       f.score,
+			JoinTime:    f.joinTime,
+			ElapsedTime: f.finishTime - f.joinTime,
+		}, nil
+	}
+	s := &PlayerStatus{
+		Id:       id,
+		State:    p.state,
+		Score:    p.Score,
+		JoinTime: p.JoinTime,
+	}
+	if p.Group != nil {
+		s.ElapsedTime = p.matchTime - p.JoinTime
+		s.GroupId = p.Group.Id
+		s.PlayerIds = p.Group.PlayerIds()
+		return s, nil
+	}
+	s.ElapsedTime = currentTime - p.JoinTime
+	s.ScoreRadius = m.PlayerScoreRadius(p, currentTime)
+	s.EstimatedWaitTime = Time(m.GetWaitTimeByScore(p.Score)) - s.ElapsedTime
+	if s.EstimatedWaitTime < 0 {
+		s.EstimatedWaitTime = 0
+	}
+	s.BandPosition, s.BandCount = m.bandPosition(p)
+	return s, nil
+}
+
+// 同分段中先于该玩家加入的人数加一，只统计同一个分段，所以是近似的排队位置
+func (m *Matcher) bandPosition(p *Player) (int, int) {
+	band := m.ScoreBandIndex(p.Score)
+	position := 1
+	count := 0
+	for i := 0; i < m.timeScoreGrid.XCount; i++ {
+		for n := m.timeScoreGrid.Front(i, band); n != nil; n = n.Next() {
+			other := n.Value.(*Player)
+			count++
+			if other.JoinTime < p.JoinTime || (other.JoinTime == p.JoinTime && other.Id < p.Id) {
+				position++
+			}
+		}
+	}
+	return position, count
+}
+
+func (m *Matcher) sweepFinishedPlayers(before Time) {
+	for id, f := range m.finishedPlayers {
+		if f.joinTime < before {
+			delete(m.finishedPlayers, id)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package matcher_test
+
+import (
+	"testing"
+
+	"github.com/ganlvtech/go-game-matching/matcher"
+)
+
+func TestMatcher_GetPlayerStatus(t *testing.T) {
+	m := matcher.NewMatcher(180, 300, 10)
+	m.RequireAccept = true
+	_ = m.JoinQueue("a", 100, 150)
+	_ = m.JoinQueue("b", 101, 151)
+	_ = m.JoinQueue("c", 102, 155)
+	_ = m.JoinQueue("d", 100, 10)
+	_ = m.JoinQueue("e", 100, 290)
+	_ = m.LeaveQueue("e")
+
+	s, err := m.GetPlayerStatus("c", 110)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.State != matcher.PlayerStateSearching || s.ElapsedTime != 8 || s.BandPosition != 3 || s.BandCount != 3 || s.ScoreRadius == 0 {
+		t.Errorf("unexpected status %+v", s)
+	}
+
+	m.Match(110, 2)
+	assertPlayerState(t, m, "a", matcher.PlayerStatePendingAccept)
+	assertPlayerState(t, m, "c", matcher.PlayerStateSearching)
+	_ = m.Accept("a")
+	assertPlayerState(t, m, "a", matcher.PlayerStatePendingAccept)
+	_ = m.Accept("b")
+	assertPlayerState(t, m, "a", matcher.PlayerStateMatched)
+	assertPlayerState(t, m, "b", matcher.PlayerStateMatched)
+	if err := m.Accept("c"); err == nil {
+		t.Error("accept without group should fail")
+	}
+
+	m.Remove("a")
+	m.Match(1000, 2)
+	assertPlayerState(t, m, "a", matcher.PlayerStateRemoved)
+	assertPlayerState(t, m, "d", matcher.PlayerStateTimedOut)
+	assertPlayerState(t, m, "e", matcher.PlayerStateLeft)
+	if _, err := m.GetPlayerStatus("f", 1000); err == nil {
+		t.Error("player never joined should not exist")
+	}
+}
+
+func assertPlayerState(t *testing.T, m *matcher.Matcher, id matcher.PlayerId, want matcher.PlayerState) {
+	t.Helper()
+	state, err := m.GetPlayerState(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != want {
+		t.Errorf("state of %s = %v, want %v", id, state, want)
+	}
+}
+
+func TestMatcher_AcceptDecline(t *testing.T) {
+	m := matcher.NewMatcher(180, 300, 10)
+	m.RequireAccept = true
+	_ = m.JoinQueue("a", 100, 150)
+	_ = m.JoinQueue("b", 101, 151)
+	_ = m.JoinQueue("c", 105, 152)
+	_ = m.JoinQueue("d", 106, 153)
+	m.Match(110, 2)
+	assertPlayerState(t, m, "a", matcher.PlayerStatePendingAccept)
+	assertPlayerState(t, m, "c", matcher.PlayerStatePendingAccept)
+
+	// 等待确认时离开相当于拒绝，小组解散，其他玩家按原来的加入时间回到队列
+	_ = m.Accept("a")
+	if err := m.LeaveQueue("b"); err != nil {
+		t.Fatal(err)
+	}
+	assertPlayerState(t, m, "a", matcher.PlayerStateSearching)
+	assertPlayerState(t, m, "b", matcher.PlayerStateLeft)
+	if p := m.Players()["a"]; p.JoinTime != 100 || p.Group != nil {
+		t.Errorf("requeued player %+v", p)
+	}
+
+	// 被移出时同样解散
+	m.Remove("d")
+	assertPlayerState(t, m, "c", matcher.PlayerStateSearching)
+	assertPlayerState(t, m, "d", matcher.PlayerStateRemoved)
+	if len(m.Groups()) != 0 {
+		t.Errorf("%d groups left", len(m.Groups()))
+	}
+
+	m.Match(111, 2)
+	assertPlayerState(t, m, "a", matcher.PlayerStatePendingAccept)
+	assertPlayerState(t, m, "c", matcher.PlayerStatePendingAccept)
+}
+
+func TestMatcher_AcceptTimeout(t *testing.T) {
+	m := matcher.NewMatcher(180, 300, 10)
+	m.RequireAccept = true
+	m.AcceptTimeout = 10
+	_ = m.JoinQueue("a", 100, 150)
+	_ = m.JoinQueue("b", 101, 151)
+	m.Match(110, 2)
+	_ = m.Accept("a")
+
+	m.Match(119, 2)
+	assertPlayerState(t, m, "b", matcher.PlayerStatePendingAccept)
+
+	// 超时后没有确认的玩家被移出，确认过的玩家回到队列
+	m.Match(120, 2)
+	assertPlayerState(t, m, "a", matcher.PlayerStateSearching)
+	assertPlayerState(t, m, "b", matcher.PlayerStateTimedOut)
+	if p := m.Players()["a"]; p.JoinTime != 100 {
+		t.Errorf("requeued player %+v", p)
+	}
+	if len(m.Groups()) != 0 {
+		t.Errorf("%d groups left", len(m.Groups()))
+	}
+}
@@ -0,0 +1,93 @@
+package matcher
+
+// 一个分段预计等待时间的误差统计，玩家加入时记录当时的预计值，匹配成功或超时后与实际等待时间比较
+//
+// 超时的玩家实际等待时间按超时时已经等待的时间计算，实际还会更长
+type PredictionStats struct {
+	Count               int     `json:"count"`     // 有结果的玩家数，包括超时的玩家
+	TimedOut            int     `json:"timed_out"` // 超时的玩家数
+	ErrorSum            float64 `json:"error_sum"` // 实际减去预计
+	AbsErrorSum         float64 `json:"abs_error_sum"`
+	WithinExpectedCount int     `json:"within_expected_count"` // 实际不超过 Expected 的人数
+	WithinHighCount     int     `json:"within_high_count"`     // 实际不超过 High 的人数
+}
+
+func (s *PredictionStats) add(predicted *WaitTimeEstimate, actual float64, timedOut bool) {
+	s.Count++
+	if timedOut {
+		s.TimedOut++
+	}
+	err := actual - predicted.Expected
+	s.ErrorSum += err
+	if err < 0 {
+		err = -err
+	}
+	s.AbsErrorSum += err
+	if actual <= predicted.Expected {
+		s.WithinExpectedCount++
+	}
+	if actual <= predicted.High {
+		s.WithinHighCount++
+	}
+}
+
+func (s *PredictionStats) Merge(o PredictionStats) {
+	s.Count += o.Count
+	s.TimedOut += o.TimedOut
+	s.ErrorSum += o.ErrorSum
+	s.AbsErrorSum += o.AbsErrorSum
+	s.WithinExpectedCount += o.WithinExpectedCount
+	s.WithinHighCount += o.WithinHighCount
+}
+
+// 平均误差，正数表示预计偏短
+func (s PredictionStats) Bias() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.ErrorSum / float64(s.Count)
+}
+
+// 平均绝对误差
+func (s PredictionStats) MAE() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.AbsErrorSum / float64(s.Count)
+}
+
+// 实际不超过 Expected 的比例，Expected 为中位数时应接近 0.5
+func (s PredictionStats) WithinExpected() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return float64(s.WithinExpectedCount) / float64(s.Count)
+}
+
+// 实际不超过 High 的比例，区间准确时应接近 0.9
+func (s PredictionStats) WithinHigh() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return float64(s.WithinHighCount) / float64(s.Count)
+}
+
+func (m *Matcher) observePrediction(p *Player, actual Time, timedOut bool) {
+	if p.predicted == nil {
+		// 旧快照恢复的玩家没有预计值
+		return
+	}
+	m.predictionStats[m.ScoreBandIndex(p.Score)].add(p.predicted, float64(actual), timedOut)
+	p.predicted = nil
+}
+
+// 各分段的误差统计，从匹配器创建开始累计，保存在快照中，ResetPredictionStats 之后重新开始
+func (m *Matcher) PredictionStats() []PredictionStats {
+	s := make([]PredictionStats, len(m.predictionStats))
+	copy(s, m.predictionStats)
+	return s
+}
+
+func (m *Matcher) ResetPredictionStats() {
+	m.predictionStats = make([]PredictionStats, m.ScoreBandCount())
+}
@@ -0,0 +1,52 @@
+package matcher_test
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+
+	"github.com/ganlvtech/go-game-matching/matcher"
+)
+
+func TestMatcher_PredictionStats(t *testing.T) {
+	m := matcher.NewMatcher(180, 300, 10)
+	for i := 0; i < 5; i++ {
+		_ = m.JoinQueue(matcher.PlayerId(strconv.Itoa(i)), 1000, 150)
+	}
+	// 还没有人匹配成功时预计为最长时间
+	if e := m.GetWaitTimeEstimateByScore(150); e.Expected != 180 {
+		t.Fatalf("initial estimate %+v", e)
+	}
+	m.Match(1003, 5)
+	s := m.PredictionStats()[15]
+	if s.Count != 5 || s.TimedOut != 0 || s.Bias() != 3-180 || s.MAE() != 177 || s.WithinExpected() != 1 || s.WithinHigh() != 1 {
+		t.Fatalf("stats %+v", s)
+	}
+
+	// 快照中保存加入时的预计值，恢复后超时的玩家仍然会被统计
+	predicted := m.GetWaitTimeEstimateByScore(5)
+	_ = m.JoinQueue("timeout", 1010, 5)
+	var b bytes.Buffer
+	if err := m.WriteSnapshot(&b); err != nil {
+		t.Fatal(err)
+	}
+	m2 := matcher.NewMatcher(180, 300, 10)
+	if err := m2.ReadSnapshot(&b); err != nil {
+		t.Fatal(err)
+	}
+	m2.Match(1300, 5)
+	s = m2.PredictionStats()[0]
+	if s.Count != 1 || s.TimedOut != 1 || s.Bias() != 290-predicted.Expected {
+		t.Fatalf("stats %+v, predicted %+v", s, predicted)
+	}
+
+	// 恢复前的统计也在快照中
+	if s := m2.PredictionStats()[15]; s.Count != 5 || m2.TimedOutCount() != 1 {
+		t.Errorf("restored stats %+v, timed out %d", s, m2.TimedOutCount())
+	}
+
+	m2.ResetPredictionStats()
+	if m2.PredictionStats()[0].Count != 0 {
+		t.Error("stats not reset")
+	}
+}
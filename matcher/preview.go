@@ -0,0 +1,110 @@
+package matcher
+
+import (
+	"bytes"
+	"math"
+
+	json "github.com/json-iterator/go"
+)
+
+// 预览的参数，为零值的项使用匹配器当前的值
+type PreviewOptions struct {
+	MatchCount  int          // 每组人数，为 0 时使用最近一次 Match 的人数
+	RadiusCurve *RadiusCurve // 分数容忍区间曲线
+	// 副本使用的 PlayerScoreRadiusFunc。匹配器的 PlayerScoreRadiusFunc 可能有自己的状态（比如 AdaptiveScoreRadius），
+	// 副本不会使用它，需要时通过这里传入不会修改状态的版本，例如 AdaptiveScoreRadius.Frozen
+	PlayerScoreRadius func(c *Matcher) PlayerScoreRadiusFunc
+}
+
+type PreviewResult struct {
+	MatchCount             int
+	Groups                 []*Group // 新组成的小组，属于副本，玩家也是副本中的玩家
+	MatchedCount           int      // 新组成的小组中的人数
+	PlayerInQueueCount     int      // 匹配之后仍在匹配的人数
+	TimedOutCount          int      // 这次匹配超时的人数
+	AverageWaitTime        float64  // 新组成的小组中的玩家的平均等待时间
+	GroupStandardDeviation float64  // 新组成的小组分数标准差的平均值
+	MaxStandardDeviation   float64  // 新组成的小组中最大的分数标准差
+}
+
+// 通过快照复制出一个新的匹配器，ScoreRadiusFunc、Clock、MatchBudget、RequireAccept 和 AcceptTimeout 与原匹配器相同
+// PlayerScoreRadiusFunc、自适应分数容忍区间、回调函数和事件订阅者不会复制
+func (m *Matcher) Clone() (*Matcher, error) {
+	c := NewMatcherWithTimeUnit(m.maxTime, m.maxScore, m.timeScoreGrid.YGroupLen, m.timeUnit)
+	e, err := NewEstimator(m.estimator.Name(), c)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.SetEstimator(e); err != nil {
+		return nil, err
+	}
+	c.ScoreRadiusFunc = m.ScoreRadiusFunc
+	c.Clock = m.Clock
+	c.MatchBudget = m.MatchBudget
+	c.RequireAccept = m.RequireAccept
+	c.AcceptTimeout = m.AcceptTimeout
+	// 副本不使用自适应系数，快照中去掉这部分
+	s, err := m.newSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	s.AdaptiveRadius = nil
+	buf := &bytes.Buffer{}
+	if err := json.NewEncoder(buf).Encode(s); err != nil {
+		return nil, err
+	}
+	if err := c.ReadSnapshot(buf); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// 在副本上执行一次完整的 Match，不受 MatchBudget 限制，返回会组成的小组，匹配器本身不会改变，也不会发布事件和调用回调函数
+func (m *Matcher) Preview(currentTime Time, opts PreviewOptions) (*PreviewResult, error) {
+	c, err := m.Clone()
+	if err != nil {
+		return nil, err
+	}
+	if opts.RadiusCurve != nil {
+		if err := c.SetRadiusCurve(opts.RadiusCurve); err != nil {
+			return nil, err
+		}
+	}
+	if opts.PlayerScoreRadius != nil {
+		c.PlayerScoreRadiusFunc = opts.PlayerScoreRadius(c)
+	}
+	count := opts.MatchCount
+	if count <= 0 {
+		count = m.matchCount
+	}
+	r := &PreviewResult{MatchCount: count}
+	if count <= 0 {
+		r.PlayerInQueueCount = c.PlayerInQueueCount()
+		return r, nil
+	}
+	lastGroupId := c.nextGroupId
+	timedOut := c.TimedOutCount()
+	c.MatchWithBudget(currentTime, count, MatchBudget{})
+	for _, g := range c.groups {
+		if g.Id > lastGroupId {
+			r.Groups = append(r.Groups, g)
+		}
+	}
+	r.PlayerInQueueCount = c.PlayerInQueueCount()
+	r.TimedOutCount = c.TimedOutCount() - timedOut
+	waitSum := 0.0
+	for _, g := range r.Groups {
+		waitSum += g.AverageWaitTime(currentTime) * float64(len(g.Players))
+		sd := g.StandardDeviation()
+		r.GroupStandardDeviation += sd
+		r.MaxStandardDeviation = math.Max(r.MaxStandardDeviation, sd)
+		r.MatchedCount += len(g.Players)
+	}
+	if r.MatchedCount > 0 {
+		r.AverageWaitTime = waitSum / float64(r.MatchedCount)
+	}
+	if len(r.Groups) > 0 {
+		r.GroupStandardDeviation /= float64(len(r.Groups))
+	}
+	return r, nil
+}
@@ -0,0 +1,162 @@
+package matcher
+
+import (
+	"math"
+	"sort"
+
+	json "github.com/json-iterator/go"
+)
+
+// 各分段最近 WindowSize 个匹配成功的玩家等待时间的分位数，Expected 和 Low 为 p50，High 为 p90
+type QuantileEstimator struct {
+	groupCount     int
+	WindowSize     int
+	MaxWaitTime    float64
+	windows        [][]float64 // 环形缓冲区
+	nexts          []int       // 下一个写入的位置
+	dirty          []bool      // 窗口有变化，需要重新计算分位数
+	p50s           []float64
+	p90s           []float64
+	lastMatchTimes []float64 // 分段最近一次有人匹配成功的时间，-1 表示没有
+	lastTime       float64
+	estimates      []WaitTimeEstimate
+	groups         []float64
+}
+
+type quantileEstimatorState struct {
+	WindowSize     int         `json:"window_size"`
+	Windows        [][]float64 `json:"windows"` // 按匹配成功的先后顺序
+	LastMatchTimes []float64   `json:"last_match_times"`
+	LastTime       float64     `json:"last_time"`
+}
+
+func NewQuantileEstimator(count int, maxWaitTime float64, windowSize int) *QuantileEstimator {
+	if windowSize < 1 {
+		windowSize = 1
+	}
+	q := &QuantileEstimator{
+		groupCount:     count,
+		WindowSize:     windowSize,
+		MaxWaitTime:    maxWaitTime,
+		windows:        make([][]float64, count),
+		nexts:          make([]int, count),
+		dirty:          make([]bool, count),
+		p50s:           make([]float64, count),
+		p90s:           make([]float64, count),
+		lastMatchTimes: make([]float64, count),
+		lastTime:       -1,
+		estimates:      make([]WaitTimeEstimate, count),
+		groups:         make([]float64, count),
+	}
+	for i := range q.windows {
+		q.windows[i] = make([]float64, 0, windowSize)
+		q.lastMatchTimes[i] = -1
+	}
+	q.Merge()
+	return q
+}
+
+func (q *QuantileEstimator) Name() string {
+	return EstimatorQuantile
+}
+
+func (q *QuantileEstimator) GroupCount() int {
+	return q.groupCount
+}
+
+func (q *QuantileEstimator) AddTimeAuto(t float64) {
+	q.lastTime = t
+}
+
+func (q *QuantileEstimator) AddItem(groupIndex int, t float64) {
+	w := q.windows[groupIndex]
+	if len(w) < q.WindowSize {
+		q.windows[groupIndex] = append(w, t)
+	} else {
+		w[q.nexts[groupIndex]] = t
+		q.nexts[groupIndex] = (q.nexts[groupIndex] + 1) % q.WindowSize
+	}
+	q.dirty[groupIndex] = true
+	q.lastMatchTimes[groupIndex] = q.lastTime
+}
+
+func (q *QuantileEstimator) Merge() {
+	for i := range q.estimates {
+		if q.dirty[i] {
+			sorted := append([]float64(nil), q.windows[i]...)
+			sort.Float64s(sorted)
+			q.p50s[i] = nearestRank(sorted, 0.5)
+			q.p90s[i] = nearestRank(sorted, 0.9)
+			q.dirty[i] = false
+		}
+		est := WaitTimeEstimate{Expected: q.MaxWaitTime, Low: q.MaxWaitTime, High: q.MaxWaitTime}
+		if len(q.windows[i]) > 0 {
+			idle := idleWaitTime(q.lastTime, q.lastMatchTimes[i])
+			est.Expected = math.Max(q.p50s[i], idle)
+			est.Low = est.Expected
+			est.High = math.Max(q.p90s[i], idle)
+		}
+		q.estimates[i] = clampWaitTimeEstimate(est, q.MaxWaitTime)
+		q.groups[i] = q.estimates[i].Expected
+	}
+}
+
+func nearestRank(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	k := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if k < 0 {
+		k = 0
+	}
+	return sorted[k]
+}
+
+func (q *QuantileEstimator) Estimate(groupIndex int) WaitTimeEstimate {
+	return q.estimates[groupIndex]
+}
+
+func (q *QuantileEstimator) GroupWaitTimes() []float64 {
+	return q.groups
+}
+
+func (q *QuantileEstimator) MarshalState() ([]byte, error) {
+	s := &quantileEstimatorState{
+		WindowSize:     q.WindowSize,
+		Windows:        make([][]float64, q.groupCount),
+		LastMatchTimes: q.lastMatchTimes,
+		LastTime:       q.lastTime,
+	}
+	for i, w := range q.windows {
+		s.Windows[i] = append(append(make([]float64, 0, len(w)), w[q.nexts[i]:]...), w[:q.nexts[i]]...)
+	}
+	return json.Marshal(s)
+}
+
+func (q *QuantileEstimator) UnmarshalState(data []byte) error {
+	s := &quantileEstimatorState{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return InvalidSnapshotError(err.Error())
+	}
+	if len(s.Windows) != q.groupCount || len(s.LastMatchTimes) != q.groupCount {
+		return InvalidSnapshotError("score group count mismatch")
+	}
+	if s.WindowSize < 1 {
+		return InvalidSnapshotError("invalid quantile window size")
+	}
+	for _, w := range s.Windows {
+		if len(w) > s.WindowSize {
+			return InvalidSnapshotError("quantile window too large")
+		}
+	}
+	q.WindowSize = s.WindowSize
+	for i, w := range s.Windows {
+		q.windows[i] = append(make([]float64, 0, s.WindowSize), w...)
+		q.nexts[i] = 0
+		q.dirty[i] = true
+	}
+	q.lastMatchTimes = s.LastMatchTimes
+	q.lastTime = s.LastTime
+	q.Merge()
+	return nil
+}
@@ -0,0 +1,224 @@
+package matcher
+
+import (
+	"math"
+	"strings"
+
+	json "github.com/json-iterator/go"
+)
+
+// 包装其他算法时名称的前缀，例如 queue+ewma
+const EstimatorQueuePrefix = "queue+"
+
+// 根据队列中的人数和加入速度估计等待时间
+//
+// 学习型的算法只能从匹配成功的玩家中学习，安静一段时间之后所有分段都会变成最长等待时间，即使分段中已经有很多人在等。
+// 这里假设一个玩家刚加入分段的中间，分数容忍区间按 ScoreRadiusFunc 随等待时间扩大，
+// 区间内的人数为当前仍在匹配的人数加上这段时间内预计加入的人数，求区间内的人数加上自己达到每组人数所需的时间。
+// Expected 和 Low 取这个时间与内部算法中较小的一个，High 按一半的加入速度计算，同样不超过内部算法的 High。
+// 没有考虑排在前面的玩家先匹配走，所以结果偏乐观，由内部算法的历史数据兜底
+type QueueEstimator struct {
+	matcher         *Matcher
+	Base            Estimator
+	MatchCount      int     // 每组人数，0 表示使用最近一次 Match 的人数
+	ArrivalHalfLife float64 // 各分段加入速度的半衰期
+	MaxWaitTime     float64
+	arrivalRates    []float64 // 各分段每单位时间加入的人数
+	lastJoinCounts  []int
+	lastTime        float64 // 上一次更新加入速度的时间，-1 表示没有
+	estimates       []WaitTimeEstimate
+	groups          []float64
+}
+
+type queueEstimatorState struct {
+	Base            json.RawMessage    `json:"base"`
+	MatchCount      int                `json:"match_count"`
+	ArrivalHalfLife float64            `json:"arrival_half_life"`
+	ArrivalRates    []float64          `json:"arrival_rates"`
+	LastJoinCounts  []int              `json:"last_join_counts"`
+	LastTime        float64            `json:"last_time"`
+	Estimates       []WaitTimeEstimate `json:"estimates"` // 队列中的玩家不一定已经恢复，直接保存结果
+}
+
+func NewQueueEstimator(m *Matcher, base Estimator, arrivalHalfLife float64) *QueueEstimator {
+	count := base.GroupCount()
+	e := &QueueEstimator{
+		matcher:         m,
+		Base:            base,
+		ArrivalHalfLife: arrivalHalfLife,
+		MaxWaitTime:     float64(m.MaxTime()),
+		arrivalRates:    make([]float64, count),
+		lastJoinCounts:  make([]int, count),
+		lastTime:        -1,
+		estimates:       make([]WaitTimeEstimate, count),
+		groups:          make([]float64, count),
+	}
+	e.Merge()
+	return e
+}
+
+func (e *QueueEstimator) Name() string {
+	return EstimatorQueuePrefix + e.Base.Name()
+}
+
+func (e *QueueEstimator) GroupCount() int {
+	return e.Base.GroupCount()
+}
+
+func (e *QueueEstimator) AddTimeAuto(t float64) {
+	e.Base.AddTimeAuto(t)
+	if e.lastTime >= 0 && t <= e.lastTime {
+		// 同一时间多次 Match，加入人数留到下一次一起计算
+		return
+	}
+	alpha := 1.0
+	if e.lastTime >= 0 && e.ArrivalHalfLife > 0 {
+		alpha = 1 - math.Pow(0.5, (t-e.lastTime)/e.ArrivalHalfLife)
+	}
+	for i := range e.arrivalRates {
+		joinCount := e.matcher.BandJoinCount(i)
+		if e.lastTime >= 0 {
+			rate := float64(joinCount-e.lastJoinCounts[i]) / (t - e.lastTime)
+			e.arrivalRates[i] += alpha * (rate - e.arrivalRates[i])
+		}
+		e.lastJoinCounts[i] = joinCount
+	}
+	e.lastTime = t
+}
+
+func (e *QueueEstimator) AddItem(groupIndex int, t float64) {
+	e.Base.AddItem(groupIndex, t)
+}
+
+func (e *QueueEstimator) Merge() {
+	e.Base.Merge()
+	count := e.MatchCount
+	if count <= 0 {
+		count = e.matcher.matchCount
+	}
+	occupancy := make([]int, len(e.estimates))
+	for i := range occupancy {
+		occupancy[i] = e.matcher.BandPlayerInQueueCount(i)
+	}
+	for i := range e.estimates {
+		est := e.Base.Estimate(i)
+		if count > 0 {
+			eta := e.eta(i, count, occupancy, 1)
+			slow := e.eta(i, count, occupancy, 0.5)
+			est.Expected = math.Min(est.Expected, eta)
+			est.Low = math.Min(est.Low, eta)
+			est.High = math.Max(est.Expected, math.Min(est.High, slow))
+		}
+		e.estimates[i] = clampWaitTimeEstimate(est, e.MaxWaitTime)
+		e.groups[i] = e.estimates[i].Expected
+	}
+}
+
+// 分段中间的玩家等待多久之后分数容忍区间内有足够的人，区间内的人数随时间单调增加，二分查找
+func (e *QueueEstimator) eta(band int, count int, occupancy []int, rateFactor float64) float64 {
+	need := float64(count - 1)
+	center := (float64(band) + 0.5) * float64(e.matcher.ScoreGroupLen())
+	if e.supply(center, 0, occupancy, rateFactor) >= need {
+		return 0
+	}
+	hi := Time(e.MaxWaitTime)
+	if e.supply(center, hi, occupancy, rateFactor) < need {
+		return e.MaxWaitTime
+	}
+	lo := Time(0)
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		if e.supply(center, mid, occupancy, rateFactor) >= need {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+	return float64(hi)
+}
+
+// 等待 t 之后分数容忍区间内的人数，分段内的分数按均匀分布计算
+func (e *QueueEstimator) supply(center float64, t Time, occupancy []int, rateFactor float64) float64 {
+	radius := float64(e.matcher.ScoreRadiusFunc(t))
+	groupLen := float64(e.matcher.ScoreGroupLen())
+	lo, hi := center-radius, center+radius
+	first := int(math.Floor(lo / groupLen))
+	if first < 0 {
+		first = 0
+	}
+	last := int(math.Floor(hi / groupLen))
+	if last >= len(occupancy) {
+		last = len(occupancy) - 1
+	}
+	sum := 0.0
+	for j := first; j <= last; j++ {
+		overlap := (math.Min(hi, float64(j+1)*groupLen) - math.Max(lo, float64(j)*groupLen)) / groupLen
+		if overlap <= 0 {
+			continue
+		}
+		sum += overlap * (float64(occupancy[j]) + e.arrivalRates[j]*rateFactor*float64(t))
+	}
+	return sum
+}
+
+func (e *QueueEstimator) Estimate(groupIndex int) WaitTimeEstimate {
+	return e.estimates[groupIndex]
+}
+
+func (e *QueueEstimator) GroupWaitTimes() []float64 {
+	return e.groups
+}
+
+// 各分段加入速度，每单位时间的人数
+func (e *QueueEstimator) ArrivalRates() []float64 {
+	return e.arrivalRates
+}
+
+func (e *QueueEstimator) MarshalState() ([]byte, error) {
+	base, err := e.Base.MarshalState()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&queueEstimatorState{
+		Base:            base,
+		MatchCount:      e.MatchCount,
+		ArrivalHalfLife: e.ArrivalHalfLife,
+		ArrivalRates:    e.arrivalRates,
+		LastJoinCounts:  e.lastJoinCounts,
+		LastTime:        e.lastTime,
+		Estimates:       e.estimates,
+	})
+}
+
+func (e *QueueEstimator) UnmarshalState(data []byte) error {
+	s := &queueEstimatorState{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return InvalidSnapshotError(err.Error())
+	}
+	count := e.GroupCount()
+	if len(s.ArrivalRates) != count || len(s.LastJoinCounts) != count || len(s.Estimates) != count {
+		return InvalidSnapshotError("score group count mismatch")
+	}
+	if err := e.Base.UnmarshalState(s.Base); err != nil {
+		return err
+	}
+	e.MatchCount = s.MatchCount
+	e.ArrivalHalfLife = s.ArrivalHalfLife
+	e.arrivalRates = s.ArrivalRates
+	e.lastJoinCounts = s.LastJoinCounts
+	e.lastTime = s.LastTime
+	e.estimates = s.Estimates
+	for i := range e.estimates {
+		e.groups[i] = e.estimates[i].Expected
+	}
+	return nil
+}
+
+// 名称为 queue+ 加上内部算法的名称
+func newQueueEstimatorByName(name string, m *Matcher) (Estimator, error) {
+	base, err := NewEstimator(strings.TrimPrefix(name, EstimatorQueuePrefix), m)
+	if err != nil {
+		return nil, UnknownEstimatorError(name)
+	}
+	return NewQueueEstimator(m, base, float64(m.MaxTime())/3), nil
+}
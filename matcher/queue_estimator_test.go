@@ -0,0 +1,65 @@
+package matcher_test
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/ganlvtech/go-game-matching/matcher"
+)
+
+func TestQueueEstimator_Occupancy(t *testing.T) {
+	m := matcher.NewMatcher(180, 300, 10)
+	e, err := matcher.NewEstimator(matcher.EstimatorQueuePrefix+matcher.EstimatorEWMA, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = m.SetEstimator(e)
+
+	// 很久没有人匹配成功，但分段 15 已经有 24 人在等
+	for i := 0; i < 24; i++ {
+		_ = m.JoinQueue(matcher.PlayerId(strconv.Itoa(i)), 1000, matcher.PlayerScore(150+i%10))
+	}
+	m.Match(1000, 25)
+	base := e.(*matcher.QueueEstimator).Base
+	if got := base.Estimate(15); got.Expected != 180 {
+		t.Fatalf("base estimate %+v", got)
+	}
+	crowded := m.GetWaitTimeEstimateByScore(155)
+	if crowded.Expected > 10 || crowded.High > 180 || crowded.Low > crowded.Expected || crowded.High < crowded.Expected {
+		t.Errorf("crowded band estimate %+v", crowded)
+	}
+	if got := m.GetWaitTimeEstimateByScore(5); got.Expected <= crowded.Expected {
+		t.Errorf("empty band estimate %+v, crowded band %+v", got, crowded)
+	}
+}
+
+func TestQueueEstimator_ArrivalRate(t *testing.T) {
+	m := matcher.NewMatcher(180, 300, 10)
+	e := matcher.NewQueueEstimator(m, matcher.NewWaitTime(m.ScoreBandCount(), 180), 60)
+	_ = m.SetEstimator(e)
+
+	// 每秒 1 人加入分段 10，每组 100 人时一直凑不齐
+	id := 0
+	for now := matcher.Time(1000); now < 1060; now++ {
+		_ = m.JoinQueue(matcher.PlayerId(strconv.Itoa(id)), now, 105)
+		id++
+		m.Match(now, 100)
+	}
+	if rate := e.ArrivalRates()[10]; rate < 0.3 || rate > 1 {
+		t.Errorf("arrival rate %v", rate)
+	}
+	if rate := e.ArrivalRates()[0]; rate != 0 {
+		t.Errorf("arrival rate of empty band %v", rate)
+	}
+	got := e.Estimate(10)
+	if got.Expected <= 0 || got.Expected >= 180 || got.High < got.Expected {
+		t.Errorf("estimate %+v", got)
+	}
+}
+
+func TestNewEstimator_Unknown(t *testing.T) {
+	m := matcher.NewMatcher(180, 300, 10)
+	if _, err := matcher.NewEstimator(matcher.EstimatorQueuePrefix+"foo", m); err == nil {
+		t.Error("unknown base estimator accepted")
+	}
+}
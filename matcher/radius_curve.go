@@ -0,0 +1,176 @@
+package matcher
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	json "github.com/json-iterator/go"
+)
+
+const (
+	RadiusCurveTypeLinear      = "linear"
+	RadiusCurveTypeExponential = "exponential"
+	RadiusCurveTypeStep        = "step"
+	RadiusCurveTypePiecewise   = "piecewise"
+)
+
+// 声明式的分数容忍区间曲线，可以从配置文件或命令行参数加载，解析为 ScoreRadiusFunc
+//
+// linear:      base + rate * t
+// exponential: base * e^(rate * t)
+// step:        取 time <= t 的最后一个台阶的 radius，没有则为 base
+// piecewise:   依次使用各段曲线，每段的 t 从该段开始时重新计算，until 为 0 的段一直持续
+//
+// 分段曲线的每段不会小于上一段结束时的值，超过最后一段的 until 之后保持结束时的值
+//
+// 分数容忍区间不会随等待时间缩小，所以 rate 不能为负数，台阶的 radius 不能递减
+//
+// 所有曲线的结果都会限制在 [min, max] 之间，max 为 0 表示不限制
+type RadiusCurve struct {
+	Type   string             `json:"type"`
+	Base   float64            `json:"base,omitempty"`
+	Rate   float64            `json:"rate,omitempty"`
+	Steps  []RadiusCurveStep  `json:"steps,omitempty"`
+	Pieces []RadiusCurvePiece `json:"pieces,omitempty"`
+	Min    float64            `json:"min,omitempty"`
+	Max    float64            `json:"max,omitempty"`
+}
+
+type RadiusCurveStep struct {
+	Time   float64 `json:"time"`
+	Radius float64 `json:"radius"`
+}
+
+type RadiusCurvePiece struct {
+	Until float64      `json:"until"`
+	Curve *RadiusCurve `json:"curve"`
+}
+
+type InvalidRadiusCurveError string
+
+func (e InvalidRadiusCurveError) Error() string {
+	return "invalid radius curve. " + string(e)
+}
+
+func ParseRadiusCurve(s string) (*RadiusCurve, error) {
+	c := &RadiusCurve{}
+	if err := json.UnmarshalFromString(s, c); err != nil {
+		return nil, InvalidRadiusCurveError(err.Error())
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *RadiusCurve) String() string {
+	s, _ := json.MarshalToString(c)
+	return s
+}
+
+func (c *RadiusCurve) Validate() error {
+	if c.Min < 0 || c.Max < 0 || (c.Max > 0 && c.Min > c.Max) {
+		return InvalidRadiusCurveError("min and max out of range")
+	}
+	switch c.Type {
+	case RadiusCurveTypeLinear, RadiusCurveTypeExponential:
+		if c.Base < 0 {
+			return InvalidRadiusCurveError("base must not be negative")
+		}
+		if c.Rate < 0 {
+			return InvalidRadiusCurveError("rate must not be negative")
+		}
+	case RadiusCurveTypeStep:
+		if len(c.Steps) == 0 {
+			return InvalidRadiusCurveError("step curve without steps")
+		}
+		if !sort.SliceIsSorted(c.Steps, func(i, j int) bool { return c.Steps[i].Time < c.Steps[j].Time }) {
+			return InvalidRadiusCurveError("steps must be sorted by time")
+		}
+		last := c.Base
+		for _, step := range c.Steps {
+			if step.Radius < last {
+				return InvalidRadiusCurveError("step radius must not decrease")
+			}
+			last = step.Radius
+		}
+	case RadiusCurveTypePiecewise:
+		if len(c.Pieces) == 0 {
+			return InvalidRadiusCurveError("piecewise curve without pieces")
+		}
+		for i, piece := range c.Pieces {
+			if piece.Curve == nil {
+				return InvalidRadiusCurveError("piece " + strconv.Itoa(i) + " without curve")
+			}
+			if piece.Until <= 0 && i != len(c.Pieces)-1 {
+				return InvalidRadiusCurveError("only the last piece can be unbounded")
+			}
+			if i > 0 && piece.Until > 0 && piece.Until <= c.Pieces[i-1].Until {
+				return InvalidRadiusCurveError("pieces must be sorted by until")
+			}
+			if err := piece.Curve.Validate(); err != nil {
+				return err
+			}
+		}
+	default:
+		return InvalidRadiusCurveError("unknown type " + strconv.Quote(c.Type))
+	}
+	return nil
+}
+
+// t 为已等待的秒数
+func (c *RadiusCurve) Radius(t float64) float64 {
+	r := c.Base
+	switch c.Type {
+	case RadiusCurveTypeLinear:
+		r = c.Base + c.Rate*t
+	case RadiusCurveTypeExponential:
+		r = c.Base * math.Exp(c.Rate*t)
+	case RadiusCurveTypeStep:
+		for _, step := range c.Steps {
+			if step.Time > t {
+				break
+			}
+			r = step.Radius
+		}
+	case RadiusCurveTypePiecewise:
+		start := float64(0)
+		end := float64(0) // 上一段结束时的值
+		for _, piece := range c.Pieces {
+			if piece.Until <= 0 || t < piece.Until {
+				r = math.Max(end, piece.Curve.Radius(t-start))
+				break
+			}
+			end = math.Max(end, piece.Curve.Radius(piece.Until-start))
+			start = piece.Until
+			r = end
+		}
+	}
+	if r < c.Min {
+		r = c.Min
+	}
+	if c.Max > 0 && r > c.Max {
+		r = c.Max
+	}
+	return r
+}
+
+func (c *RadiusCurve) ScoreRadiusFunc(maxScore PlayerScore) ScoreRadiusFunc {
+	return c.ScoreRadiusFuncWithTimeUnit(maxScore, time.Second)
+}
+
+// 曲线中的时间总是秒，deltaT 以 unit 为单位
+func (c *RadiusCurve) ScoreRadiusFuncWithTimeUnit(maxScore PlayerScore, unit time.Duration) ScoreRadiusFunc {
+	seconds := unit.Seconds()
+	return func(deltaT Time) PlayerScore {
+		r := c.Radius(float64(deltaT) * seconds)
+		if r < 0 {
+			r = 0
+		} else if r > float64(maxScore) {
+			return maxScore
+		}
+		return PlayerScore(r)
+	}
+}
@@ -0,0 +1,96 @@
+package matcher_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ganlvtech/go-game-matching/matcher"
+)
+
+func TestParseRadiusCurve(t *testing.T) {
+	c, err := matcher.ParseRadiusCurve(`{"type":"piecewise","max":100,"pieces":[
+		{"until":10,"curve":{"type":"step","base":5,"steps":[{"time":5,"radius":10}]}},
+		{"until":20,"curve":{"type":"linear","base":20,"rate":2}},
+		{"until":0,"curve":{"type":"exponential","base":40,"rate":1}}
+	]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := c.ScoreRadiusFunc(300)
+	cases := []struct {
+		deltaT matcher.Time
+		radius matcher.PlayerScore
+	}{
+		{0, 5},
+		{5, 10},
+		{10, 20},
+		{15, 30},
+		{20, 40},
+		{60, 100},
+	}
+	for _, tc := range cases {
+		if r := f(tc.deltaT); r != tc.radius {
+			t.Errorf("radius(%d) = %d, want %d", tc.deltaT, r, tc.radius)
+		}
+	}
+}
+
+func TestParseRadiusCurve_Invalid(t *testing.T) {
+	for _, s := range []string{
+		`{"type":"quadratic"}`,
+		`{"type":"step"}`,
+		`{"type":"step","steps":[{"time":5,"radius":10},{"time":1,"radius":5}]}`,
+		`{"type":"piecewise","pieces":[{"until":0,"curve":{"type":"linear"}},{"until":10,"curve":{"type":"linear"}}]}`,
+		`{"type":"linear","min":10,"max":5}`,
+		`{"type":"linear","base":10,"rate":-1}`,
+		`{"type":"step","base":10,"steps":[{"time":5,"radius":5}]}`,
+		`not json`,
+	} {
+		if _, err := matcher.ParseRadiusCurve(s); err == nil {
+			t.Errorf("ParseRadiusCurve(%s) should fail", s)
+		}
+	}
+}
+
+func TestRadiusCurve_PiecewiseMonotonic(t *testing.T) {
+	c, err := matcher.ParseRadiusCurve(`{"type":"piecewise","pieces":[
+		{"until":5,"curve":{"type":"linear","base":10,"rate":4}},
+		{"until":10,"curve":{"type":"linear","base":20,"rate":2}}
+	]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		t      float64
+		radius float64
+	}{
+		{0, 10},
+		{4.9, 29.6},
+		{5, 30}, // 第二段从 20 开始，保持第一段结束时的 30
+		{9, 30},
+		{10, 30},
+		{100, 30},
+	}
+	for _, tc := range cases {
+		if r := c.Radius(tc.t); math.Abs(r-tc.radius) > 1e-9 {
+			t.Errorf("radius(%v) = %v, want %v", tc.t, r, tc.radius)
+		}
+	}
+
+	c, _ = matcher.ParseRadiusCurve(`{"type":"piecewise","pieces":[
+		{"until":10,"curve":{"type":"step","base":5,"steps":[{"time":5,"radius":10}]}},
+		{"until":20,"curve":{"type":"linear","base":5,"rate":3}},
+		{"until":30,"curve":{"type":"exponential","base":1,"rate":0.5}}
+	]}`)
+	last := 0.0
+	for ti := 0; ti <= 400; ti++ {
+		r := c.Radius(float64(ti) / 10)
+		if r < last {
+			t.Fatalf("radius(%v) = %v, smaller than %v", float64(ti)/10, r, last)
+		}
+		last = r
+	}
+	if last != math.Exp(5) {
+		t.Errorf("radius after the last piece = %v", last)
+	}
+}
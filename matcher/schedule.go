@@ -0,0 +1,113 @@
+package matcher
+
+import (
+	"math"
+	"sort"
+
+	"github.com/wangjia184/sortedset"
+)
+
+// 分数容忍区间扩大到新的分段时的等待时间，按从小到大排列
+// 等待时间达到这些值时，玩家能搜索到的单元变多，再匹配一次才可能有新的结果
+// 假设分数容忍区间随等待时间单调不减，每个分段边界二分查找，时间单位很小时也不需要逐个时间点计算
+func (m *Matcher) radiusCrossings(radius ScoreRadiusFunc) []Time {
+	crossings := make([]Time, 0)
+	h := m.timeScoreGrid
+	cells := func(dt Time) int {
+		return int(radius(dt)) / h.YGroupLen
+	}
+	end := Time(h.XLen())
+	dt := Time(0)
+	for j := cells(0) + 1; j <= h.YCount; {
+		// 找到最小的 dt 使得 cells(dt) >= j
+		lo, hi := dt+1, end
+		for lo < hi {
+			mid := (lo + hi) / 2
+			if cells(mid) >= j {
+				hi = mid
+			} else {
+				lo = mid + 1
+			}
+		}
+		if lo >= end {
+			break
+		}
+		dt = lo
+		crossings = append(crossings, dt)
+		j = cells(dt) + 1
+	}
+	return crossings
+}
+
+// 队列中的玩家在 after 之后最早一次分数容忍区间扩大到新分段的时间
+// 启用自适应分数容忍区间时按各分段当前的系数计算，系数在下一次 Match 时才会改变
+// 使用其他 PlayerScoreRadiusFunc 时无法预先计算，返回 false；队列为空或之后不会再扩大时也返回 false
+func (m *Matcher) NextRadiusCrossing(after Time) (Time, bool) {
+	if m.playerQueue.GetCount() == 0 {
+		return 0, false
+	}
+	if m.adaptiveRadius != nil {
+		return m.nextAdaptiveRadiusCrossing(after)
+	}
+	if m.PlayerScoreRadiusFunc != nil {
+		return 0, false
+	}
+	next := Time(0)
+	ok := false
+	for _, dt := range m.radiusCrossings(m.ScoreRadiusFunc) {
+		// 加入时间晚于 after - dt 的玩家中最早加入的那个，最先在 after 之后到达这个等待时间
+		nodes := m.playerQueue.GetByScoreRange(sortedset.SCORE(after-dt), sortedset.SCORE(math.MaxInt64), &sortedset.GetByScoreRangeOptions{
+			Limit:        1,
+			ExcludeStart: true,
+		})
+		if len(nodes) == 0 {
+			continue
+		}
+		t := Time(nodes[0].Score()) + dt
+		if !ok || t < next {
+			next = t
+			ok = true
+		}
+	}
+	return next, ok
+}
+
+// 各分段的系数不同，扩大的时间也不同，逐个玩家在所在分段的时间中查找
+// 系数相同的分段共用一次计算，系数被限制在上下限时很多分段是相同的
+func (m *Matcher) nextAdaptiveRadiusCrossing(after Time) (Time, bool) {
+	a := m.adaptiveRadius
+	bandCrossings := make(map[int][]Time)
+	factorCrossings := make(map[float64][]Time)
+	next := Time(0)
+	ok := false
+	for _, node := range m.playerQueue.GetByRankRange(1, -1, false) {
+		p := node.Value.(*Player)
+		band := m.ScoreBandIndex(p.Score)
+		crossings, found := bandCrossings[band]
+		if !found {
+			f := a.factor(band)
+			if crossings, found = factorCrossings[f]; !found {
+				crossings = m.radiusCrossings(func(dt Time) PlayerScore {
+					return a.scaledRadius(m, dt, f)
+				})
+				factorCrossings[f] = crossings
+			}
+			bandCrossings[band] = crossings
+		}
+		i := sort.Search(len(crossings), func(i int) bool {
+			return p.JoinTime+crossings[i] > after
+		})
+		if i < len(crossings) && (!ok || p.JoinTime+crossings[i] < next) {
+			next = p.JoinTime + crossings[i]
+			ok = true
+		}
+	}
+	return next, ok
+}
+
+// 各分段累计加入人数
+func (m *Matcher) BandJoinCounts() []int {
+	counts := make([]int, len(m.bandJoinCounts))
+	copy(counts, m.bandJoinCounts)
+	return counts
+}
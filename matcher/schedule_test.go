@@ -0,0 +1,78 @@
+package matcher_test
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/ganlvtech/go-game-matching/matcher"
+)
+
+func TestMatcher_NextRadiusCrossing(t *testing.T) {
+	m := matcher.NewMatcher(180, 300, 10)
+	if _, ok := m.NextRadiusCrossing(1000); ok {
+		t.Error("empty queue should have no crossing")
+	}
+	// 每 5 秒扩大 10 分，正好一个分段
+	m.ScoreRadiusFunc = func(deltaT matcher.Time) matcher.PlayerScore {
+		return matcher.PlayerScore(deltaT / 5 * 10)
+	}
+	_ = m.JoinQueue("a", 1000, 100)
+	_ = m.JoinQueue("b", 1003, 100)
+	for _, c := range []struct {
+		after matcher.Time
+		want  matcher.Time
+	}{
+		{1000, 1005},
+		{1005, 1008},
+		{1008, 1010},
+		{1009, 1010},
+	} {
+		if next, ok := m.NextRadiusCrossing(c.after); !ok || next != c.want {
+			t.Errorf("NextRadiusCrossing(%d) = %d, %v, want %d", c.after, next, ok, c.want)
+		}
+	}
+
+	m.PlayerScoreRadiusFunc = func(p *matcher.Player, currentTime matcher.Time) matcher.PlayerScore {
+		return 10
+	}
+	if _, ok := m.NextRadiusCrossing(1000); ok {
+		t.Error("crossing with PlayerScoreRadiusFunc should be unknown")
+	}
+}
+
+func TestMatcher_NextRadiusCrossingAdaptive(t *testing.T) {
+	m := matcher.NewMatcher(180, 300, 10)
+	m.ScoreRadiusFunc = func(deltaT matcher.Time) matcher.PlayerScore {
+		return matcher.PlayerScore(deltaT / 5 * 10)
+	}
+	a := matcher.NewAdaptiveScoreRadius(m, 4, 30)
+	m.SetAdaptiveScoreRadius(a)
+	// 分段 10 人多，系数收窄；分段 25 只有一个人，系数放宽
+	for i := 0; i < 16; i++ {
+		_ = m.JoinQueue(matcher.PlayerId(strconv.Itoa(i)), 1000, 100)
+	}
+	_ = m.JoinQueue("lonely", 1002, 250)
+	m.Match(1003, 100)
+	if f := a.Stats(); f[10].Factor == f[25].Factor {
+		t.Fatalf("factors should differ: %+v %+v", f[10], f[25])
+	}
+
+	// 与逐个时间点检查每个玩家的区间覆盖的分段数比较
+	cells := func(p *matcher.Player, currentTime matcher.Time) int {
+		return int(m.PlayerScoreRadius(p, currentTime)) / m.ScoreGroupLen()
+	}
+	for after := matcher.Time(1003); after < 1100; after += 7 {
+		want, wantOk := matcher.Time(0), false
+		for now := after + 1; now < 1180 && !wantOk; now++ {
+			for _, p := range m.Players() {
+				if now > p.JoinTime && cells(p, now) > cells(p, now-1) {
+					want, wantOk = now, true
+					break
+				}
+			}
+		}
+		if next, ok := m.NextRadiusCrossing(after); ok != wantOk || next != want {
+			t.Errorf("NextRadiusCrossing(%d) = %d, %v, want %d, %v", after, next, ok, want, wantOk)
+		}
+	}
+}
@@ -0,0 +1,462 @@
+package matcher
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// 按分数范围分片的匹配器
+//
+// 分数轴被平均分为多个区间，每个区间由一个独立的 Matcher 负责，各自有自己的锁，Match 时各分片在自己的 goroutine 中并行匹配。
+// 各分片匹配完成后，由协调器处理相邻分片的边界：分数容忍区间跨过边界的玩家，从两个分片中一起选取候选玩家组成小组。
+// 跨分片的小组登记在发起匹配的玩家所在的分片中，删除、确认这类玩家时会按顺序锁住小组涉及的全部分片。
+//
+// 分片模式下不支持快照和操作日志。
+type ShardedMatcher struct {
+	shards   []*matcherShard
+	shardLen PlayerScore // 每个分片的分数范围，是 scoreGroupLen 的整数倍
+	ownersMu sync.Mutex
+	owners   map[PlayerId]int      // 玩家所在的分片，Sweep 时清理
+	joining  map[PlayerId]struct{} // 正在加入的玩家，避免同一个玩家同时加入两个分片
+	matchMu  sync.Mutex            // 保证 Match 和 Sweep 串行执行
+}
+
+type matcherShard struct {
+	mu      sync.Mutex
+	matcher *Matcher
+}
+
+func NewShardedMatcher(shardCount int, maxTime Time, maxScore PlayerScore, scoreGroupLen int) *ShardedMatcher {
+	return NewShardedMatcherWithTimeUnit(shardCount, maxTime, maxScore, scoreGroupLen, time.Second)
+}
+
+func NewShardedMatcherWithTimeUnit(shardCount int, maxTime Time, maxScore PlayerScore, scoreGroupLen int, unit time.Duration) *ShardedMatcher {
+	if shardCount < 1 {
+		shardCount = 1
+	}
+	s := &ShardedMatcher{
+		shards:  make([]*matcherShard, shardCount),
+		owners:  make(map[PlayerId]int),
+		joining: make(map[PlayerId]struct{}),
+	}
+	for i := range s.shards {
+		m := NewMatcherWithTimeUnit(maxTime, maxScore, scoreGroupLen, unit)
+		if m == nil {
+			return nil
+		}
+		// 各分片的小组 Id 使用不同的区间，避免重复
+		m.nextGroupId = GroupId(i) << 48
+		s.shards[i] = &matcherShard{matcher: m}
+	}
+	scoreGroupLen = s.shards[0].matcher.timeScoreGrid.YGroupLen
+	scoreGroupCount := s.shards[0].matcher.timeScoreGrid.YCount
+	s.shardLen = PlayerScore((scoreGroupCount + shardCount - 1) / shardCount * scoreGroupLen)
+	return s
+}
+
+func (s *ShardedMatcher) ShardCount() int {
+	return len(s.shards)
+}
+
+// 各分片使用相同的时间单位，不需要加锁
+func (s *ShardedMatcher) TimeUnit() time.Duration {
+	return s.shards[0].matcher.timeUnit
+}
+
+func (s *ShardedMatcher) SetClock(c Clock) {
+	s.Each(func(i int, m *Matcher) {
+		m.Clock = c
+	})
+}
+
+func (s *ShardedMatcher) TimeOf(t time.Time) Time {
+	return s.shards[0].matcher.TimeOf(t)
+}
+
+func (s *ShardedMatcher) shardIndex(score PlayerScore) int {
+	i := int(score / s.shardLen)
+	if i >= len(s.shards) {
+		i = len(s.shards) - 1
+	}
+	return i
+}
+
+// 依次锁住每个分片并执行 f，用于修改配置和统计
+func (s *ShardedMatcher) Each(f func(i int, m *Matcher)) {
+	for i, shard := range s.shards {
+		shard.mu.Lock()
+		f(i, shard.matcher)
+		shard.mu.Unlock()
+	}
+}
+
+func (s *ShardedMatcher) ownerOf(id PlayerId) (int, bool) {
+	s.ownersMu.Lock()
+	i, ok := s.owners[id]
+	s.ownersMu.Unlock()
+	return i, ok
+}
+
+func (s *ShardedMatcher) lockShards(indices []int) {
+	for _, i := range indices {
+		s.shards[i].mu.Lock()
+	}
+}
+
+func (s *ShardedMatcher) unlockShards(indices []int) {
+	for _, i := range indices {
+		s.shards[i].mu.Unlock()
+	}
+}
+
+// 锁住玩家所在的分片，如果玩家在跨分片的小组中，则按顺序锁住小组涉及的全部分片，返回需要解锁的分片
+func (s *ShardedMatcher) lockPlayer(id PlayerId) (*Matcher, []int, error) {
+	for {
+		i, ok := s.ownerOf(id)
+		if !ok {
+			return nil, nil, PlayerNotExistsError(id)
+		}
+		m := s.shards[i].matcher
+		s.shards[i].mu.Lock()
+		p, ok := m.players[id]
+		if !ok || p.Group == nil {
+			return m, []int{i}, nil
+		}
+		g := p.Group
+		indices := s.groupShards(g)
+		if len(indices) == 1 {
+			return m, indices, nil
+		}
+		s.shards[i].mu.Unlock()
+		s.lockShards(indices)
+		// 解锁期间小组可能被解散或者重新匹配，玩家也可能离开后加入其他分片，这些情况下重新加锁
+		// 小组解散后玩家回到队列时，锁住的分片仍然包含玩家所在的分片，不需要重试
+		if m.players[id] == p && (p.Group == nil || p.Group == g) {
+			return m, indices, nil
+		}
+		s.unlockShards(indices)
+	}
+}
+
+// 锁住的分片中除 m 以外的匹配器，用于解散跨分片的小组
+func (s *ShardedMatcher) otherMatchers(m *Matcher, indices []int) []*Matcher {
+	others := make([]*Matcher, 0, len(indices))
+	for _, i := range indices {
+		if s.shards[i].matcher != m {
+			others = append(others, s.shards[i].matcher)
+		}
+	}
+	return others
+}
+
+// 小组涉及的分片，从小到大排列
+func (s *ShardedMatcher) groupShards(g *Group) []int {
+	indices := make([]int, 0, 2)
+	for _, p := range g.Players {
+		i := s.shardIndex(p.Score)
+		found := false
+		for _, v := range indices {
+			if v == i {
+				found = true
+				break
+			}
+		}
+		if !found {
+			indices = append(indices, i)
+		}
+	}
+	sort.Ints(indices)
+	return indices
+}
+
+// ownersMu 只在读写 owners 和 joining 时短暂持有，等待分片的锁时不持有，避免加入请求在 Match 期间全部排队
+func (s *ShardedMatcher) JoinQueue(id PlayerId, joinTime Time, score PlayerScore) error {
+	target := s.shardIndex(score)
+	s.ownersMu.Lock()
+	if _, ok := s.joining[id]; ok {
+		s.ownersMu.Unlock()
+		return PlayerAlreadyExistsError(id)
+	}
+	i, ok := s.owners[id]
+	s.joining[id] = struct{}{}
+	s.ownersMu.Unlock()
+
+	err := s.joinShard(id, joinTime, score, target, i, ok)
+
+	s.ownersMu.Lock()
+	delete(s.joining, id)
+	if err == nil {
+		s.owners[id] = target
+	}
+	s.ownersMu.Unlock()
+	return err
+}
+
+// prev 为玩家之前所在的分片，hasPrev 为 false 时表示没有加入过
+func (s *ShardedMatcher) joinShard(id PlayerId, joinTime Time, score PlayerScore, target int, prev int, hasPrev bool) error {
+	if hasPrev && prev != target {
+		// 之前在其他分片中离开或超时的玩家，以新的分数重新加入
+		s.shards[prev].mu.Lock()
+		exists := s.shards[prev].matcher.Exists(id)
+		if !exists {
+			delete(s.shards[prev].matcher.finishedPlayers, id)
+		}
+		s.shards[prev].mu.Unlock()
+		if exists {
+			return PlayerAlreadyExistsError(id)
+		}
+	}
+	s.shards[target].mu.Lock()
+	defer s.shards[target].mu.Unlock()
+	return s.shards[target].matcher.JoinQueue(id, joinTime, score)
+}
+
+func (s *ShardedMatcher) LeaveQueue(id PlayerId) error {
+	m, indices, err := s.lockPlayer(id)
+	if err != nil {
+		return err
+	}
+	defer s.unlockShards(indices)
+	return m.leaveQueue(id, s.otherMatchers(m, indices)...)
+}
+
+func (s *ShardedMatcher) Remove(id PlayerId) {
+	m, indices, err := s.lockPlayer(id)
+	if err != nil {
+		return
+	}
+	defer s.unlockShards(indices)
+	m.remove(id, Event{Type: EventPlayerRemoved, Reason: RemoveReasonRemove}, s.otherMatchers(m, indices)...)
+}
+
+func (s *ShardedMatcher) Accept(id PlayerId) error {
+	m, indices, err := s.lockPlayer(id)
+	if err != nil {
+		return err
+	}
+	defer s.unlockShards(indices)
+	return m.Accept(id)
+}
+
+func (s *ShardedMatcher) GetMatchedPlayers(id PlayerId) ([]PlayerId, error) {
+	m, indices, err := s.lockPlayer(id)
+	if err != nil {
+		return nil, err
+	}
+	defer s.unlockShards(indices)
+	return m.GetMatchedPlayers(id)
+}
+
+func (s *ShardedMatcher) GetPlayerStatus(id PlayerId, currentTime Time) (*PlayerStatus, error) {
+	m, indices, err := s.lockPlayer(id)
+	if err != nil {
+		return nil, err
+	}
+	defer s.unlockShards(indices)
+	return m.GetPlayerStatus(id, currentTime)
+}
+
+func (s *ShardedMatcher) GetWaitTimeByScore(score PlayerScore) int {
+	shard := s.shards[s.shardIndex(score)]
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	return shard.matcher.GetWaitTimeByScore(score)
+}
+
+func (s *ShardedMatcher) GetWaitTimeEstimateByScore(score PlayerScore) WaitTimeEstimate {
+	shard := s.shards[s.shardIndex(score)]
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	return shard.matcher.GetWaitTimeEstimateByScore(score)
+}
+
+func (s *ShardedMatcher) Match(currentTime Time, count int) {
+	s.matchMu.Lock()
+	defer s.matchMu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, shard := range s.shards {
+		wg.Add(1)
+		go func(shard *matcherShard) {
+			shard.mu.Lock()
+			shard.matcher.Match(currentTime, count)
+			shard.mu.Unlock()
+			wg.Done()
+		}(shard)
+	}
+	wg.Wait()
+
+	for i := 0; i+1 < len(s.shards); i++ {
+		indices := []int{i, i + 1}
+		s.lockShards(indices)
+		s.matchBoundary(i, currentTime, count)
+		s.unlockShards(indices)
+	}
+}
+
+// 匹配分片 i 和 i+1 边界附近的玩家，按加入顺序依次尝试，与 Matcher.Match 的规则相同
+func (s *ShardedMatcher) matchBoundary(i int, currentTime Time, count int) {
+	a := s.shards[i].matcher
+	b := s.shards[i+1].matcher
+	boundary := s.shardLen * PlayerScore(i+1)
+	// 分片内的小组已经在各自的 Match 中处理过，这里处理跨分片的小组
+	a.expirePendingGroups(currentTime, b)
+	b.expirePendingGroups(currentTime, a)
+
+	seeds := make([]*Player, 0, a.playerQueue.GetCount()+b.playerQueue.GetCount())
+	for _, m := range []*Matcher{a, b} {
+		for _, node := range m.playerQueue.GetByRankRange(1, -1, false) {
+			seeds = append(seeds, node.Value.(*Player))
+		}
+	}
+	sort.Slice(seeds, func(i, j int) bool {
+		if seeds[i].JoinTime != seeds[j].JoinTime {
+			return seeds[i].JoinTime < seeds[j].JoinTime
+		}
+		return seeds[i].Id < seeds[j].Id
+	})
+
+	for _, p := range seeds {
+		if p.Group != nil {
+			continue
+		}
+		owner := a
+		if p.Score >= boundary {
+			owner = b
+		}
+		scoreRadius := owner.PlayerScoreRadius(p, currentTime)
+		if p.Score < boundary && p.Score+scoreRadius < boundary {
+			continue
+		}
+		if p.Score >= boundary && p.Score >= boundary+scoreRadius {
+			continue
+		}
+		candidates := append(a.collectCandidates(p, currentTime, scoreRadius, count), b.collectCandidates(p, currentTime, scoreRadius, count)...)
+		if len(candidates) < count {
+			continue
+		}
+		sort.Slice(candidates, func(i, j int) bool {
+			if candidates[i].JoinTime != candidates[j].JoinTime {
+				return candidates[i].JoinTime < candidates[j].JoinTime
+			}
+			di := scoreDistance(candidates[i].Score, p.Score)
+			dj := scoreDistance(candidates[j].Score, p.Score)
+			if di != dj {
+				return di < dj
+			}
+			return candidates[i].Id < candidates[j].Id
+		})
+		g := NewGroup(count)
+		copy(g.Players, candidates)
+		owner.addGroup(g)
+		a.takeGroupPlayers(g, currentTime)
+		b.takeGroupPlayers(g, currentTime)
+		owner.Events.Publish(Event{Type: EventGroupFormed, Time: currentTime, GroupId: g.Id, PlayerIds: g.PlayerIds()})
+		if owner.OnGroupMatchedEventCallback != nil {
+			owner.OnGroupMatchedEventCallback(g)
+		}
+	}
+}
+
+func scoreDistance(a PlayerScore, b PlayerScore) PlayerScore {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+func (s *ShardedMatcher) Sweep(before Time) {
+	s.matchMu.Lock()
+	defer s.matchMu.Unlock()
+	s.ownersMu.Lock()
+	defer s.ownersMu.Unlock()
+	// 跨分片的小组被清理时可能涉及其他分片，所以锁住全部分片
+	indices := make([]int, len(s.shards))
+	for i := range indices {
+		indices[i] = i
+	}
+	s.lockShards(indices)
+	defer s.unlockShards(indices)
+	for _, shard := range s.shards {
+		shard.matcher.sweep(before, s.otherMatchers(shard.matcher, indices)...)
+	}
+	for id, i := range s.owners {
+		m := s.shards[i].matcher
+		if _, err := m.GetPlayerState(id); err != nil {
+			delete(s.owners, id)
+		}
+	}
+}
+
+// 管理统计函数 ==========
+
+func (s *ShardedMatcher) PlayerCount() int {
+	sum := 0
+	s.Each(func(i int, m *Matcher) {
+		sum += m.PlayerCount()
+	})
+	return sum
+}
+
+func (s *ShardedMatcher) PlayerInQueueCount() int {
+	sum := 0
+	s.Each(func(i int, m *Matcher) {
+		sum += m.PlayerInQueueCount()
+	})
+	return sum
+}
+
+func (s *ShardedMatcher) GroupCount() int {
+	sum := 0
+	s.Each(func(i int, m *Matcher) {
+		sum += m.GroupCount()
+	})
+	return sum
+}
+
+// 管理统计函数结束 ==========
+
+// 各分片的分段累计加入人数之和
+func (s *ShardedMatcher) BandJoinCounts() []int {
+	var counts []int
+	s.Each(func(i int, m *Matcher) {
+		c := m.BandJoinCounts()
+		if counts == nil {
+			counts = c
+			return
+		}
+		for j := range c {
+			counts[j] += c[j]
+		}
+	})
+	return counts
+}
+
+// 各分片的误差统计按分段相加
+func (s *ShardedMatcher) PredictionStats() []PredictionStats {
+	var stats []PredictionStats
+	s.Each(func(i int, m *Matcher) {
+		p := m.PredictionStats()
+		if stats == nil {
+			stats = p
+			return
+		}
+		for j := range p {
+			stats[j].Merge(p[j])
+		}
+	})
+	return stats
+}
+
+func (s *ShardedMatcher) NextRadiusCrossing(after Time) (Time, bool) {
+	next := Time(0)
+	ok := false
+	s.Each(func(i int, m *Matcher) {
+		if t, ok2 := m.NextRadiusCrossing(after); ok2 && (!ok || t < next) {
+			next = t
+			ok = true
+		}
+	})
+	return next, ok
+}
@@ -0,0 +1,275 @@
+package matcher_test
+
+import (
+	"math/rand"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ganlvtech/go-game-matching/matcher"
+)
+
+func TestShardedMatcher_Boundary(t *testing.T) {
+	s := matcher.NewShardedMatcher(2, 180, 300, 10)
+	for i, score := range []matcher.PlayerScore{146, 148, 152, 154} {
+		if err := s.JoinQueue(matcher.PlayerId(strconv.Itoa(i)), 100, score); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.JoinQueue("0", 100, 10); err == nil {
+		t.Error("duplicate id across shards should be rejected")
+	}
+	s.Match(101, 4)
+	ids, err := s.GetMatchedPlayers("3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 4 {
+		t.Errorf("cross shard group = %v", ids)
+	}
+	if s.GroupCount() != 1 {
+		t.Errorf("group count = %d, want 1", s.GroupCount())
+	}
+	for i := 0; i < 4; i++ {
+		s.Remove(matcher.PlayerId(strconv.Itoa(i)))
+	}
+	if s.GroupCount() != 0 || s.PlayerCount() != 0 {
+		t.Errorf("group count = %d, player count = %d after remove", s.GroupCount(), s.PlayerCount())
+	}
+	state, err := s.GetPlayerStatus("2", 102)
+	if err != nil || state.State != matcher.PlayerStateRemoved {
+		t.Errorf("state = %v, %v", state, err)
+	}
+}
+
+func benchmarkShardedMatcher_Match(b *testing.B, shardCount int, concurrent int) {
+	const maxScore = 300
+	s := matcher.NewShardedMatcher(shardCount, 120, maxScore, 10)
+	var wg sync.WaitGroup
+	isRun := true
+
+	for i := 0; i < concurrent; i++ {
+		wg.Add(1)
+		go func(base int) {
+			defer wg.Done()
+			for id := base; isRun; id++ {
+				score := rand.Uint32() % maxScore
+				err := s.JoinQueue(matcher.PlayerId(strconv.Itoa(id)), matcher.Time(time.Now().Unix()), matcher.PlayerScore(score))
+				if err != nil {
+					panic(err)
+				}
+			}
+		}(i * 1000000)
+	}
+
+	for {
+		s.Match(matcher.Time(time.Now().Unix()), 25)
+		if s.PlayerCount()-s.PlayerInQueueCount() > b.N {
+			isRun = false
+			break
+		}
+		time.Sleep(time.Microsecond * 1000)
+	}
+	wg.Wait()
+}
+
+// -test.benchtime 10s
+func BenchmarkShardedMatcher_Match_4_1000(b *testing.B) {
+	benchmarkShardedMatcher_Match(b, 4, 1000)
+}
+
+func TestShardedMatcher_JoinWhileShardLocked(t *testing.T) {
+	s := matcher.NewShardedMatcher(2, 180, 300, 10)
+	done := make(chan error, 2)
+	s.Each(func(i int, m *matcher.Matcher) {
+		if i != 0 {
+			return
+		}
+		// 分片 0 被锁住时，加入分片 0 的请求等待，不影响加入分片 1 的请求
+		go func() {
+			done <- s.JoinQueue("a", 100, 10)
+		}()
+		time.Sleep(50 * time.Millisecond)
+		go func() {
+			done <- s.JoinQueue("b", 100, 200)
+		}()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Error(err)
+			}
+		case <-time.After(time.Second):
+			t.Error("join to another shard blocked by a locked shard")
+		}
+	})
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+	if s.PlayerCount() != 2 {
+		t.Errorf("player count = %d, want 2", s.PlayerCount())
+	}
+}
+
+func TestShardedMatcher_ConcurrentJoinSameId(t *testing.T) {
+	s := matcher.NewShardedMatcher(4, 180, 300, 10)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(score matcher.PlayerScore) {
+			defer wg.Done()
+			if s.JoinQueue("a", 100, score) == nil {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			}
+		}(matcher.PlayerScore(i * 15))
+	}
+	wg.Wait()
+	if succeeded != 1 || s.PlayerCount() != 1 {
+		t.Errorf("%d joins succeeded, player count = %d", succeeded, s.PlayerCount())
+	}
+}
+
+// 分片 0 中的 0 和 1，分片 1 中的 2 和 3 组成跨分片的小组，等待确认
+func newCrossShardPendingGroup(t *testing.T) *matcher.ShardedMatcher {
+	t.Helper()
+	s := matcher.NewShardedMatcher(2, 180, 300, 10)
+	s.Each(func(i int, m *matcher.Matcher) {
+		m.RequireAccept = true
+		m.AcceptTimeout = 10
+	})
+	for i, score := range []matcher.PlayerScore{146, 148, 152, 154} {
+		if err := s.JoinQueue(matcher.PlayerId(strconv.Itoa(i)), matcher.Time(100+i), score); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Match(110, 4)
+	for i := 0; i < 4; i++ {
+		assertShardedPlayerState(t, s, strconv.Itoa(i), matcher.PlayerStatePendingAccept)
+	}
+	return s
+}
+
+func assertShardedPlayerState(t *testing.T, s *matcher.ShardedMatcher, id string, want matcher.PlayerState) {
+	t.Helper()
+	status, err := s.GetPlayerStatus(matcher.PlayerId(id), 120)
+	if err != nil {
+		t.Fatalf("%s: %v", id, err)
+	}
+	if status.State != want {
+		t.Errorf("%s: state %v, want %v", id, status.State, want)
+	}
+}
+
+func TestShardedMatcher_CrossShardDecline(t *testing.T) {
+	s := newCrossShardPendingGroup(t)
+	_ = s.Accept("0")
+	// 分片 1 中的玩家拒绝，两个分片中的其他玩家都回到队列
+	if err := s.LeaveQueue("3"); err != nil {
+		t.Fatal(err)
+	}
+	assertShardedPlayerState(t, s, "3", matcher.PlayerStateLeft)
+	for _, id := range []string{"0", "1", "2"} {
+		assertShardedPlayerState(t, s, id, matcher.PlayerStateSearching)
+	}
+	if s.GroupCount() != 0 || s.PlayerInQueueCount() != 3 {
+		t.Errorf("group count %d, in queue %d", s.GroupCount(), s.PlayerInQueueCount())
+	}
+
+	// 回到队列的玩家可以再次跨分片匹配
+	_ = s.JoinQueue("4", 111, 150)
+	s.Match(112, 4)
+	if ids, err := s.GetMatchedPlayers("2"); err != nil || len(ids) != 4 {
+		t.Errorf("rematch %v, %v", ids, err)
+	}
+}
+
+func TestShardedMatcher_CrossShardAcceptTimeout(t *testing.T) {
+	s := newCrossShardPendingGroup(t)
+	_ = s.Accept("0")
+	_ = s.Accept("2")
+	s.Match(119, 4)
+	assertShardedPlayerState(t, s, "1", matcher.PlayerStatePendingAccept)
+	// 没有确认的玩家超时，确认过的玩家回到队列
+	s.Match(120, 4)
+	assertShardedPlayerState(t, s, "1", matcher.PlayerStateTimedOut)
+	assertShardedPlayerState(t, s, "3", matcher.PlayerStateTimedOut)
+	assertShardedPlayerState(t, s, "0", matcher.PlayerStateSearching)
+	assertShardedPlayerState(t, s, "2", matcher.PlayerStateSearching)
+	if s.GroupCount() != 0 || s.PlayerInQueueCount() != 2 {
+		t.Errorf("group count %d, in queue %d", s.GroupCount(), s.PlayerInQueueCount())
+	}
+}
+
+func TestShardedMatcher_CrossShardSweep(t *testing.T) {
+	s := newCrossShardPendingGroup(t)
+	// 只清理分片 0 中最早加入的玩家，小组在两个分片中都要解散
+	s.Sweep(101)
+	if _, err := s.GetPlayerStatus("0", 120); err == nil {
+		t.Error("swept player still exists")
+	}
+	for _, id := range []string{"1", "2", "3"} {
+		assertShardedPlayerState(t, s, id, matcher.PlayerStateSearching)
+	}
+	if s.GroupCount() != 0 || s.PlayerInQueueCount() != 3 {
+		t.Errorf("group count %d, in queue %d", s.GroupCount(), s.PlayerInQueueCount())
+	}
+}
+
+// 跨分片小组被解散、重新匹配的同时查询和离开，lockPlayer 需要在重新加锁后检查小组是否改变
+func TestShardedMatcher_LockPlayerWhileGroupChanges(t *testing.T) {
+	s := matcher.NewShardedMatcher(2, 180, 300, 10)
+	s.Each(func(i int, m *matcher.Matcher) {
+		m.RequireAccept = true
+		m.AcceptTimeout = 1
+	})
+	const count = 40
+	for i := 0; i < count; i++ {
+		_ = s.JoinQueue(matcher.PlayerId(strconv.Itoa(i)), 100, matcher.PlayerScore(146+i%8))
+	}
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for now := matcher.Time(100); now < 300; now++ {
+			s.Match(now, 4)
+		}
+		close(done)
+	}()
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(int64(w)))
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				id := matcher.PlayerId(strconv.Itoa(r.Intn(count)))
+				if _, err := s.GetMatchedPlayers(id); err == nil && r.Intn(4) == 0 {
+					_ = s.Accept(id)
+				}
+				_, _ = s.GetPlayerStatus(id, 100)
+			}
+		}(w)
+	}
+	wg.Wait()
+	// 状态一致：不存在指向已解散小组的玩家
+	for i := 0; i < count; i++ {
+		status, err := s.GetPlayerStatus(matcher.PlayerId(strconv.Itoa(i)), 300)
+		if err != nil {
+			continue
+		}
+		if status.State == matcher.PlayerStatePendingAccept {
+			if _, err := s.GetMatchedPlayers(matcher.PlayerId(strconv.Itoa(i))); err != nil {
+				t.Errorf("%d: state %v without group", i, status.State)
+			}
+		}
+	}
+}
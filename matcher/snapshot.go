@@ -0,0 +1,400 @@
+package matcher
+
+import (
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	json "github.com/json-iterator/go"
+	"github.com/wangjia184/sortedset"
+)
+
+// 快照格式版本，格式有不兼容的改动时增加
+//  1. 初始版本
+//  2. 增加自适应分数容忍区间的系数，版本 1 的快照恢复时系数重置为初始值
+const SnapshotVersion = 2
+
+// 快照中保存匹配器的全部状态，恢复后的匹配器与保存时完全一致，包括二维表中每个单元内的顺序
+type snapshot struct {
+	Version         int                      `json:"version"`
+	MaxTime         Time                     `json:"max_time"`
+	MaxScore        PlayerScore              `json:"max_score"`
+	ScoreGroupLen   int                      `json:"score_group_len"`
+	TimeUnit        time.Duration            `json:"time_unit,omitempty"` // 纳秒，旧快照没有这一项，为秒
+	CurrentTime     Time                     `json:"current_time"`
+	NextGroupId     GroupId                  `json:"next_group_id"`
+	AppliedSeq      uint64                   `json:"applied_seq,omitempty"`
+	MatchCursor     *queuePosition           `json:"match_cursor,omitempty"`
+	RadiusCurve     *RadiusCurve             `json:"radius_curve,omitempty"`
+	Queue           []snapshotPlayer         `json:"queue"`
+	Grid            []snapshotCell           `json:"grid"`
+	Groups          []snapshotGroup          `json:"groups"`
+	FinishedPlayers []snapshotFinishedPlayer `json:"finished_players"`
+	BandJoinCounts  []int                    `json:"band_join_counts"`
+	BandMatchCounts []int                    `json:"band_match_counts,omitempty"` // 旧快照没有这一项，为 0
+	Estimator       string                   `json:"estimator,omitempty"`         // 旧快照没有这一项，为 gaussian
+	WaitTime        json.RawMessage          `json:"wait_time"`                   // 预计等待时间算法的状态
+	AdaptiveRadius  *snapshotAdaptiveRadius  `json:"adaptive_radius,omitempty"`   // 未启用自适应分数容忍区间时为空
+	PredictionStats []PredictionStats        `json:"prediction_stats,omitempty"`  // 旧快照没有这一项，为 0
+	TimedOutCount   int                      `json:"timed_out_count,omitempty"`
+}
+
+type snapshotPlayer struct {
+	Id        PlayerId          `json:"id"`
+	JoinTime  Time              `json:"join_time"`
+	Score     PlayerScore       `json:"score"`
+	State     PlayerState       `json:"state"`
+	MatchTime Time              `json:"match_time,omitempty"`
+	Predicted *WaitTimeEstimate `json:"predicted,omitempty"`
+}
+
+type snapshotCell struct {
+	X   int        `json:"x"`
+	Y   int        `json:"y"`
+	Ids []PlayerId `json:"ids"`
+}
+
+type snapshotGroup struct {
+	Id       GroupId          `json:"id"`
+	Players  []snapshotPlayer `json:"players"`
+	Removed  []bool           `json:"removed"`
+	Accepted []bool           `json:"accepted"`
+}
+
+type snapshotFinishedPlayer struct {
+	Id         PlayerId    `json:"id"`
+	State      PlayerState `json:"state"`
+	Score      PlayerScore `json:"score"`
+	JoinTime   Time        `json:"join_time"`
+	FinishTime Time        `json:"finish_time"`
+}
+
+type snapshotWaitTime struct {
+	Groups                []float64 `json:"groups"`
+	GroupBuffers          []float64 `json:"group_buffers"`
+	GroupBufferItemCounts []int     `json:"group_buffer_item_counts"`
+	LastTime              float64   `json:"last_time"`
+}
+
+// 只保存系数的状态，MatchCount、TargetWaitTimes 等参数来自启动参数
+type snapshotAdaptiveRadius struct {
+	WaitFactors    []float64 `json:"wait_factors"`
+	DensityFactors []float64 `json:"density_factors"`
+	Populations    []int     `json:"populations"`
+	ArrivalRates   []float64 `json:"arrival_rates"`
+	LastJoinCounts []int     `json:"last_join_counts"`
+	LastTime       Time      `json:"last_time"`
+}
+
+func newSnapshotAdaptiveRadius(a *AdaptiveScoreRadius) *snapshotAdaptiveRadius {
+	return &snapshotAdaptiveRadius{
+		WaitFactors:    a.waitFactors,
+		DensityFactors: a.densityFactors,
+		Populations:    a.populations,
+		ArrivalRates:   a.arrivalRates,
+		LastJoinCounts: a.lastJoinCounts,
+		LastTime:       a.lastTime,
+	}
+}
+
+func (s *snapshotAdaptiveRadius) validate(count int) error {
+	if len(s.WaitFactors) != count || len(s.DensityFactors) != count || len(s.Populations) != count || len(s.ArrivalRates) != count || len(s.LastJoinCounts) != count {
+		return InvalidSnapshotError("adaptive radius band count mismatch")
+	}
+	return nil
+}
+
+func (s *snapshotAdaptiveRadius) restore(a *AdaptiveScoreRadius) {
+	a.waitFactors = s.WaitFactors
+	a.densityFactors = s.DensityFactors
+	a.populations = s.Populations
+	a.arrivalRates = s.ArrivalRates
+	a.lastJoinCounts = s.LastJoinCounts
+	a.lastTime = s.LastTime
+}
+
+func newSnapshotPlayer(p *Player) snapshotPlayer {
+	return snapshotPlayer{
+		Id:        p.Id,
+		JoinTime:  p.JoinTime,
+		Score:     p.Score,
+		State:     p.state,
+		MatchTime: p.matchTime,
+		Predicted: p.predicted,
+	}
+}
+
+func (m *Matcher) WriteSnapshot(w io.Writer) error {
+	s, err := m.newSnapshot()
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(s)
+}
+
+func (m *Matcher) newSnapshot() (*snapshot, error) {
+	s := &snapshot{
+		Version:         SnapshotVersion,
+		MaxTime:         m.maxTime,
+		MaxScore:        m.maxScore,
+		ScoreGroupLen:   m.timeScoreGrid.YGroupLen,
+		TimeUnit:        m.timeUnit,
+		CurrentTime:     m.currentTime,
+		NextGroupId:     m.nextGroupId,
+		AppliedSeq:      m.appliedSeq,
+		MatchCursor:     m.matchCursor,
+		RadiusCurve:     m.radiusCurve,
+		Queue:           make([]snapshotPlayer, 0, m.playerQueue.GetCount()),
+		Grid:            make([]snapshotCell, 0),
+		Groups:          make([]snapshotGroup, len(m.groups)),
+		FinishedPlayers: make([]snapshotFinishedPlayer, 0, len(m.finishedPlayers)),
+		BandJoinCounts:  m.bandJoinCounts,
+		BandMatchCounts: m.bandMatchCounts,
+		PredictionStats: m.predictionStats,
+		TimedOutCount:   m.timedOutCount,
+	}
+	if name := m.estimator.Name(); name != EstimatorGaussian {
+		s.Estimator = name
+	}
+	waitTime, err := m.estimator.MarshalState()
+	if err != nil {
+		return nil, err
+	}
+	s.WaitTime = waitTime
+	if m.adaptiveRadius != nil {
+		s.AdaptiveRadius = newSnapshotAdaptiveRadius(m.adaptiveRadius)
+	}
+	for _, node := range m.playerQueue.GetByRankRange(1, -1, false) {
+		s.Queue = append(s.Queue, newSnapshotPlayer(node.Value.(*Player)))
+	}
+	h := m.timeScoreGrid
+	for i := 0; i < h.XCount; i++ {
+		for j := 0; j < h.YCount; j++ {
+			if h.CellLen(i, j) == 0 {
+				continue
+			}
+			c := snapshotCell{X: i, Y: j, Ids: make([]PlayerId, 0, h.CellLen(i, j))}
+			for n := h.Front(i, j); n != nil; n = n.Next() {
+				c.Ids = append(c.Ids, n.Value.(*Player).Id)
+			}
+			s.Grid = append(s.Grid, c)
+		}
+	}
+	for i, g := range m.groups {
+		s.Groups[i] = snapshotGroup{
+			Id:       g.Id,
+			Players:  make([]snapshotPlayer, len(g.Players)),
+			Removed:  g.removed,
+			Accepted: g.accepted,
+		}
+		for j, p := range g.Players {
+			s.Groups[i].Players[j] = newSnapshotPlayer(p)
+		}
+	}
+	for id, f := range m.finishedPlayers {
+		s.FinishedPlayers = append(s.FinishedPlayers, snapshotFinishedPlayer{
+			Id:         id,
+			State:      f.state,
+			Score:      f.score,
+			JoinTime:   f.joinTime,
+			FinishTime: f.finishTime,
+		})
+	}
+	sort.Slice(s.FinishedPlayers, func(i, j int) bool {
+		return s.FinishedPlayers[i].Id < s.FinishedPlayers[j].Id
+	})
+	return s, nil
+}
+
+// 从快照恢复，会覆盖匹配器当前的全部状态，快照的配置必须与匹配器一致
+// ScoreRadiusFunc、回调函数和事件订阅者等不在快照中的字段保持不变
+func (m *Matcher) ReadSnapshot(r io.Reader) error {
+	s := &snapshot{}
+	if err := json.NewDecoder(r).Decode(s); err != nil {
+		return InvalidSnapshotError(err.Error())
+	}
+	if s.Version < 1 || s.Version > SnapshotVersion {
+		return InvalidSnapshotError("unsupported version " + strconv.Itoa(s.Version))
+	}
+	if s.TimeUnit == 0 {
+		s.TimeUnit = time.Second
+	}
+	if s.MaxTime != m.maxTime || s.MaxScore != m.maxScore || s.ScoreGroupLen != m.timeScoreGrid.YGroupLen || s.TimeUnit != m.timeUnit {
+		return InvalidSnapshotError("config mismatch")
+	}
+	if s.Estimator == "" {
+		s.Estimator = EstimatorGaussian
+	}
+	if s.Estimator != m.estimator.Name() {
+		return InvalidSnapshotError("estimator mismatch, snapshot uses " + s.Estimator)
+	}
+	h := m.timeScoreGrid
+	// 自适应系数影响匹配结果，快照和匹配器必须同时启用或同时不启用
+	if s.AdaptiveRadius != nil {
+		if m.adaptiveRadius == nil {
+			return InvalidSnapshotError("adaptive radius mismatch, snapshot uses adaptive radius")
+		}
+		if err := s.AdaptiveRadius.validate(h.YCount); err != nil {
+			return err
+		}
+	} else if m.adaptiveRadius != nil && s.Version >= 2 {
+		return InvalidSnapshotError("adaptive radius mismatch, snapshot does not use adaptive radius")
+	}
+	if len(s.BandJoinCounts) != h.YCount {
+		return InvalidSnapshotError("score group count mismatch")
+	}
+	if s.BandMatchCounts == nil {
+		s.BandMatchCounts = make([]int, h.YCount)
+	}
+	if len(s.BandMatchCounts) != h.YCount {
+		return InvalidSnapshotError("score group count mismatch")
+	}
+	if s.PredictionStats == nil {
+		s.PredictionStats = make([]PredictionStats, h.YCount)
+	}
+	if len(s.PredictionStats) != h.YCount {
+		return InvalidSnapshotError("score group count mismatch")
+	}
+
+	players := make(map[PlayerId]*Player, len(s.Queue))
+	playerQueue := sortedset.New()
+	for _, sp := range s.Queue {
+		if _, ok := players[sp.Id]; ok {
+			return PlayerAlreadyExistsError(sp.Id)
+		}
+		p, err := m.newPlayerFromSnapshot(sp)
+		if err != nil {
+			return err
+		}
+		players[p.Id] = p
+		playerQueue.AddOrUpdate(string(p.Id), sortedset.SCORE(p.JoinTime), p)
+	}
+
+	grid := NewGeoHash(h.XCount, h.YCount, h.XGroupLen, h.YGroupLen)
+	gridCount := 0
+	for _, c := range s.Grid {
+		if c.X < 0 || c.X >= h.XCount || c.Y < 0 || c.Y >= h.YCount {
+			return InvalidSnapshotError("grid cell out of range")
+		}
+		for _, id := range c.Ids {
+			p, ok := players[id]
+			if !ok {
+				return PlayerNotExistsError(id)
+			}
+			if h.GetXGroupIndex(p.gridX) != c.X || h.GetYGroupIndex(int(p.Score)) != c.Y {
+				return InvalidSnapshotError("player " + string(id) + " in wrong grid cell")
+			}
+			if p.gridNode != nil {
+				return InvalidSnapshotError("player " + string(id) + " appears twice in grid")
+			}
+			p.gridNode = grid.Add(p.gridX, int(p.Score), p)
+			gridCount++
+		}
+	}
+	if gridCount != len(players) {
+		return InvalidSnapshotError("grid does not match queue")
+	}
+
+	groups := make([]*Group, len(s.Groups))
+	groupIndex := make(map[GroupId]int, len(s.Groups))
+	for i, sg := range s.Groups {
+		count := len(sg.Players)
+		if len(sg.Removed) != count || len(sg.Accepted) != count {
+			return InvalidSnapshotError("group " + strconv.FormatUint(uint64(sg.Id), 10) + " is malformed")
+		}
+		g := NewGroup(count)
+		g.Id = sg.Id
+		g.owner = m
+		for j, sp := range sg.Players {
+			p, err := m.newPlayerFromSnapshot(sp)
+			if err != nil {
+				return err
+			}
+			p.Group = g
+			g.Players[j] = p
+			g.removed[j] = sg.Removed[j]
+			g.accepted[j] = sg.Accepted[j]
+			if g.removed[j] {
+				g.removedCount++
+				continue
+			}
+			if g.accepted[j] {
+				g.acceptedCount++
+			}
+			if _, ok := players[p.Id]; ok {
+				return PlayerAlreadyExistsError(p.Id)
+			}
+			players[p.Id] = p
+		}
+		if _, ok := groupIndex[g.Id]; ok {
+			return InvalidSnapshotError("duplicate group " + strconv.FormatUint(uint64(g.Id), 10))
+		}
+		groups[i] = g
+		groupIndex[g.Id] = i
+	}
+
+	finishedPlayers := make(map[PlayerId]*finishedPlayer, len(s.FinishedPlayers))
+	for _, f := range s.FinishedPlayers {
+		finishedPlayers[f.Id] = &finishedPlayer{
+			state:      f.State,
+			score:      f.Score,
+			joinTime:   f.JoinTime,
+			finishTime: f.FinishTime,
+		}
+	}
+
+	if s.RadiusCurve != nil {
+		if err := s.RadiusCurve.Validate(); err != nil {
+			return err
+		}
+	}
+	// 最后一个检查，失败时不会修改匹配器
+	if err := m.estimator.UnmarshalState(s.WaitTime); err != nil {
+		return err
+	}
+	if s.RadiusCurve != nil {
+		_ = m.SetRadiusCurve(s.RadiusCurve)
+	}
+	m.players = players
+	m.finishedPlayers = finishedPlayers
+	m.playerQueue = playerQueue
+	m.timeScoreGrid = grid
+	m.groups = groups
+	m.groupIndex = groupIndex
+	m.bandJoinCounts = s.BandJoinCounts
+	m.bandMatchCounts = s.BandMatchCounts
+	m.predictionStats = s.PredictionStats
+	m.timedOutCount = s.TimedOutCount
+	m.currentTime = s.CurrentTime
+	m.nextGroupId = s.NextGroupId
+	m.appliedSeq = s.AppliedSeq
+	m.matchCursor = s.MatchCursor
+	if m.adaptiveRadius != nil {
+		if s.AdaptiveRadius != nil {
+			s.AdaptiveRadius.restore(m.adaptiveRadius)
+		} else {
+			m.adaptiveRadius.reset()
+		}
+	}
+	return nil
+}
+
+// 分数和加入时间超出网格范围时返回 InvalidSnapshotError
+func (m *Matcher) newPlayerFromSnapshot(sp snapshotPlayer) (*Player, error) {
+	if sp.Score >= m.maxScore {
+		return nil, InvalidSnapshotError("player " + string(sp.Id) + " score out of range")
+	}
+	if sp.JoinTime < 0 {
+		return nil, InvalidSnapshotError("player " + string(sp.Id) + " join time out of range")
+	}
+	return &Player{
+		Id:        sp.Id,
+		JoinTime:  sp.JoinTime,
+		gridX:     m.timeToGridX(sp.JoinTime),
+		Score:     sp.Score,
+		state:     sp.State,
+		matchTime: sp.MatchTime,
+		predicted: sp.Predicted,
+	}, nil
+}
@@ -0,0 +1,88 @@
+package matcher_test
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ganlvtech/go-game-matching/matcher"
+)
+
+func newRandomMatcher(r *rand.Rand, until matcher.Time) *matcher.Matcher {
+	m := matcher.NewMatcher(180, 300, 10)
+	m.RequireAccept = true
+	id := 0
+	for t := matcher.Time(1000); t < until; t++ {
+		for i := 0; i < 20; i++ {
+			_ = m.JoinQueue(matcher.PlayerId(strconv.Itoa(id)), t, matcher.PlayerScore(r.Intn(300)))
+			id++
+		}
+		_ = m.LeaveQueue(matcher.PlayerId(strconv.Itoa(r.Intn(id))))
+		m.Remove(matcher.PlayerId(strconv.Itoa(r.Intn(id))))
+		_ = m.Accept(matcher.PlayerId(strconv.Itoa(r.Intn(id))))
+		m.Match(t, 5)
+	}
+	return m
+}
+
+func TestMatcher_Snapshot(t *testing.T) {
+	m := newRandomMatcher(rand.New(rand.NewSource(1)), 1300)
+	var b1 bytes.Buffer
+	if err := m.WriteSnapshot(&b1); err != nil {
+		t.Fatal(err)
+	}
+
+	m2 := matcher.NewMatcher(180, 300, 10)
+	m2.RequireAccept = true
+	if err := m2.ReadSnapshot(bytes.NewReader(b1.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	var b2 bytes.Buffer
+	if err := m2.WriteSnapshot(&b2); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b1.Bytes(), b2.Bytes()) {
+		t.Fatal("snapshot of restored matcher differs")
+	}
+
+	for i := 0; i < 10; i++ {
+		for _, x := range []*matcher.Matcher{m, m2} {
+			_ = x.JoinQueue(matcher.PlayerId("new"+strconv.Itoa(i)), 1300, matcher.PlayerScore(i*30))
+			x.Match(matcher.Time(1300+i), 5)
+		}
+	}
+	if !reflect.DeepEqual(m.GroupsPlayerIds(), m2.GroupsPlayerIds()) {
+		t.Error("restored matcher matched differently")
+	}
+
+	if err := matcher.NewMatcher(120, 300, 10).ReadSnapshot(bytes.NewReader(b1.Bytes())); err == nil {
+		t.Error("snapshot with different config should be rejected")
+	}
+}
+
+func TestMatcher_ReadSnapshotOutOfRange(t *testing.T) {
+	m := matcher.NewMatcher(180, 300, 10)
+	m.RequireAccept = true
+	_ = m.JoinQueue("a", 1000, 10)
+	_ = m.JoinQueue("b", 1000, 12)
+	m.Match(1000, 2)
+	_ = m.JoinQueue("c", 1001, 20)
+	var b bytes.Buffer
+	if err := m.WriteSnapshot(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range [][2]string{
+		{`"score":20`, `"score":300`},
+		{`"score":12`, `"score":5000`},
+		{`"join_time":1000,"score":10`, `"join_time":-1,"score":10`},
+	} {
+		s := strings.Replace(b.String(), c[0], c[1], 1)
+		err := matcher.NewMatcher(180, 300, 10).ReadSnapshot(strings.NewReader(s))
+		if _, ok := err.(matcher.InvalidSnapshotError); !ok {
+			t.Errorf("%s: got %v, want InvalidSnapshotError", c[1], err)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package matcher
+
+import "time"
+
+// Time 的单位，默认为秒
+// maxTime、ScoreRadiusFunc 的参数、等待时间、事件和玩家状态中的时间都使用这个单位，分数容忍区间曲线中的时间总是秒
+func (m *Matcher) TimeUnit() time.Duration {
+	return m.timeUnit
+}
+
+// 把时刻转换为 Time
+func (m *Matcher) TimeOf(t time.Time) Time {
+	return Time(t.UnixNano() / int64(m.timeUnit))
+}
+
+// 把时长转换为 Time
+func (m *Matcher) DurationToTime(d time.Duration) Time {
+	return Time(d / m.timeUnit)
+}
+
+// 把 Time 表示的时长转换为 time.Duration
+func (m *Matcher) TimeToDuration(t Time) time.Duration {
+	return time.Duration(t) * m.timeUnit
+}
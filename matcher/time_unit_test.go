@@ -0,0 +1,50 @@
+package matcher_test
+
+import (
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ganlvtech/go-game-matching/matcher"
+)
+
+// 毫秒为单位、所有时间乘以 1000 时，匹配结果应该与秒为单位完全相同
+func TestMatcher_TimeUnit(t *testing.T) {
+	curve, err := matcher.ParseRadiusCurve(`{"type":"linear","base":10,"rate":2,"max":150}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := matcher.NewMatcher(180, 300, 10)
+	ms := matcher.NewMatcherWithTimeUnit(180000, 300, 10, time.Millisecond)
+	for _, m := range []*matcher.Matcher{s, ms} {
+		if err := m.SetRadiusCurve(curve); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := rand.New(rand.NewSource(1))
+	id := 0
+	for tm := matcher.Time(1000); tm < 1100; tm++ {
+		for i := 0; i < 5; i++ {
+			score := matcher.PlayerScore(r.Intn(300))
+			_ = s.JoinQueue(matcher.PlayerId(strconv.Itoa(id)), tm, score)
+			_ = ms.JoinQueue(matcher.PlayerId(strconv.Itoa(id)), tm*1000, score)
+			id++
+		}
+		s.Match(tm, 5)
+		ms.Match(tm*1000, 5)
+	}
+	if s.GroupCount() == 0 {
+		t.Fatal("no group matched")
+	}
+	if !reflect.DeepEqual(s.GroupsPlayerIds(), ms.GroupsPlayerIds()) {
+		t.Error("millisecond matcher matched differently")
+	}
+	if w1, w2 := s.GetWaitTimeByScore(150), ms.GetWaitTimeByScore(150); w2/1000 != w1 {
+		t.Errorf("wait time %d ms, want about %d s", w2, w1)
+	}
+	if got := ms.TimeOf(time.Unix(1, 500*int64(time.Millisecond))); got != 1500 {
+		t.Errorf("TimeOf = %d, want 1500", got)
+	}
+}
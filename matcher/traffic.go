@@ -0,0 +1,311 @@
+package matcher
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	json "github.com/json-iterator/go"
+)
+
+// 流量记录格式版本
+const TrafficVersion = 1
+
+// 流量记录，每行一条 JSON 格式的 TrafficRecord，用于事后复现匹配器看到的全部操作
+//
+// 开始记录时先写一条 header，其中有创建匹配器需要的配置和当时的快照，之后每条是一个执行过的操作，
+// 包括执行时间、执行结果和 match 形成的小组。服务器重启后继续追加时会再写一条 header，重放时从新的快照开始
+type TrafficRecord struct {
+	Header     *TrafficHeader `json:"header,omitempty"`
+	At         int64          `json:"at,omitempty"` // 执行时的 unix 纳秒
+	*Operation                // 执行之后的操作，match 的 processed 为实际处理的人数
+	Error      string         `json:"error,omitempty"`
+	Groups     []TrafficGroup `json:"groups,omitempty"` // match 形成的小组
+}
+
+type TrafficHeader struct {
+	Version        int                    `json:"version"`
+	At             int64                  `json:"at"`
+	MaxTime        Time                   `json:"max_time"`
+	MaxScore       PlayerScore            `json:"max_score"`
+	ScoreGroupLen  int                    `json:"score_group_len"`
+	TimeUnit       time.Duration          `json:"time_unit"`
+	RequireAccept  bool                   `json:"require_accept,omitempty"`
+	AcceptTimeout  Time                   `json:"accept_timeout,omitempty"`
+	Estimator      string                 `json:"estimator,omitempty"` // 参数在快照中
+	AdaptiveRadius *TrafficAdaptiveRadius `json:"adaptive_radius,omitempty"`
+	Snapshot       json.RawMessage        `json:"snapshot"`
+}
+
+// 自适应分数容忍区间的配置，系数保存在快照中，从非空的匹配器开始记录时重放结果也相同
+// 旧的记录没有 MinFactor 等参数，为 0 时使用默认值
+type TrafficAdaptiveRadius struct {
+	MatchCount      int       `json:"match_count"`
+	TargetWaitTimes []float64 `json:"target_wait_times"`
+	MinFactor       float64   `json:"min_factor,omitempty"`
+	MaxFactor       float64   `json:"max_factor,omitempty"`
+	AdjustRate      float64   `json:"adjust_rate,omitempty"`
+	ArrivalSmooth   float64   `json:"arrival_smooth,omitempty"`
+}
+
+type TrafficGroup struct {
+	Id        GroupId    `json:"id"`
+	PlayerIds []PlayerId `json:"ids"`
+}
+
+func NewTrafficGroup(g *Group) TrafficGroup {
+	return TrafficGroup{Id: g.Id, PlayerIds: g.PlayerIds()}
+}
+
+// 按 header 中的配置和快照创建匹配器，启用了自适应分数容忍区间时同时返回
+func (h *TrafficHeader) NewMatcher() (*Matcher, *AdaptiveScoreRadius, error) {
+	if h.Version != TrafficVersion {
+		return nil, nil, InvalidTrafficError("unsupported version " + strconv.Itoa(h.Version))
+	}
+	m := NewMatcherWithTimeUnit(h.MaxTime, h.MaxScore, h.ScoreGroupLen, h.TimeUnit)
+	m.RequireAccept = h.RequireAccept
+	m.AcceptTimeout = h.AcceptTimeout
+	if h.Estimator != "" {
+		e, err := NewEstimator(h.Estimator, m)
+		if err != nil {
+			return nil, nil, err
+		}
+		_ = m.SetEstimator(e)
+	}
+	// 自适应系数保存在快照中，需要先启用再恢复快照
+	var a *AdaptiveScoreRadius
+	if h.AdaptiveRadius != nil {
+		a = NewAdaptiveScoreRadius(m, h.AdaptiveRadius.MatchCount, 0)
+		copy(a.TargetWaitTimes, h.AdaptiveRadius.TargetWaitTimes)
+		if h.AdaptiveRadius.MinFactor != 0 {
+			a.MinFactor = h.AdaptiveRadius.MinFactor
+		}
+		if h.AdaptiveRadius.MaxFactor != 0 {
+			a.MaxFactor = h.AdaptiveRadius.MaxFactor
+		}
+		if h.AdaptiveRadius.AdjustRate != 0 {
+			a.AdjustRate = h.AdaptiveRadius.AdjustRate
+		}
+		if h.AdaptiveRadius.ArrivalSmooth != 0 {
+			a.ArrivalSmooth = h.AdaptiveRadius.ArrivalSmooth
+		}
+		m.SetAdaptiveScoreRadius(a)
+	}
+	if err := m.ReadSnapshot(bytes.NewReader(h.Snapshot)); err != nil {
+		return nil, nil, err
+	}
+	return m, a, nil
+}
+
+// 执行操作，同时返回这次操作形成的小组
+func (m *Matcher) ApplyWithGroups(op *Operation) ([]*Group, error) {
+	lastGroupId := m.nextGroupId
+	err := m.Apply(op)
+	if m.nextGroupId == lastGroupId {
+		return nil, err
+	}
+	groups := make([]*Group, 0, m.nextGroupId-lastGroupId)
+	for id := lastGroupId + 1; id <= m.nextGroupId; id++ {
+		if g, ok := m.groupIndex[id]; ok {
+			groups = append(groups, m.groups[g])
+		}
+	}
+	return groups, err
+}
+
+// 记录流量，Apply 执行操作并写入记录
+//
+// 写入带缓冲，每次 match 之后和关闭时写入文件，写入失败不影响操作的执行，错误由 Err 和 Close 返回
+type TrafficRecorder struct {
+	mu    sync.Mutex
+	file  *os.File
+	w     *bufio.Writer
+	clock Clock
+	err   error
+}
+
+// 打开记录文件并追加一条 header，a 为匹配器使用的自适应分数容忍区间，没有则为 nil
+// 文件末尾写了一半的记录会被截断，否则新的 header 会接在它后面，导致这一行无法解析
+func OpenTrafficRecorder(path string, m *Matcher, a *AdaptiveScoreRadius, clock Clock) (*TrafficRecorder, error) {
+	// header 中的快照包含 m 使用的自适应系数，配置必须来自同一个对象
+	if a != m.adaptiveRadius {
+		return nil, InvalidTrafficError("adaptive radius mismatch")
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := truncateTornLine(file); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	if err := m.WriteSnapshot(buf); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	h := &TrafficHeader{
+		Version:       TrafficVersion,
+		At:            clock.Now().UnixNano(),
+		MaxTime:       m.maxTime,
+		MaxScore:      m.maxScore,
+		ScoreGroupLen: m.timeScoreGrid.YGroupLen,
+		TimeUnit:      m.timeUnit,
+		RequireAccept: m.RequireAccept,
+		AcceptTimeout: m.AcceptTimeout,
+		Estimator:     m.estimator.Name(),
+		Snapshot:      bytes.TrimSpace(buf.Bytes()),
+	}
+	if a != nil {
+		h.AdaptiveRadius = &TrafficAdaptiveRadius{
+			MatchCount:      a.MatchCount,
+			TargetWaitTimes: a.TargetWaitTimes,
+			MinFactor:       a.MinFactor,
+			MaxFactor:       a.MaxFactor,
+			AdjustRate:      a.AdjustRate,
+			ArrivalSmooth:   a.ArrivalSmooth,
+		}
+	}
+	r := &TrafficRecorder{
+		file:  file,
+		w:     bufio.NewWriter(file),
+		clock: clock,
+	}
+	r.write(&TrafficRecord{Header: h})
+	if r.err == nil {
+		r.err = r.w.Flush()
+	}
+	if r.err != nil {
+		_ = file.Close()
+		return nil, r.err
+	}
+	return r, nil
+}
+
+// 从文件末尾向前找到最后一个换行符，截断之后的内容，并把写入位置移到文件末尾
+func truncateTornLine(file *os.File) error {
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	end := size
+	buf := make([]byte, 4096)
+	for end > 0 {
+		start := end - int64(len(buf))
+		if start < 0 {
+			start = 0
+		}
+		chunk := buf[:end-start]
+		if _, err := file.ReadAt(chunk, start); err != nil {
+			return err
+		}
+		if i := bytes.LastIndexByte(chunk, '\n'); i >= 0 {
+			end = start + int64(i) + 1
+			break
+		}
+		end = start
+	}
+	if end == size {
+		return nil
+	}
+	if err := file.Truncate(end); err != nil {
+		return err
+	}
+	_, err = file.Seek(end, io.SeekStart)
+	return err
+}
+
+func (r *TrafficRecorder) write(rec *TrafficRecord) {
+	if r.err != nil {
+		return
+	}
+	b, err := json.Marshal(rec)
+	if err == nil {
+		_, err = r.w.Write(append(b, '\n'))
+	}
+	r.err = err
+}
+
+// 在 m 上执行操作并记录，返回操作本身的错误
+func (r *TrafficRecorder) Apply(m *Matcher, op *Operation) error {
+	_, err := r.ApplyWithGroups(m, op)
+	return err
+}
+
+// 与 Matcher.ApplyWithGroups 相同，同时写入记录
+func (r *TrafficRecorder) ApplyWithGroups(m *Matcher, op *Operation) ([]*Group, error) {
+	groups, err := m.ApplyWithGroups(op)
+	rec := &TrafficRecord{At: r.clock.Now().UnixNano(), Operation: op}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	if len(groups) > 0 {
+		rec.Groups = make([]TrafficGroup, len(groups))
+		for i, g := range groups {
+			rec.Groups[i] = NewTrafficGroup(g)
+		}
+	}
+	r.mu.Lock()
+	r.write(rec)
+	if op.Type == OperationMatch && r.err == nil {
+		r.err = r.w.Flush()
+	}
+	r.mu.Unlock()
+	return groups, err
+}
+
+// 第一个写入错误，出错之后的记录都会被丢弃
+func (r *TrafficRecorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+func (r *TrafficRecorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		r.err = r.w.Flush()
+	}
+	if err := r.file.Close(); err != nil && r.err == nil {
+		r.err = err
+	}
+	return r.err
+}
+
+// 按顺序读取流量记录，末尾写了一半的记录会被忽略
+type TrafficReader struct {
+	r    *bufio.Reader
+	line int
+}
+
+func NewTrafficReader(r io.Reader) *TrafficReader {
+	return &TrafficReader{r: bufio.NewReader(r)}
+}
+
+// 读取下一条记录，没有更多记录时返回 io.EOF
+func (r *TrafficReader) Next() (*TrafficRecord, error) {
+	line, err := r.r.ReadBytes('\n')
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.line++
+	rec := &TrafficRecord{}
+	if err := json.Unmarshal(line, rec); err != nil {
+		return nil, InvalidTrafficError("line " + strconv.Itoa(r.line) + ": " + err.Error())
+	}
+	if rec.Header == nil && rec.Operation == nil {
+		return nil, InvalidTrafficError("line " + strconv.Itoa(r.line) + ": neither header nor operation")
+	}
+	if r.line == 1 && rec.Header == nil {
+		return nil, InvalidTrafficError("missing header")
+	}
+	return rec, nil
+}
@@ -0,0 +1,205 @@
+package matcher_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ganlvtech/go-game-matching/matcher"
+)
+
+func TestTrafficRecorder_Replay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "traffic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "traffic.jsonl")
+
+	clock := matcher.NewVirtualClock(time.Unix(1600000000, 0))
+	m := matcher.NewMatcher(180, 300, 10)
+	m.MatchBudget = matcher.MatchBudget{MaxPlayers: 30}
+	a := matcher.NewAdaptiveScoreRadius(m, 5, 20)
+	m.SetAdaptiveScoreRadius(a)
+	rec, err := matcher.OpenTrafficRecorder(path, m, a, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := rand.New(rand.NewSource(3))
+	id := 0
+	for now := matcher.Time(1000); now < 1400; now++ {
+		clock.Advance(time.Second)
+		for i := 0; i < 8; i++ {
+			_ = rec.Apply(m, &matcher.Operation{Type: matcher.OperationJoin, Id: matcher.PlayerId(strconv.Itoa(id)), Time: now, Score: matcher.PlayerScore(r.Intn(300))})
+			id++
+		}
+		_ = rec.Apply(m, &matcher.Operation{Type: matcher.OperationLeave, Id: matcher.PlayerId(strconv.Itoa(r.Intn(id)))})
+		_ = rec.Apply(m, &matcher.Operation{Type: matcher.OperationMatch, Time: now, Count: 5})
+		if now == 1200 {
+			// 重启后继续追加，记录中会有第二个 header，崩溃时写了一半的记录被截断
+			if err := rec.Close(); err != nil {
+				t.Fatal(err)
+			}
+			appendTornLine(t, path)
+			m.SetAdaptiveScoreRadius(nil)
+			if rec, err = matcher.OpenTrafficRecorder(path, m, nil, clock); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	appendTornLine(t, path)
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	reader := matcher.NewTrafficReader(f)
+	var m2 *matcher.Matcher
+	headers, groups := 0, 0
+	for {
+		tr, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tr.Header != nil {
+			headers++
+			if m2, _, err = tr.Header.NewMatcher(); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		op := *tr.Operation
+		replayed, err := m2.ApplyWithGroups(&op)
+		if (err == nil) != (tr.Error == "") {
+			t.Fatalf("seq %d: recorded error %q, replayed %v", tr.Seq, tr.Error, err)
+		}
+		got := make([]matcher.TrafficGroup, len(replayed))
+		for i, g := range replayed {
+			got[i] = matcher.NewTrafficGroup(g)
+		}
+		if len(got) != len(tr.Groups) || (len(got) > 0 && !reflect.DeepEqual(got, tr.Groups)) {
+			t.Fatalf("groups differ at %v: recorded %v, replayed %v", tr.Operation, tr.Groups, got)
+		}
+		groups += len(got)
+	}
+	if headers != 2 || groups < 100 {
+		t.Fatalf("headers %d, groups %d", headers, groups)
+	}
+
+	var s1, s2 bytes.Buffer
+	_ = m.WriteSnapshot(&s1)
+	_ = m2.WriteSnapshot(&s2)
+	if s1.String() != s2.String() {
+		t.Error("replayed matcher differs from recorded matcher")
+	}
+}
+
+func appendTornLine(t *testing.T, path string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString(`{"at":1,"op":"jo`)
+	_ = f.Close()
+}
+
+func TestTrafficRecorder_AdaptiveState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "traffic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "traffic.jsonl")
+
+	clock := matcher.NewVirtualClock(time.Unix(1600000000, 0))
+	m := matcher.NewMatcher(180, 300, 10)
+	a := matcher.NewAdaptiveScoreRadius(m, 5, 20)
+	a.MaxFactor = 3
+	m.SetAdaptiveScoreRadius(a)
+	r := rand.New(rand.NewSource(5))
+	id := 0
+	run := func(rec *matcher.TrafficRecorder, from, to matcher.Time) {
+		for now := from; now < to; now++ {
+			for i := 0; i < 4; i++ {
+				op := &matcher.Operation{Type: matcher.OperationJoin, Id: matcher.PlayerId(strconv.Itoa(id)), Time: now, Score: matcher.PlayerScore(r.Intn(300))}
+				if rec != nil {
+					_ = rec.Apply(m, op)
+				} else {
+					_ = m.Apply(op)
+				}
+				id++
+			}
+			op := &matcher.Operation{Type: matcher.OperationMatch, Time: now, Count: 5}
+			if rec != nil {
+				_ = rec.Apply(m, op)
+			} else {
+				_ = m.Apply(op)
+			}
+		}
+	}
+	// 系数已经调整过之后才开始记录
+	run(nil, 1000, 1100)
+	if _, err := matcher.OpenTrafficRecorder(path, m, nil, clock); err == nil {
+		t.Error("recording started without the adaptive radius in use")
+	}
+	rec, err := matcher.OpenTrafficRecorder(path, m, a, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run(rec, 1100, 1200)
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	reader := matcher.NewTrafficReader(f)
+	tr, err := reader.Next()
+	if err != nil || tr.Header == nil {
+		t.Fatalf("header %v, err %v", tr, err)
+	}
+	m2, a2, err := tr.Header.NewMatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a2.MaxFactor != 3 || !reflect.DeepEqual(a.Stats()[0].TargetWaitTime, a2.Stats()[0].TargetWaitTime) {
+		t.Errorf("adaptive radius config differs %+v", a2)
+	}
+	for {
+		tr, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = m2.Apply(tr.Operation)
+	}
+	if !reflect.DeepEqual(a.Stats(), a2.Stats()) {
+		t.Errorf("factors differ after replay\n%+v\n%+v", a.Stats(), a2.Stats())
+	}
+	var s1, s2 bytes.Buffer
+	_ = m.WriteSnapshot(&s1)
+	_ = m2.WriteSnapshot(&s2)
+	if s1.String() != s2.String() {
+		t.Error("replayed matcher differs from recorded matcher")
+	}
+}
@@ -1,5 +1,9 @@
 package matcher
 
+import (
+	json "github.com/json-iterator/go"
+)
+
 const (
 	GaussianBlurMinIndex  = -2
 	GaussianBlurMaxIndex  = 2
@@ -86,3 +90,41 @@ func (w *WaitTime) Merge() {
 		}
 	}
 }
+
+func (w *WaitTime) Name() string {
+	return EstimatorGaussian
+}
+
+// 只有一个平均值，区间的上下限相同
+func (w *WaitTime) Estimate(groupIndex int) WaitTimeEstimate {
+	t := w.Groups[groupIndex]
+	return WaitTimeEstimate{Expected: t, Low: t, High: t}
+}
+
+func (w *WaitTime) GroupWaitTimes() []float64 {
+	return w.Groups
+}
+
+func (w *WaitTime) MarshalState() ([]byte, error) {
+	return json.Marshal(&snapshotWaitTime{
+		Groups:                w.Groups,
+		GroupBuffers:          w.groupBuffers,
+		GroupBufferItemCounts: w.groupBufferItemCounts,
+		LastTime:              w.lastTime,
+	})
+}
+
+func (w *WaitTime) UnmarshalState(data []byte) error {
+	s := &snapshotWaitTime{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return InvalidSnapshotError(err.Error())
+	}
+	if len(s.Groups) != w.groupCount || len(s.GroupBuffers) != w.groupCount || len(s.GroupBufferItemCounts) != w.groupCount {
+		return InvalidSnapshotError("score group count mismatch")
+	}
+	w.Groups = s.Groups
+	w.groupBuffers = s.GroupBuffers
+	w.groupBufferItemCounts = s.GroupBufferItemCounts
+	w.lastTime = s.LastTime
+	return nil
+}
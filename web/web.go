@@ -0,0 +1,9 @@
+// 监控页面，打包在可执行文件中，不依赖外部资源
+package web
+
+import (
+	_ "embed"
+)
+
+//go:embed index.html
+var IndexHTML []byte